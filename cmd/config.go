@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// flagKeys maps configuration keys to the names of the flags bound to them.
+var flagKeys = make(map[string]string)
+
+// bindFlag binds the flag of the root command to the configuration key.
+func bindFlag(flag string, key string) {
+	flagKeys[key] = flag
+	_ = viper.BindPFlag(key, rootCmd.Flags().Lookup(flag))
+}
+
+// fileKeys contains the configuration keys set from the defaults of the serve config file.
+var fileKeys = make(map[string]bool)
+
+// copySettings returns the effective global configuration as defaults of new settings,
+// which can be changed without affecting the global configuration.
+func copySettings() *viper.Viper {
+	settings := viper.New()
+	for key := range flagKeys {
+		settings.SetDefault(key, viper.Get(key))
+	}
+	return settings
+}
+
+// sensitiveKeys contains substrings of configuration keys whose values are redacted.
+var sensitiveKeys = []string{"authkey", "token", "password"}
+
+// logSettings logs all effective settings including where their value originates
+// from as a single line, redacting sensitive values.
+func logSettings(cmd *cobra.Command) {
+	keys := make([]string, 0, len(flagKeys))
+	for key := range flagKeys {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	settings := make([]string, 0, len(keys))
+	for _, key := range keys {
+		settings = append(settings, fmt.Sprintf("%s=%q(%s)", key, settingValue(key), settingSource(cmd, key)))
+	}
+
+	log.Printf("Effective settings: %s", strings.Join(settings, " "))
+}
+
+// settingValue returns the value of the configuration key, redacted if it is sensitive.
+func settingValue(key string) string {
+	value := fmt.Sprint(viper.Get(key))
+	if value == "" || value == "[]" {
+		return value
+	}
+
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return "<redacted>"
+		}
+	}
+
+	// Hide passwords of URLs such as proxy credentials.
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// settingSource returns where the value of the configuration key originates from.
+func settingSource(cmd *cobra.Command, key string) string {
+	if fileKeys[key] {
+		return "file"
+	}
+	if flag := cmd.Flags().Lookup(flagKeys[key]); flag != nil && flag.Changed {
+		return "flag"
+	}
+	if _, ok := os.LookupEnv(envKeyReplacer.Replace(strings.ToUpper(key))); ok {
+		return "env"
+	}
+	return "default"
+}
+
+// applyEnvSlices splits list settings given as environment variables at commas, the same
+// way as their flags, as viper would otherwise split them at whitespace.
+func applyEnvSlices(cmd *cobra.Command) {
+	for key, name := range flagKeys {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || flag.Value.Type() != "stringSlice" {
+			continue
+		}
+
+		value, ok := os.LookupEnv(envKeyReplacer.Replace(strings.ToUpper(key)))
+		if !ok {
+			continue
+		}
+
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		viper.Set(key, values)
+	}
+}
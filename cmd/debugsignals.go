@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+)
+
+// handleDebugSignals logs a goroutine dump and memory statistics on SIGQUIT until the
+// context is canceled, instead of Go's default of exiting with a dump. This allows
+// diagnosing a stuck proxy without losing the process.
+func handleDebugSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				logDiagnostics()
+			}
+		}
+	}()
+}
+
+// logDiagnostics logs the memory statistics and the stacks of all goroutines.
+func logDiagnostics() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Printf("Diagnostics: goroutines=%d heap_alloc=%d heap_inuse=%d heap_objects=%d sys=%d num_gc=%d",
+		runtime.NumGoroutine(), mem.HeapAlloc, mem.HeapInuse, mem.HeapObjects, mem.Sys, mem.NumGC)
+
+	var dump bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&dump, 2); err != nil {
+		log.Printf("Warning: failed to dump goroutines: %v", err)
+		return
+	}
+	log.Printf("Goroutine dump:\n%s", dump.String())
+}
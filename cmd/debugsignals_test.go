@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a buffer that may be written by the logger of other goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDebugSignals(t *testing.T) {
+	var buf lockedBuffer
+	output := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(output) })
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	handleDebugSignals(ctx)
+
+	// SIGQUIT would otherwise exit the test binary with a dump.
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGQUIT); err != nil {
+		t.Fatalf("failed to send SIGQUIT: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), "Goroutine dump:") {
+		if time.Now().After(deadline) {
+			t.Fatalf("log = %q, want a goroutine dump", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logs := buf.String()
+	if !strings.Contains(logs, "Diagnostics: goroutines=") {
+		t.Errorf("log = %q, want the memory statistics", logs)
+	}
+	// The dump includes the stacks of all goroutines, like the one of this test.
+	if !strings.Contains(logs, "cmd.TestDebugSignals") {
+		t.Errorf("log = %q, want the stack of the test goroutine", logs)
+	}
+}
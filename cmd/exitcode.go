@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes for the different classes of failures, so that supervisors and alerts
+// can tell the causes apart. They are documented in the README.
+const (
+	exitGeneral         = 1 // unclassified errors
+	exitConfig          = 2 // invalid flags or configuration
+	exitKubernetes      = 3 // Kubernetes client config, credentials or state store
+	exitTailscale       = 4 // Tailscale authentication or connection
+	exitStartupDeadline = 5 // the proxy did not become ready within the startup deadline
+	exitUpstream        = 6 // the upstream is not a reachable Kubernetes API server
+)
+
+// flushTimeout bounds the time flushing buffered telemetry may delay the exit.
+const flushTimeout = 5 * time.Second
+
+// flushers export buffered telemetry before the process exits.
+var (
+	flushersMu sync.Mutex
+	flushers   []func(ctx context.Context) error
+)
+
+// onExit registers a function flushing buffered telemetry before the process exits.
+func onExit(flush func(ctx context.Context) error) {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+	flushers = append(flushers, flush)
+}
+
+// flush runs the registered flushers within the flush timeout.
+func flush() {
+	flushersMu.Lock()
+	defer flushersMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	for _, fn := range flushers {
+		if err := fn(ctx); err != nil {
+			log.Printf("Warning: failed to flush before exit: %v", err)
+		}
+	}
+}
+
+// exitOnTermination exits with 0 on termination signals, after flushing buffered telemetry.
+func exitOnTermination(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-ctx.Done():
+		case <-signals:
+			flush()
+			logShutdown("terminated", 0, nil)
+			os.Exit(0)
+		}
+	}()
+}
+
+// exitCodeError annotates an error with the exit code of its failure class.
+type exitCodeError struct {
+	code   int
+	reason string
+	err    error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode wraps the error with the given message and exit code.
+func withExitCode(code int, err error, msg string) error {
+	return &exitCodeError{code: code, reason: msg, err: fmt.Errorf("%s: %w", msg, err)}
+}
+
+// exit logs why the proxy shuts down as the final log line and exits with the code of
+// the error. The reason is the failed operation and the cause the error chain leading
+// to it, so that the log line tells e.g. a lost Tailscale login and a startup timeout
+// apart without reading the preceding logs.
+func exit(err error) {
+	reason, cause := "error", err
+	var e *exitCodeError
+	if errors.As(err, &e) {
+		reason, cause = e.reason, errors.Unwrap(e.err)
+	}
+	flush()
+	logShutdown(reason, exitCode(err), cause)
+	os.Exit(exitCode(err))
+}
+
+// logShutdown logs the structured shutdown record.
+func logShutdown(reason string, code int, cause error) {
+	if cause == nil {
+		log.Printf("Shutting down reason=%q exit_code=%d", reason, code)
+		return
+	}
+	log.Printf("Shutting down reason=%q exit_code=%d cause=%q", reason, code, cause)
+}
+
+// exitCode returns the exit code for the error.
+func exitCode(err error) int {
+	var e *exitCodeError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitGeneral
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+)
+
+// subprocessEnv marks the test binary run by runSubprocess.
+const subprocessEnv = "TAILSCALE_KUBE_PROXY_TEST_SUBPROCESS"
+
+// inSubprocess reports whether the test runs in the subprocess started by runSubprocess.
+func inSubprocess() bool {
+	return os.Getenv(subprocessEnv) == "1"
+}
+
+// runSubprocess runs the test again in a subprocess, so that it may exit the process,
+// and returns its exit code and output.
+func runSubprocess(t *testing.T) (int, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), subprocessEnv+"=1")
+	output, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), string(output)
+	} else if err != nil {
+		t.Fatalf("failed to run subprocess: %v", err)
+	}
+	return 0, string(output)
+}
+
+func TestExitCode(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "unclassified", err: cause, want: exitGeneral},
+		{name: "classified", err: withExitCode(exitTailscale, cause, "failed to connect to Tailscale"), want: exitTailscale},
+		{name: "wrapped", err: fmt.Errorf("startup: %w", withExitCode(exitUpstream, cause, "failed to verify upstream")), want: exitUpstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExit(t *testing.T) {
+	if inSubprocess() {
+		exit(withExitCode(exitConfig, errors.New("unknown mode"), "invalid configuration"))
+		return
+	}
+
+	code, output := runSubprocess(t)
+	if code != exitConfig {
+		t.Errorf("exit code = %d, want %d", code, exitConfig)
+	}
+	want := `Shutting down reason="invalid configuration" exit_code=2 cause="unknown mode"`
+	if !strings.Contains(output, want) {
+		t.Errorf("output = %q, want the line %q", output, want)
+	}
+}
+
+func TestExitReasons(t *testing.T) {
+	tests := []struct {
+		name     string
+		shutdown func(t *testing.T)
+		wantCode int
+		want     string
+	}{
+		{
+			name: "signal",
+			shutdown: func(t *testing.T) {
+				exitOnTermination(context.Background())
+				_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				time.Sleep(5 * time.Second)
+			},
+			wantCode: 0,
+			want:     `Shutting down reason="terminated" exit_code=0`,
+		},
+		{
+			name: "watchdog",
+			shutdown: func(t *testing.T) {
+				startWatchdog(10 * time.Millisecond)
+				time.Sleep(5 * time.Second)
+			},
+			wantCode: exitStartupDeadline,
+			want:     `Shutting down reason="startup deadline exceeded" exit_code=5 cause="proxy did not become ready within 10ms"`,
+		},
+		{
+			name: "startup deadline",
+			shutdown: func(t *testing.T) {
+				err := withExitCode(exitStartupDeadline, context.DeadlineExceeded, "startup deadline exceeded")
+				exit(fmt.Errorf("startup: %w", err))
+			},
+			wantCode: exitStartupDeadline,
+			want:     `Shutting down reason="startup deadline exceeded" exit_code=5 cause="context deadline exceeded"`,
+		},
+		{
+			name: "flag error",
+			shutdown: func(t *testing.T) {
+				rootCmd.SetArgs([]string{"--no-such-flag"})
+				Execute()
+			},
+			wantCode: exitConfig,
+			want:     `Shutting down reason="invalid flags" exit_code=2 cause="unknown flag: --no-such-flag"`,
+		},
+		{
+			name: "upstream failure",
+			shutdown: func(t *testing.T) {
+				testutil.Configure(t, nil)
+				upstream := httptest.NewServer(http.NotFoundHandler())
+				server, err := newProxy(viper.GetViper(), &rest.Config{Host: upstream.URL}, testutil.NewStaticResolver())
+				if err != nil {
+					t.Fatalf("newProxy() error = %v", err)
+				}
+				if err = server.VerifyUpstream(context.Background()); err != nil {
+					exit(withExitCode(exitUpstream, err, "failed to verify upstream"))
+				}
+			},
+			wantCode: exitUpstream,
+			want:     `Shutting down reason="failed to verify upstream" exit_code=6 cause=`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if inSubprocess() {
+				tt.shutdown(t)
+				return
+			}
+
+			code, output := runSubprocess(t)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if !strings.Contains(output, tt.want) {
+				t.Errorf("output = %q, want the line %q", output, tt.want)
+			}
+		})
+	}
+}
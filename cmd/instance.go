@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+// instance is a proxy served as its own Tailscale node.
+type instance struct {
+	ts     *tailscale.Server
+	server *proxy.ReverseProxy
+}
+
+// newInstance creates the state store, Tailscale node and proxy configured by the
+// settings. The node must be closed by the caller.
+func newInstance(settings *viper.Viper, config *rest.Config) (*instance, error) {
+	// initialize state store
+	var store ipn.StateStore
+	if secretName := settings.GetString("secret_name"); secretName != "" {
+		log.Printf("Using Kubernetes secret state store %s", secretName)
+		nsBytes, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+		if err != nil {
+			return nil, withExitCode(exitKubernetes, err, "failed to read namespace")
+		}
+
+		if store, err = newSecretStore(settings, string(nsBytes), secretName, config); err != nil {
+			return nil, err
+		}
+	}
+
+	// initialize tailscale server
+	ts, err := tailscale.NewServerWithSettings(settings, store)
+	if errors.Is(err, tailscale.ErrInvalidConfig) {
+		return nil, withExitCode(exitConfig, err, "failed to create server")
+	} else if err != nil {
+		return nil, withExitCode(exitTailscale, err, "failed to create server")
+	}
+
+	// initialize proxy
+	server, err := newProxy(settings, config, ts)
+	if err != nil {
+		_ = ts.Close()
+		return nil, err
+	}
+	return &instance{ts: ts, server: server}, nil
+}
+
+// newSecretStore creates the state store in the Kubernetes secret. If that fails and the
+// fallback is enabled, the state is kept in memory instead.
+func newSecretStore(settings *viper.Viper, namespace string, secretName string, config *rest.Config) (ipn.StateStore, error) {
+	store, err := tailscale.NewKubernetesStoreWithSettings(settings, namespace, secretName, config)
+	if errors.Is(err, tailscale.ErrInvalidConfig) {
+		return nil, withExitCode(exitConfig, err, "failed to create store")
+	} else if err != nil && settings.GetBool("fallback_ephemeral") {
+		// Without persisted state the node can't keep its identity, so it is registered
+		// as ephemeral node to be removed from the tailnet once it goes offline.
+		log.Printf("Warning: failed to create store, falling back to an ephemeral node: %v", err)
+		settings.Set("ts.ephemeral", true)
+		return new(mem.Store), nil
+	} else if err != nil {
+		return nil, withExitCode(exitKubernetes, err, "failed to create store")
+	}
+	return store, nil
+}
+
+// newProxy creates the proxy configured by the settings, classifying its errors.
+func newProxy(settings *viper.Viper, config *rest.Config, identities proxy.IdentityResolver) (*proxy.ReverseProxy, error) {
+	server, err := proxy.NewKubeProxyWithSettings(settings, config, identities)
+	if errors.Is(err, proxy.ErrInvalidConfig) {
+		return nil, withExitCode(exitConfig, err, "failed to create proxy")
+	} else if err != nil {
+		return nil, withExitCode(exitKubernetes, err, "failed to create proxy")
+	}
+	return server, nil
+}
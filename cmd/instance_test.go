@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/spf13/viper"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestSecretStoreFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+	}{
+		{name: "fallback", fallback: true},
+		{name: "no fallback", fallback: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			output := log.Writer()
+			log.SetOutput(&buf)
+			t.Cleanup(func() { log.SetOutput(output) })
+
+			// RBAC for the secret is not applied yet.
+			api := testutil.NewFakeAPIServer(t)
+			api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+			}))
+			settings := viper.New()
+			settings.Set("fallback_ephemeral", tt.fallback)
+
+			store, err := newSecretStore(settings, "proxy", "tailscale-state", api.Config())
+			if !tt.fallback {
+				if exitCode(err) != exitKubernetes {
+					t.Errorf("newSecretStore() error = %v, want exit code %d", err, exitKubernetes)
+				}
+				if settings.GetBool("ts.ephemeral") {
+					t.Error("node is ephemeral without the fallback")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("newSecretStore() error = %v", err)
+			}
+			if _, ok := store.(*mem.Store); !ok {
+				t.Errorf("store = %T, want an in-memory store", store)
+			}
+			if !settings.GetBool("ts.ephemeral") {
+				t.Error("node is not ephemeral after the fallback")
+			}
+			if !strings.Contains(buf.String(), "Warning: failed to create store, falling back to an ephemeral node") {
+				t.Errorf("log = %q, want a warning about the fallback", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+)
+
+// handleMaintenanceSignal toggles maintenance mode on SIGUSR2 until the context is
+// canceled, for environments without access to the management server.
+func handleMaintenanceSignal(ctx context.Context, m management.Maintainer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				enabled, _ := m.Maintenance()
+				m.SetMaintenance(!enabled, "")
+			}
+		}
+	}()
+}
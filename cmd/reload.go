@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloader is implemented by the proxy to apply changed configuration files.
+type reloader interface {
+	Reload() error
+}
+
+// handleReloadSignal reloads the proxy on SIGHUP until the context is canceled.
+func handleReloadSignal(ctx context.Context, r reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := r.Reload(); err != nil {
+					log.Printf("Warning: failed to reload, keeping the previous configuration: %v", err)
+				} else {
+					log.Println("Reloaded known principals and dropped cached identities")
+				}
+			}
+		}
+	}()
+}
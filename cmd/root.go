@@ -4,17 +4,25 @@ Copyright © 2026 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/podinfo"
 	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/client-go/rest"
-	"tailscale.com/ipn"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -32,10 +40,13 @@ in-cluster resources.`,
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		exit(err)
 	}
 }
 
+// envKeyReplacer maps configuration keys to the names of environment variables.
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -46,67 +57,456 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().String("secret-name", "", "Name of the Kubernetes secret to store Tailscale state")
-	_ = viper.BindPFlag("secret_name", rootCmd.Flags().Lookup("secret-name"))
+	bindFlag("secret-name", "secret_name")
+
+	rootCmd.Flags().Bool("fallback-ephemeral", false, "Run as ephemeral node with in-memory state if the state secret can't be used")
+	bindFlag("fallback-ephemeral", "fallback_ephemeral")
+
+	rootCmd.Flags().Int("state-size-warn-bytes", 512<<10, "Log a warning if the Tailscale state in the secret exceeds this size (0 = disabled)")
+	bindFlag("state-size-warn-bytes", "state_size_warn_bytes")
+
+	rootCmd.Flags().StringSlice("state-secret-labels", nil, "Additional labels set on the state secret as key=value pairs")
+	bindFlag("state-secret-labels", "state_secret_labels")
+
+	rootCmd.Flags().Duration("client-init-timeout", 30*time.Second, "Time to retry loading the state secret if the Kubernetes API is unavailable at startup (0 to disable retries)")
+	bindFlag("client-init-timeout", "client_init_timeout")
 
 	rootCmd.Flags().String("hostname", "kube-proxy", "Hostname to use for the Tailscale node")
-	_ = viper.BindPFlag("ts.hostname", rootCmd.Flags().Lookup("hostname"))
+	bindFlag("hostname", "ts.hostname")
 
 	rootCmd.Flags().String("authkey", "", "Tailscale authentication key")
-	_ = viper.BindPFlag("ts.authkey", rootCmd.Flags().Lookup("authkey"))
+	bindFlag("authkey", "ts.authkey")
+
+	rootCmd.Flags().StringSlice("authkeys", nil, "Additional Tailscale authentication keys tried in order if login with the previous key fails")
+	bindFlag("authkeys", "ts.authkeys")
 
 	rootCmd.Flags().String("control-url", "", "Custom Tailscale control URL (e.g. for Headscale)")
-	_ = viper.BindPFlag("ts.control_url", rootCmd.Flags().Lookup("control-url"))
+	bindFlag("control-url", "ts.control_url")
 
 	rootCmd.Flags().Bool("ephemeral", false, "Whether to use an ephemeral Tailscale node")
-	_ = viper.BindPFlag("ts.ephemeral", rootCmd.Flags().Lookup("ephemeral"))
+	bindFlag("ephemeral", "ts.ephemeral")
+
+	rootCmd.Flags().String("address-family", tailscale.AddressFamilyBoth, "Tailscale IPs to listen on (both, ipv4 or ipv6)")
+	bindFlag("address-family", "ts.address_family")
+
+	rootCmd.Flags().Int("local-api-attempts", 3, "Attempts of Tailscale local API calls failing with transient errors, e.g. to identify users (1 = no retries)")
+	bindFlag("local-api-attempts", "ts.local_api_attempts")
+
+	rootCmd.Flags().String("expected-tailnet", "", "Name or MagicDNS suffix of the tailnet the node must join, exits otherwise")
+	bindFlag("expected-tailnet", "ts.expected_tailnet")
+
+	rootCmd.Flags().String("username-source", "login", "Tailscale profile field used as Kubernetes username (login, displayName or id)")
+	bindFlag("username-source", "username_source")
+
+	rootCmd.Flags().Duration("whois-timeout", 5*time.Second, "Time resolving the Tailscale user of a request may take before it is rejected with 503 (0 = unlimited)")
+	bindFlag("whois-timeout", "whois_timeout")
+
+	rootCmd.Flags().Duration("identity-cache-ttl", 0, "How long resolved identities are cached per client IP (0 = disabled)")
+	bindFlag("identity-cache-ttl", "identity_cache_ttl")
+
+	rootCmd.Flags().Int("max-tracked-users", 4096, "Maximum number of users and clients kept in per-user caches and counters, evicting the least recently seen (0 = unlimited)")
+	bindFlag("max-tracked-users", "max_tracked_users")
+
+	rootCmd.Flags().Bool("connection-identity", false, "Resolve the Tailscale identity once per connection instead of per request")
+	bindFlag("connection-identity", "connection_identity")
+
+	rootCmd.Flags().Duration("max-conn-lifetime", 0, "Close client connections after this age, cutting watches so that clients reconnect and are identified again (0 = unlimited)")
+	bindFlag("max-conn-lifetime", "max_conn_lifetime")
+
+	rootCmd.Flags().String("identity-cel", "", "CEL expression deriving the Kubernetes user and groups from the Tailscale user, tags and capabilities")
+	bindFlag("identity-cel", "identity_cel")
+
+	rootCmd.Flags().String("identity-mapper-url", "", "URL of an HTTP service resolving the Kubernetes user and groups of a Tailscale login")
+	bindFlag("identity-mapper-url", "identity_mapper_url")
+
+	rootCmd.Flags().Bool("identity-mapper-fail-open", false, "Use the Tailscale identity if the identity mapper is unavailable instead of the unidentified user")
+	bindFlag("identity-mapper-fail-open", "identity_mapper_fail_open")
+
+	rootCmd.Flags().Duration("identity-mapper-cache-ttl", time.Minute, "Time to cache identities returned by the identity mapper (0 to disable)")
+	bindFlag("identity-mapper-cache-ttl", "identity_mapper_cache_ttl")
+
+	rootCmd.Flags().StringSlice("default-groups", nil, "Groups impersonated for identified users if no groups were derived")
+	bindFlag("default-groups", "default_groups")
+
+	rootCmd.Flags().Int("max-groups", 0, "Maximum number of impersonated groups per request (0 = unlimited)")
+	bindFlag("max-groups", "max_groups")
+
+	rootCmd.Flags().Int("max-header-bytes", http.DefaultMaxHeaderBytes, "Maximum size of request headers in bytes")
+	bindFlag("max-header-bytes", "max_header_bytes")
+
+	rootCmd.Flags().String("known-principals-file", "", "File listing the users and groups that may be impersonated, one 'user:<name>' or 'group:<name>' per line")
+	bindFlag("known-principals-file", "known_principals_file")
+
+	rootCmd.Flags().StringSlice("tag-namespace", nil, "Confine nodes with a tag to a namespace, as tag:<name>=<namespace> pairs")
+	bindFlag("tag-namespace", "tag_namespaces")
+
+	rootCmd.Flags().String("unidentified-user", "system:anonymous", "User to impersonate for requests without a resolvable Tailscale identity")
+	bindFlag("unidentified-user", "unidentified_user")
+
+	rootCmd.Flags().String("help-page", "", "HTML file shown to unidentified browser users instead of the built-in help page")
+	bindFlag("help-page", "help_page")
+
+	rootCmd.Flags().String("root-response", "forward", "Response to requests of the root path, forward to the Kubernetes API or info about the proxy")
+	bindFlag("root-response", "root_response")
+
+	rootCmd.Flags().StringSlice("allowed-source-cidrs", nil, "CIDRs of the client addresses accepted by the proxy (default all)")
+	bindFlag("allowed-source-cidrs", "allowed_source_cidrs")
+
+	rootCmd.Flags().StringSlice("allow-methods", []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, "HTTP methods accepted by the proxy")
+	bindFlag("allow-methods", "allow_methods")
+
+	rootCmd.Flags().StringSlice("allow-paths", nil, "Regular expressions of API paths accessible through the proxy (default all)")
+	bindFlag("allow-paths", "allow_paths")
+
+	rootCmd.Flags().StringSlice("deny-paths", nil, "Regular expressions of API paths never accessible through the proxy, regardless of RBAC")
+	bindFlag("deny-paths", "deny_paths")
+
+	rootCmd.Flags().StringSlice("block-subresources", nil, "Subresources denied to principals, as <principals>=<resource>/<subresource> pairs with principals joined by & (all) or | (any)")
+	bindFlag("block-subresources", "block_subresources")
+
+	rootCmd.Flags().Bool("maintenance", false, "Start in maintenance mode, rejecting all requests with the maintenance message")
+	bindFlag("maintenance", "maintenance")
+
+	rootCmd.Flags().String("maintenance-message", "the cluster is under maintenance, please try again later", "Message returned to clients in maintenance mode")
+	bindFlag("maintenance-message", "maintenance_message")
+
+	rootCmd.Flags().Bool("coalesce-requests", false, "Share one upstream request among identical concurrent discovery requests of the same user")
+	bindFlag("coalesce-requests", "coalesce_requests")
+
+	rootCmd.Flags().StringSlice("timeout-rule", nil, "Timeouts of requests per verb and path, as <verb>[:<path pattern>]=<duration> (first match applies)")
+	bindFlag("timeout-rule", "timeout_rules")
+
+	rootCmd.Flags().Int("max-in-flight", 0, "Maximum number of concurrent read-only requests (0 = unlimited)")
+	bindFlag("max-in-flight", "max_in_flight")
+
+	rootCmd.Flags().Int("max-mutating-in-flight", 0, "Maximum number of concurrent mutating requests (0 = unlimited)")
+	bindFlag("max-mutating-in-flight", "max_mutating_in_flight")
+
+	rootCmd.Flags().Duration("max-in-flight-wait", time.Second, "How long a request waits for a free slot before being rejected")
+	bindFlag("max-in-flight-wait", "max_in_flight_wait")
+
+	rootCmd.Flags().String("strip-path-prefix", "", "Path prefix to remove from incoming requests before forwarding")
+	bindFlag("strip-path-prefix", "strip_path_prefix")
+
+	rootCmd.Flags().Bool("require-path-prefix", false, "Reject requests outside of the strip path prefix instead of forwarding them unchanged")
+	bindFlag("require-path-prefix", "require_path_prefix")
+
+	rootCmd.Flags().String("upstream-path-prefix", "", "Path prefix to add to requests forwarded to the Kubernetes API")
+	bindFlag("upstream-path-prefix", "upstream_path_prefix")
+
+	rootCmd.Flags().Bool("front-proxy-mode", false, "Identify users with authenticating proxy headers instead of impersonation")
+	bindFlag("front-proxy-mode", "front_proxy.enabled")
+
+	rootCmd.Flags().String("front-proxy-cert", "", "Client certificate file trusted by the API server's requestheader authenticator")
+	bindFlag("front-proxy-cert", "front_proxy.cert_file")
+
+	rootCmd.Flags().String("front-proxy-key", "", "Key file of the front proxy client certificate")
+	bindFlag("front-proxy-key", "front_proxy.key_file")
+
+	rootCmd.Flags().String("front-proxy-user-header", "X-Remote-User", "Header carrying the username in front proxy mode")
+	bindFlag("front-proxy-user-header", "front_proxy.user_header")
+
+	rootCmd.Flags().String("front-proxy-group-header", "X-Remote-Group", "Header carrying the groups in front proxy mode")
+	bindFlag("front-proxy-group-header", "front_proxy.group_header")
+
+	rootCmd.Flags().String("front-proxy-extra-header-prefix", "X-Remote-Extra-", "Prefix of extra headers stripped from clients in front proxy mode")
+	bindFlag("front-proxy-extra-header-prefix", "front_proxy.extra_header_prefix")
+
+	rootCmd.Flags().Bool("discover-api-endpoints", false, "Spread connections across the endpoints of the default/kubernetes service instead of its cluster IP")
+	bindFlag("discover-api-endpoints", "discover_api_endpoints")
+
+	rootCmd.Flags().String("api-url", "", "URL of the Kubernetes API to forward to, including unix:///path/to/socket, or unix+http:// for plain HTTP (default from the in-cluster config)")
+	bindFlag("api-url", "api_url")
+
+	rootCmd.Flags().Bool("verify-upstream", true, "Verify on startup that the upstream responds like a Kubernetes API server")
+	bindFlag("verify-upstream", "verify_upstream")
+
+	rootCmd.Flags().String("upstream-server-name", "", "Server name to verify the Kubernetes API certificate against (default host of the API URL)")
+	bindFlag("upstream-server-name", "upstream_server_name")
+
+	rootCmd.Flags().String("client-authorization", "strip", "Handling of the client's Authorization header: strip, header (move to --client-authorization-header) or forward")
+	bindFlag("client-authorization", "client_authorization")
+
+	rootCmd.Flags().String("client-authorization-header", "X-Forwarded-Authorization", "Header carrying the client's Authorization header in header mode")
+	bindFlag("client-authorization-header", "client_authorization_header")
+
+	rootCmd.Flags().StringSlice("forward-client-headers", nil, "Client headers forwarded to the Kubernetes API if unknown headers are stripped")
+	bindFlag("forward-client-headers", "forward_client_headers")
+
+	rootCmd.Flags().Bool("strip-unknown-headers", false, "Strip client headers that are neither needed by the protocol nor listed in --forward-client-headers")
+	bindFlag("strip-unknown-headers", "strip_unknown_headers")
+
+	rootCmd.Flags().Bool("send-proxy-protocol", false, "Send a PROXY protocol v2 header on connections to the Kubernetes API")
+	bindFlag("send-proxy-protocol", "send_proxy_protocol")
+
+	rootCmd.Flags().String("upstream-proxy", "", "URL of an HTTP proxy to reach the Kubernetes API through (default from HTTPS_PROXY and NO_PROXY)")
+	bindFlag("upstream-proxy", "upstream_proxy")
+
+	rootCmd.Flags().String("cluster-dns", "", "Address of the DNS server used to resolve the Kubernetes API host (default system resolver)")
+	bindFlag("cluster-dns", "cluster_dns")
+
+	rootCmd.Flags().Duration("startup-deadline", 0, "Exit if the proxy is not ready within this duration (0 = disabled)")
+	bindFlag("startup-deadline", "startup_deadline")
+
+	rootCmd.Flags().String("tsnet-dir", "", "Writable directory for Tailscale runtime files (default in the user config directory)")
+	bindFlag("tsnet-dir", "ts.dir")
+
+	rootCmd.Flags().Int("auto-reauth", 0, "Maximum attempts to re-authenticate with the auth key when the node needs login (0 = disabled)")
+	bindFlag("auto-reauth", "ts.auto_reauth")
+
+	rootCmd.Flags().String("ts-log-level", "info", "Log level of the Tailscale node (info or debug)")
+	bindFlag("ts-log-level", "ts.log_level")
+
+	rootCmd.Flags().Duration("tcp-keepalive", 0, "Interval of TCP keep-alives on client connections (0 = disabled)")
+	bindFlag("tcp-keepalive", "tcp_keepalive")
+
+	rootCmd.Flags().Int("max-conns", 0, "Maximum number of concurrent client connections, closing connections over the limit (0 = unlimited)")
+	bindFlag("max-conns", "max_conns")
+
+	rootCmd.Flags().Duration("watch-keepalive", 30*time.Second, "Interval of pings on idle HTTP/2 connections to the Kubernetes API to detect dropped watches (0 to disable)")
+	bindFlag("watch-keepalive", "watch_keepalive")
+
+	rootCmd.Flags().Duration("upstream-keepalive-interval", 0, "Interval of requests to /healthz of the Kubernetes API to keep the connection warm (0 to disable)")
+	bindFlag("upstream-keepalive-interval", "upstream_keepalive_interval")
+
+	rootCmd.Flags().String("management-addr", "", "Address of the management server, e.g. :9090 (disabled if empty)")
+	bindFlag("management-addr", "management_addr")
+
+	rootCmd.Flags().String("management-tls-cert", "", "Certificate file to serve the management endpoints over HTTPS")
+	bindFlag("management-tls-cert", "management_tls_cert")
+
+	rootCmd.Flags().String("management-tls-key", "", "Key file of the management server certificate")
+	bindFlag("management-tls-key", "management_tls_key")
+
+	rootCmd.Flags().String("management-tls-min-version", "1.2", "Minimum TLS version of the management server (1.2 or 1.3)")
+	bindFlag("management-tls-min-version", "management_tls_min_version")
+
+	rootCmd.Flags().String("management-client-ca", "", "CA file to require and verify client certificates of management requests")
+	bindFlag("management-client-ca", "management_client_ca")
+
+	rootCmd.Flags().String("management-auth-token", "", "Bearer token required for the management endpoints except /healthcheck")
+	bindFlag("management-auth-token", "management_auth_token")
+
+	rootCmd.Flags().Bool("log-api-warnings", false, "Log warnings returned by the Kubernetes API, e.g. about deprecated APIs")
+	bindFlag("log-api-warnings", "log_api_warnings")
+
+	rootCmd.Flags().Bool("check-version-skew", false, "Log a warning if a user's kubectl version is outside of the supported skew of the API server")
+	bindFlag("check-version-skew", "check_version_skew")
+
+	rootCmd.Flags().Float64("log-sample-rate", 1, "Fraction of forwarded requests written to the access log, denials and errors are always logged")
+	bindFlag("log-sample-rate", "log_sample_rate")
+
+	rootCmd.Flags().Bool("log-request-bodies", false, "Log the JSON bodies of mutating requests for debugging (may expose sensitive data)")
+	bindFlag("log-request-bodies", "log_request_bodies")
+
+	rootCmd.Flags().String("audit-log", "", "File to write audit.k8s.io/v1 events of all requests to, or '-' for stdout")
+	bindFlag("audit-log", "audit_log")
+
+	rootCmd.Flags().String("otel-endpoint", "", "OTLP/HTTP endpoint to export the proxy's decisions to as OpenTelemetry logs, e.g. http://otel-collector:4318")
+	bindFlag("otel-endpoint", "otel_endpoint")
+
+	rootCmd.Flags().String("audit-stream-url", "", "URL of a NATS server to publish audit events to, e.g. nats://nats:4222")
+	bindFlag("audit-stream-url", "audit_stream_url")
+
+	rootCmd.Flags().String("audit-stream-subject", "tailscale-kube-proxy.audit", "NATS subject audit events are published to")
+	bindFlag("audit-stream-subject", "audit_stream_subject")
+
+	rootCmd.Flags().Int("audit-stream-buffer", 1024, "Number of audit events buffered for the audit stream before dropping them")
+	bindFlag("audit-stream-buffer", "audit_stream_buffer")
+
+	rootCmd.Flags().Int("metrics-max-users", 50, "Maximum number of distinct users in metric labels, further users are reported as 'other'")
+	bindFlag("metrics-max-users", "metrics_max_users")
+
+	rootCmd.Flags().Duration("active-user-window", 15*time.Minute, "Time since the last request within which a user counts as active in the metrics")
+	bindFlag("active-user-window", "active_user_window")
+
+	rootCmd.Flags().String("metrics-push-url", "", "URL of a Prometheus Pushgateway to push metrics to, for instances that can't be scraped")
+	bindFlag("metrics-push-url", "metrics_push_url")
+
+	rootCmd.Flags().Duration("metrics-push-interval", time.Minute, "Interval at which metrics are pushed to the Pushgateway")
+	bindFlag("metrics-push-interval", "metrics_push_interval")
 
 	rootCmd.Flags().Bool("insecure", false, "Allow insecure connection to the Kubernetes API")
-	_ = viper.BindPFlag("insecure", rootCmd.Flags().Lookup("insecure"))
+	bindFlag("insecure", "insecure")
+
+	rootCmd.Flags().Bool("i-understand-insecure", false, "Confirm that --insecure disables the verification of the Kubernetes API server")
+	bindFlag("i-understand-insecure", "i_understand_insecure")
+
+	rootCmd.Flags().Duration("insecure-warn-interval", time.Hour, "Interval at which the warning about --insecure is repeated (0 to only warn at startup)")
+	bindFlag("insecure-warn-interval", "insecure_warn_interval")
+
+	rootCmd.Flags().String("pinned-server-cert", "", "SHA-256 fingerprint of the only Kubernetes API server certificate to accept")
+	bindFlag("pinned-server-cert", "pinned_server_cert")
+
+	rootCmd.Flags().Bool("enable-debug-signals", false, "Log a goroutine dump and memory statistics on SIGQUIT instead of exiting")
+	bindFlag("enable-debug-signals", "enable_debug_signals")
 
 	rootCmd.Flags().Bool("debug", false, "Enable debug logging")
-	_ = viper.BindPFlag("debug", rootCmd.Flags().Lookup("debug"))
+	bindFlag("debug", "debug")
+
+	// Flag parsing errors are caused by an invalid configuration.
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExitCode(exitConfig, err, "invalid flags")
+	})
 
 	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 }
 
 func run(cmd *cobra.Command, args []string) error {
-	// kubernetes client config
+	// Errors from here on are not caused by wrong usage.
+	cmd.SilenceUsage = true
+
+	// Tell the logs of multiple replicas apart if the pod is known via the downward API.
+	if os.Getenv("POD_NAME") != "" {
+		log.SetFlags(log.Flags() | log.Lmsgprefix)
+		log.SetPrefix(fmt.Sprintf("pod=%s/%s ", podinfo.Namespace(), podinfo.Name()))
+	}
+
 	log.Println("Starting TailscaleKubeProxy server...")
+	applyEnvSlices(cmd)
+	logSettings(cmd)
+
+	if viper.GetBool("enable_debug_signals") {
+		handleDebugSignals(cmd.Context())
+	}
+
+	// Exit if the proxy doesn't become ready in time, so that Kubernetes restarts the
+	// pod instead of leaving it stuck in a state it can't recover from on its own.
+	stopWatchdog := startWatchdog(viper.GetDuration("startup_deadline"))
+
+	// kubernetes client config
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		log.Fatalf("Failed to create config: %v", err)
+		return withExitCode(exitKubernetes, err, "failed to create config")
 	}
 
-	// initialize state store
-	secretName := viper.GetString("secret_name")
-	var store ipn.StateStore
-	if secretName != "" {
-		log.Printf("Using Kubernetes secret state store %s", secretName)
-		nsBytes, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
-		if err != nil {
-			log.Fatalf("Failed to read namespace: %v", err)
+	inst, err := newInstance(viper.GetViper(), config)
+	if err != nil {
+		return err
+	}
+	ts, server := inst.ts, inst.server
+	defer ts.Close()
+	onExit(server.Shutdown)
+
+	// Fail fast if the target is not a Kubernetes API server, e.g. a wrong --api-url.
+	if viper.GetBool("verify_upstream") {
+		if err = server.VerifyUpstream(cmd.Context()); err != nil {
+			return withExitCode(exitUpstream, err, "failed to verify upstream")
 		}
+	}
 
-		store, err = tailscale.NewKubernetesStore(string(nsBytes), secretName, config)
+	// start management server
+	if addr := viper.GetString("management_addr"); addr != "" {
+		mgmt, err := newManagementServer(addr)
 		if err != nil {
-			log.Fatalf("Failed to create store: %v", err)
+			return err
 		}
+		mgmt.Handle("/debug/tslog", management.LogLevelHandler(ts))
+		mgmt.Handle("/healthcheck", management.HealthHandler(ts.CheckStatus))
+		mgmt.Handle("/sessions", management.JSONHandler(server.Sessions))
+		mgmt.Handle("/whoami-self", management.JSONErrorHandler(ts.Self))
+		mgmt.Handle("/maintenance", management.MaintenanceHandler(server))
+		mgmt.Handle("/metrics", metrics.Handler())
+		go func() {
+			exit(withExitCode(exitGeneral, mgmt.Listen(), "management server failed"))
+		}()
 	}
 
-	// initialize tailscale server
-	ts, err := tailscale.NewServer(store)
-	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+	handleMaintenanceSignal(cmd.Context(), server)
+	handleReloadSignal(cmd.Context(), server)
+
+	// push metrics if the proxy can't be scraped
+	if url := viper.GetString("metrics_push_url"); url != "" {
+		interval := viper.GetDuration("metrics_push_interval")
+		if interval <= 0 {
+			return withExitCode(exitConfig, errors.New("interval must be positive"), "invalid metrics push interval")
+		}
+		go metrics.Push(cmd.Context(), url, interval)
 	}
-	defer ts.Close()
 
-	// initialize proxy
-	server, err := proxy.NewKubeProxy(config, ts)
-	if err != nil {
-		log.Fatalf("Failed to create proxy: %v", err)
+	// wait for the tailscale connection
+	terminated, err := awaitUp(cmd.Context(), ts.Up)
+	if terminated {
+		stopWatchdog()
+		logShutdown("terminated during startup", 0, nil)
+		return nil
+	} else if err != nil {
+		return withExitCode(exitTailscale, err, "failed to connect to Tailscale")
+	}
+	// Once the proxy is ready, termination signals exit the process immediately again,
+	// after exporting the buffered telemetry.
+	exitOnTermination(cmd.Context())
+	stopWatchdog()
+	log.Printf("Proxy ready after %s", metrics.ObserveReady().Round(time.Millisecond))
+
+	// re-authenticate the node if it needs to log in again
+	if attempts := viper.GetInt("ts.auto_reauth"); attempts > 0 {
+		go func() {
+			if err := ts.AutoReauth(cmd.Context(), attempts); err != nil {
+				exit(withExitCode(exitTailscale, err, "failed to re-authenticate Tailscale node"))
+			}
+		}()
 	}
 
+	// export metrics about the Tailscale peers
+	go ts.ReportPeerMetrics(cmd.Context())
+
+	// keep the connection to the API server warm
+	go server.RunKeepalive(cmd.Context())
+
 	// start proxy
-	return server.Listen()
+	if err = server.Serve(ts.Listener()); err != nil {
+		return withExitCode(exitGeneral, err, "proxy server failed")
+	}
+	return nil
+}
+
+// startWatchdog exits with exitStartupDeadline unless the returned function is called
+// within the deadline. A deadline of zero disables the watchdog.
+func startWatchdog(deadline time.Duration) (stop func() bool) {
+	if deadline <= 0 {
+		return func() bool { return false }
+	}
+	return time.AfterFunc(deadline, func() {
+		err := fmt.Errorf("proxy did not become ready within %s", deadline)
+		exit(withExitCode(exitStartupDeadline, err, "startup deadline exceeded"))
+	}).Stop
+}
+
+// awaitUp waits for the Tailscale node to come up. The login is aborted on termination,
+// so that the node is closed cleanly instead of being killed while it is registering,
+// which terminated reports.
+func awaitUp(ctx context.Context, up func(context.Context) error) (terminated bool, err error) {
+	upCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err = up(upCtx); err != nil && upCtx.Err() != nil && ctx.Err() == nil {
+		return true, nil
+	}
+	return false, err
+}
+
+// newManagementServer creates the management server listening on the address, secured
+// by the configured TLS settings and auth token.
+func newManagementServer(addr string) (*management.Server, error) {
+	mgmt := management.NewServer(addr)
+	if viper.GetString("management_client_ca") != "" && viper.GetString("management_tls_cert") == "" {
+		return nil, withExitCode(exitConfig, errors.New("client CA requires a TLS certificate"), "invalid management server TLS configuration")
+	}
+	if cert := viper.GetString("management_tls_cert"); cert != "" {
+		err := mgmt.SetTLS(management.TLSConfig{
+			CertFile:     cert,
+			KeyFile:      viper.GetString("management_tls_key"),
+			ClientCAFile: viper.GetString("management_client_ca"),
+			MinVersion:   viper.GetString("management_tls_min_version"),
+		})
+		if err != nil {
+			return nil, withExitCode(exitConfig, err, "invalid management server TLS configuration")
+		}
+	}
+	// Probes of the health check usually can't authenticate.
+	if token := viper.GetString("management_auth_token"); token != "" {
+		mgmt.SetAuthToken(token, "/healthcheck")
+	}
+	return mgmt, nil
 }
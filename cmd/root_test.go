@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStartupDeadline(t *testing.T) {
+	if inSubprocess() {
+		startWatchdog(10 * time.Millisecond)
+		time.Sleep(5 * time.Second)
+		return
+	}
+
+	code, output := runSubprocess(t)
+	if code != exitStartupDeadline {
+		t.Errorf("exit code = %d, want %d", code, exitStartupDeadline)
+	}
+	if want := `reason="startup deadline exceeded" exit_code=5`; !strings.Contains(output, want) {
+		t.Errorf("output = %q, want the line %q", output, want)
+	}
+}
+
+func TestStartupDeadlineStopped(t *testing.T) {
+	stop := startWatchdog(time.Hour)
+	if !stop() {
+		t.Error("stop() = false, want the pending watchdog to be stopped")
+	}
+	if startWatchdog(0)() {
+		t.Error("disabled watchdog reported being stopped")
+	}
+}
+
+func TestAwaitUpTerminated(t *testing.T) {
+	var upCtx context.Context
+	terminated, err := awaitUp(t.Context(), func(ctx context.Context) error {
+		upCtx = ctx
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatalf("failed to send SIGTERM: %v", err)
+		}
+		// The node keeps registering until the login is aborted.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("login was not aborted")
+		}
+	})
+	if !terminated || err != nil {
+		t.Errorf("awaitUp() = %t, %v, want a termination", terminated, err)
+	}
+	if upCtx.Err() == nil {
+		t.Error("context of the login is not canceled")
+	}
+}
+
+func TestAwaitUpFailure(t *testing.T) {
+	cause := errors.New("invalid key: unable to validate API key")
+	terminated, err := awaitUp(t.Context(), func(context.Context) error { return cause })
+	if terminated || !errors.Is(err, cause) {
+		t.Errorf("awaitUp() = %t, %v, want %v", terminated, err, cause)
+	}
+
+	// Canceling the command is not a termination signal.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	terminated, err = awaitUp(ctx, func(ctx context.Context) error { return ctx.Err() })
+	if terminated || !errors.Is(err, context.Canceled) {
+		t.Errorf("awaitUp() of a canceled command = %t, %v, want %v", terminated, err, context.Canceled)
+	}
+
+	if terminated, err = awaitUp(t.Context(), func(context.Context) error { return nil }); terminated || err != nil {
+		t.Errorf("awaitUp() = %t, %v, want the node to be up", terminated, err)
+	}
+}
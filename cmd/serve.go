@@ -0,0 +1,407 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// serveCmd runs several proxies configured in a file, each as its own Tailscale node.
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run several proxies configured in a file, each as its own Tailscale node",
+	Long: `Runs every proxy instance of the config file as its own Tailscale node with its own
+hostname, backend, identity mapping and state secret. The instances are started and
+restarted independently, so that a failing instance doesn't affect the others.
+
+Instances are configured with the names of the command line flags. The settings under
+'defaults' apply to all instances and take precedence over environment variables.`,
+	Args: cobra.NoArgs,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().String("config", "", "YAML file configuring the proxy instances")
+	_ = serveCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// processKeys are the settings shared by all instances of serve, which can only be set
+// in the defaults of the config file.
+var processKeys = map[string]bool{
+	"management_addr":            true,
+	"management_tls_cert":        true,
+	"management_tls_key":         true,
+	"management_tls_min_version": true,
+	"management_client_ca":       true,
+	"management_auth_token":      true,
+	"metrics_push_url":           true,
+	"metrics_push_interval":      true,
+	"enable_debug_signals":       true,
+}
+
+// Delays between restarts of a failed instance, doubling with every failure in a row.
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// serveConfig is the config file of serve.
+type serveConfig struct {
+	// Defaults are the settings of all instances by flag name.
+	Defaults map[string]any `json:"defaults"`
+	// Instances are the settings of each instance by flag name, including its name.
+	Instances []map[string]any `json:"instances"`
+}
+
+// serveInstance is a configured instance of serve.
+type serveInstance struct {
+	name     string
+	settings *viper.Viper
+}
+
+// flagSetting returns the configuration key of the flag.
+func flagSetting(flag string) (string, bool) {
+	for key, name := range flagKeys {
+		if name == flag {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// loadServeConfig reads the config file, applies its defaults to the global configuration
+// and returns the settings of each instance on top of them.
+func loadServeConfig(path string) ([]serveInstance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config serveConfig
+	if err = yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Instances) == 0 {
+		return nil, errors.New("no instances configured")
+	}
+
+	for flag, value := range config.Defaults {
+		key, ok := flagSetting(flag)
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q in defaults", flag)
+		}
+		if key == "startup_deadline" {
+			return nil, errors.New("startup-deadline is not supported, as the instances become ready independently")
+		}
+		viper.Set(key, value)
+		fileKeys[key] = true
+	}
+
+	instances := make([]serveInstance, 0, len(config.Instances))
+	seen := map[string]map[string]string{"name": {}, "hostname": {}, "secret-name": {}, "tsnet-dir": {}}
+	for i, values := range config.Instances {
+		name, _ := values["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("instance %d has no name", i+1)
+		}
+
+		inst, err := newServeInstance(name, values)
+		if err != nil {
+			return nil, fmt.Errorf("instance %q: %w", name, err)
+		}
+
+		// Instances sharing a node identity or state would take over each other's node.
+		unique := map[string]string{
+			"name":        name,
+			"hostname":    inst.settings.GetString("ts.hostname"),
+			"secret-name": inst.settings.GetString("secret_name"),
+			"tsnet-dir":   inst.settings.GetString("ts.dir"),
+		}
+		for setting, value := range unique {
+			if other, ok := seen[setting][value]; ok && value != "" {
+				return nil, fmt.Errorf("instances %q and %q have the same %s %q", other, name, setting, value)
+			}
+			seen[setting][value] = name
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// newServeInstance returns the instance with its settings over the global configuration.
+// Unless set otherwise, the hostname is the name of the instance. The Tailscale runtime
+// files are kept in a directory of the instance below --tsnet-dir.
+func newServeInstance(name string, values map[string]any) (serveInstance, error) {
+	settings := copySettings()
+	if !viper.IsSet("ts.hostname") {
+		settings.SetDefault("ts.hostname", name)
+	}
+
+	dir := viper.GetString("ts.dir")
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return serveInstance{}, fmt.Errorf("no tsnet-dir: %w", err)
+		}
+		dir = filepath.Join(configDir, "tailscale-kube-proxy")
+	}
+	settings.SetDefault("ts.dir", filepath.Join(dir, name))
+
+	for flag, value := range values {
+		if flag == "name" {
+			continue
+		}
+		key, ok := flagSetting(flag)
+		if !ok {
+			return serveInstance{}, fmt.Errorf("unknown setting %q", flag)
+		}
+		if processKeys[key] || key == "startup_deadline" {
+			return serveInstance{}, fmt.Errorf("%s applies to all instances and can only be set in defaults", flag)
+		}
+		settings.Set(key, value)
+	}
+	return serveInstance{name: name, settings: settings}, nil
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	// Errors from here on are not caused by wrong usage.
+	cmd.SilenceUsage = true
+
+	log.Println("Starting TailscaleKubeProxy instances...")
+	applyEnvSlices(rootCmd)
+	path, _ := cmd.Flags().GetString("config")
+	instances, err := loadServeConfig(path)
+	if err != nil {
+		return withExitCode(exitConfig, err, "invalid serve config")
+	}
+	logSettings(rootCmd)
+	log.Printf("Serving instances %s", instanceNames(instances))
+
+	// The instances are stopped on termination, closing their nodes cleanly.
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if viper.GetBool("enable_debug_signals") {
+		handleDebugSignals(ctx)
+	}
+
+	// The health check only fails if a running instance is unhealthy or none is running,
+	// so that a liveness probe doesn't restart all instances because of a failed one.
+	var nodes sync.Map
+	if addr := viper.GetString("management_addr"); addr != "" {
+		mgmt, err := newManagementServer(addr)
+		if err != nil {
+			return err
+		}
+		mgmt.Handle("/healthcheck", management.HealthHandler(func(ctx context.Context) error {
+			running := false
+			var err error
+			nodes.Range(func(name, node any) bool {
+				running = true
+				if err = node.(*instance).ts.CheckStatus(ctx); err != nil {
+					err = fmt.Errorf("instance %q: %w", name, err)
+				}
+				return err == nil
+			})
+			if !running {
+				return errors.New("no instance is running")
+			}
+			return err
+		}))
+		mgmt.Handle("/metrics", metrics.Handler())
+		go func() {
+			exit(withExitCode(exitGeneral, mgmt.Listen(), "management server failed"))
+		}()
+	}
+
+	// push metrics if the proxy can't be scraped
+	if url := viper.GetString("metrics_push_url"); url != "" {
+		interval := viper.GetDuration("metrics_push_interval")
+		if interval <= 0 {
+			return withExitCode(exitConfig, errors.New("interval must be positive"), "invalid metrics push interval")
+		}
+		go metrics.Push(ctx, url, interval)
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return withExitCode(exitKubernetes, err, "failed to create config")
+	}
+
+	err = superviseInstances(ctx, instances, func(ctx context.Context, inst serveInstance) error {
+		return runInstance(ctx, inst, config, &nodes)
+	})
+	if err != nil {
+		return err
+	}
+	logShutdown("terminated", 0, nil)
+	return nil
+}
+
+// runInstance serves the instance as its own Tailscale node until the context is
+// canceled or it fails. The running node is kept in nodes for the health check.
+func runInstance(ctx context.Context, inst serveInstance, config *rest.Config, nodes *sync.Map) error {
+	node, err := newInstance(inst.settings, config)
+	if err != nil {
+		return err
+	}
+	defer node.ts.Close()
+	defer closeProxy(inst, node.server)
+
+	handleMaintenanceSignal(ctx, node.server)
+	handleReloadSignal(ctx, node.server)
+
+	if inst.settings.GetBool("verify_upstream") {
+		if err = node.server.VerifyUpstream(ctx); err != nil {
+			return withExitCode(exitUpstream, err, "failed to verify upstream")
+		}
+	}
+
+	if err = node.ts.Up(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return withExitCode(exitTailscale, err, "failed to connect to Tailscale")
+	}
+	metrics.ObserveReady()
+	log.Printf("Instance %q ready", inst.name)
+	nodes.Store(inst.name, node)
+	defer nodes.Delete(inst.name)
+
+	// A failure of the re-authentication stops the instance.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if attempts := inst.settings.GetInt("ts.auto_reauth"); attempts > 0 {
+		go func() {
+			if err := node.ts.AutoReauth(ctx, attempts); err != nil {
+				cancel(withExitCode(exitTailscale, err, "failed to re-authenticate Tailscale node"))
+			}
+		}()
+	}
+
+	return serveProxy(ctx, inst, node.server, node.ts.Listener(), node.ts.Close)
+}
+
+// serveProxy serves the proxy of the instance on the listener until the context is
+// canceled or it fails, closing the proxy afterwards, so that a restarted instance
+// doesn't leave the background work of the previous proxy behind.
+func serveProxy(ctx context.Context, inst serveInstance, server *proxy.ReverseProxy, ln net.Listener, closeFn func() error) error {
+	defer closeProxy(inst, server)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var keepalive sync.WaitGroup
+	keepalive.Go(func() { server.RunKeepalive(ctx) })
+	defer keepalive.Wait()
+	defer cancel()
+
+	return serveUntilDone(ctx, func() error { return server.Serve(ln) }, closeFn)
+}
+
+// closeProxy closes the proxy of the instance, logging a failure to export its events.
+func closeProxy(inst serveInstance, server *proxy.ReverseProxy) {
+	if err := server.Close(); err != nil {
+		log.Printf("Warning: failed to close instance %q: %v", inst.name, err)
+	}
+}
+
+// serveUntilDone runs serve until it fails or the context is canceled, which is stopped by
+// calling closeFn. It returns the cause of the cancellation, unless the context was
+// canceled without one.
+func serveUntilDone(ctx context.Context, serve func() error, closeFn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- serve()
+	}()
+
+	select {
+	case err := <-done:
+		return withExitCode(exitGeneral, err, "proxy server failed")
+	case <-ctx.Done():
+		_ = closeFn()
+		<-done
+		if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+		return nil
+	}
+}
+
+// superviseInstances runs the instances until the context is canceled, restarting each
+// failed instance with backoff independently of the others. Instances failing with a
+// configuration error are stopped for good, as they would fail again. It fails once no
+// instance is left.
+func superviseInstances(ctx context.Context, instances []serveInstance, run func(context.Context, serveInstance) error) error {
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervise(ctx, inst, run)
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return withExitCode(exitConfig, errors.New("all instances failed with configuration errors"), "no instance left")
+}
+
+// supervise runs the instance until the context is canceled or it fails with a
+// configuration error.
+func supervise(ctx context.Context, inst serveInstance, run func(context.Context, serveInstance) error) {
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		err := run(ctx, inst)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		if exitCode(err) == exitConfig {
+			log.Printf("Error: instance %q stopped for good: %v", inst.name, err)
+			return
+		}
+
+		// An instance that ran for a while failed for a new reason.
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		log.Printf("Warning: instance %q failed, restarting in %s: %v", inst.name, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRestartDelay)
+	}
+}
+
+// instanceNames returns the names of the instances for logs.
+func instanceNames(instances []serveInstance) string {
+	names := make([]string, len(instances))
+	for i, inst := range instances {
+		names[i] = inst.name
+	}
+	return strings.Join(names, ", ")
+}
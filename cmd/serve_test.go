@@ -0,0 +1,417 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"k8s.io/client-go/rest"
+)
+
+// writeServeConfig writes the config file of serve and returns its path.
+func writeServeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "serve.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+// loopbackInstances runs the proxy of each instance on a loopback listener instead of a
+// Tailscale node, and returns the addresses of the running instances by name.
+type loopbackInstances struct {
+	mu    sync.Mutex
+	addrs map[string]string
+	ready chan string
+}
+
+func newLoopbackInstances() *loopbackInstances {
+	return &loopbackInstances{addrs: make(map[string]string), ready: make(chan string, 10)}
+}
+
+func (l *loopbackInstances) run(ctx context.Context, inst serveInstance) error {
+	server, err := newProxy(inst.settings, &rest.Config{BearerToken: "proxy-token"}, testutil.NewStaticResolver())
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.addrs[inst.name] = ln.Addr().String()
+	l.mu.Unlock()
+	l.ready <- inst.name
+
+	return serveProxy(ctx, inst, server, ln, ln.Close)
+}
+
+func (l *loopbackInstances) addr(name string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.addrs[name]
+}
+
+// awaitReady waits for the instances to be running.
+func (l *loopbackInstances) awaitReady(t *testing.T, names ...string) {
+	t.Helper()
+
+	for range names {
+		select {
+		case <-l.ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("instances did not start")
+		}
+	}
+}
+
+// startInstances supervises the instances until the test finishes.
+func startInstances(t *testing.T, instances []serveInstance, run func(context.Context, serveInstance) error) chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- superviseInstances(ctx, instances, run)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return done
+}
+
+// getEcho requests the path from the proxy and decodes the echo of the fake API server.
+func getEcho(t *testing.T, addr string, path string) testutil.EchoResponse {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var echo testutil.EchoResponse
+	if err = json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("failed to decode echo: %v", err)
+	}
+	return echo
+}
+
+func TestServeTwoInstances(t *testing.T) {
+	testutil.Configure(t, nil)
+	alpha, beta := testutil.NewFakeAPIServer(t), testutil.NewFakeAPIServer(t)
+	path := writeServeConfig(t, fmt.Sprintf(`
+defaults:
+  verify-upstream: false
+  tsnet-dir: %s
+instances:
+  - name: alpha
+    api-url: %s
+    unidentified-user: alpha-anonymous
+  - name: beta
+    hostname: beta-api
+    api-url: %s
+    unidentified-user: beta-anonymous
+    strip-path-prefix: /beta
+`, t.TempDir(), alpha.URL, beta.URL))
+
+	instances, err := loadServeConfig(path)
+	if err != nil {
+		t.Fatalf("loadServeConfig() error = %v", err)
+	}
+	loopback := newLoopbackInstances()
+	startInstances(t, instances, loopback.run)
+	loopback.awaitReady(t, "alpha", "beta")
+
+	tests := []struct {
+		name     string
+		path     string
+		upstream *testutil.FakeAPIServer
+		wantUser string
+		wantPath string
+	}{
+		{name: "alpha", path: "/api/v1/pods", upstream: alpha, wantUser: "alpha-anonymous", wantPath: "/api/v1/pods"},
+		{name: "beta", path: "/beta/api/v1/pods", upstream: beta, wantUser: "beta-anonymous", wantPath: "/api/v1/pods"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.upstream.Requests())
+			echo := getEcho(t, loopback.addr(tt.name), tt.path)
+
+			if echo.User != tt.wantUser {
+				t.Errorf("user = %q, want %q", echo.User, tt.wantUser)
+			}
+			if echo.Path != tt.wantPath {
+				t.Errorf("path = %q, want %q", echo.Path, tt.wantPath)
+			}
+			if got := len(tt.upstream.Requests()) - before; got != 1 {
+				t.Errorf("upstream of %s received %d requests, want 1", tt.name, got)
+			}
+		})
+	}
+}
+
+func TestServeInstanceFailsIndependently(t *testing.T) {
+	testutil.Configure(t, nil)
+	api := testutil.NewFakeAPIServer(t)
+	path := writeServeConfig(t, fmt.Sprintf(`
+defaults:
+  verify-upstream: false
+  tsnet-dir: %s
+  api-url: %s
+instances:
+  - name: broken
+    unidentified-user: ""
+  - name: working
+`, t.TempDir(), api.URL))
+
+	instances, err := loadServeConfig(path)
+	if err != nil {
+		t.Fatalf("loadServeConfig() error = %v", err)
+	}
+	loopback := newLoopbackInstances()
+	done := startInstances(t, instances, loopback.run)
+	loopback.awaitReady(t, "working")
+
+	echo := getEcho(t, loopback.addr("working"), "/api/v1/pods")
+	if echo.User != "system:anonymous" {
+		t.Errorf("user = %q, want the default unidentified user", echo.User)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("instances stopped with %v, want the working instance to keep running", err)
+	default:
+	}
+}
+
+func TestServeStopsWithoutInstances(t *testing.T) {
+	instances := []serveInstance{{name: "alpha"}, {name: "beta"}}
+	err := superviseInstances(context.Background(), instances, func(context.Context, serveInstance) error {
+		return withExitCode(exitConfig, errors.New("unidentified user must not be empty"), "failed to create proxy")
+	})
+	if exitCode(err) != exitConfig {
+		t.Errorf("superviseInstances() error = %v, want exit code %d", err, exitConfig)
+	}
+}
+
+func TestServeRestartsFailedInstance(t *testing.T) {
+	var mu sync.Mutex
+	runs := 0
+	running := make(chan struct{})
+	startInstances(t, []serveInstance{{name: "alpha"}}, func(ctx context.Context, _ serveInstance) error {
+		mu.Lock()
+		runs++
+		first := runs == 1
+		mu.Unlock()
+
+		if first {
+			return withExitCode(exitTailscale, errors.New("connection refused"), "failed to connect to Tailscale")
+		}
+		close(running)
+		<-ctx.Done()
+		return nil
+	})
+
+	select {
+	case <-running:
+	case <-time.After(minRestartDelay + 5*time.Second):
+		t.Fatal("failed instance was not restarted")
+	}
+}
+
+func TestServeRestartLeavesNoGoroutines(t *testing.T) {
+	testutil.Configure(t, nil)
+	api := testutil.NewFakeAPIServer(t)
+	path := writeServeConfig(t, fmt.Sprintf(`
+defaults:
+  verify-upstream: false
+  tsnet-dir: %s
+instances:
+  - name: alpha
+    api-url: %s
+    insecure: true
+    i-understand-insecure: true
+    insecure-warn-interval: 1s
+`, t.TempDir(), api.URL))
+	instances, err := loadServeConfig(path)
+	if err != nil {
+		t.Fatalf("loadServeConfig() error = %v", err)
+	}
+	baseline := runtime.NumGoroutine()
+
+	// The first run fails after serving a request, so that its proxy is restarted.
+	var runs sync.WaitGroup
+	runs.Add(2)
+	first := true
+	run := func(ctx context.Context, inst serveInstance) error {
+		defer runs.Done()
+		server, err := newProxy(inst.settings, &rest.Config{Host: api.URL}, testutil.NewStaticResolver())
+		if err != nil {
+			return err
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		go func() {
+			if resp, err := http.Get("http://" + ln.Addr().String() + "/api/v1/pods"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		if first {
+			first = false
+			_ = ln.Close()
+		}
+		return serveProxy(ctx, inst, server, ln, ln.Close)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- superviseInstances(ctx, instances, run) }()
+
+	restarted := make(chan struct{})
+	go func() {
+		runs.Wait()
+		close(restarted)
+	}()
+	time.Sleep(minRestartDelay + 500*time.Millisecond)
+	cancel()
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed instance was not restarted")
+	}
+	<-done
+	http.DefaultClient.CloseIdleConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left after the restart, want at most %d:\n%s",
+				runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadServeConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "unknown setting",
+			config:  "instances:\n  - name: alpha\n    api-uri: https://alpha\n",
+			wantErr: `unknown setting "api-uri"`,
+		},
+		{
+			name:    "unknown default",
+			config:  "defaults:\n  hostnam: proxy\ninstances:\n  - name: alpha\n",
+			wantErr: `unknown setting "hostnam" in defaults`,
+		},
+		{
+			name:    "unknown section",
+			config:  "instance:\n  - name: alpha\n",
+			wantErr: `unknown field "instance"`,
+		},
+		{
+			name:    "setting of all instances",
+			config:  "instances:\n  - name: alpha\n    management-addr: :9090\n",
+			wantErr: "management-addr applies to all instances",
+		},
+		{
+			name:    "missing name",
+			config:  "instances:\n  - hostname: alpha\n",
+			wantErr: "instance 1 has no name",
+		},
+		{
+			name:    "same hostname",
+			config:  "defaults:\n  hostname: proxy\ninstances:\n  - name: alpha\n  - name: beta\n",
+			wantErr: `same hostname "proxy"`,
+		},
+		{
+			name:    "same state secret",
+			config:  "instances:\n  - name: alpha\n    secret-name: state\n  - name: beta\n    secret-name: state\n",
+			wantErr: `same secret-name "state"`,
+		},
+		{
+			name:    "no instances",
+			config:  "defaults:\n  hostname: proxy\n",
+			wantErr: "no instances configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.Configure(t, map[string]any{"ts.dir": t.TempDir()})
+
+			_, err := loadServeConfig(writeServeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadServeConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadServeConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	testutil.Configure(t, map[string]any{"ts.dir": dir})
+	path := writeServeConfig(t, `
+defaults:
+  authkey: tskey-shared
+  max-groups: 5
+instances:
+  - name: alpha
+  - name: beta
+    hostname: beta-api
+    max-groups: 10
+`)
+
+	instances, err := loadServeConfig(path)
+	if err != nil {
+		t.Fatalf("loadServeConfig() error = %v", err)
+	}
+
+	want := []struct {
+		hostname  string
+		dir       string
+		maxGroups int
+	}{
+		{hostname: "alpha", dir: filepath.Join(dir, "alpha"), maxGroups: 5},
+		{hostname: "beta-api", dir: filepath.Join(dir, "beta"), maxGroups: 10},
+	}
+	for i, inst := range instances {
+		if got := inst.settings.GetString("ts.hostname"); got != want[i].hostname {
+			t.Errorf("hostname of %s = %q, want %q", inst.name, got, want[i].hostname)
+		}
+		if got := inst.settings.GetString("ts.dir"); got != want[i].dir {
+			t.Errorf("tsnet dir of %s = %q, want %q", inst.name, got, want[i].dir)
+		}
+		if got := inst.settings.GetInt("max_groups"); got != want[i].maxGroups {
+			t.Errorf("max groups of %s = %d, want %d", inst.name, got, want[i].maxGroups)
+		}
+		if got := inst.settings.GetString("ts.authkey"); got != "tskey-shared" {
+			t.Errorf("authkey of %s = %q, want the default", inst.name, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionsCmd lists the requests currently being proxied by a running instance.
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the active requests of a running proxy grouped by user",
+	Long: `Lists the requests currently being proxied by a running instance, grouped by
+Tailscale user. The instance must have the management server enabled.`,
+	Args: cobra.NoArgs,
+	RunE: runSessions,
+}
+
+func init() {
+	sessionsCmd.Flags().String("addr", "localhost:9090", "Address or URL of the management server, e.g. https://localhost:9090")
+	sessionsCmd.Flags().String("token", "", "Bearer token of the management server")
+	sessionsCmd.Flags().String("ca-file", "", "CA file to verify the certificate of the management server")
+	rootCmd.AddCommand(sessionsCmd)
+}
+
+func runSessions(cmd *cobra.Command, args []string) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	token, _ := cmd.Flags().GetString("token")
+	caFile, _ := cmd.Flags().GetString("ca-file")
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequestWithContext(cmd.Context(), http.MethodGet, strings.TrimSuffix(addr, "/")+"/sessions", nil)
+	if err != nil {
+		return fmt.Errorf("invalid management server address: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query management server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("management server responded with %s", resp.Status)
+	}
+
+	var sessions []proxy.Session
+	if err = json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return fmt.Errorf("failed to decode sessions: %w", err)
+	}
+
+	// Sessions are ordered by start time, a stable sort keeps that order per user.
+	slices.SortStableFunc(sessions, func(a, b proxy.Session) int {
+		return strings.Compare(a.User, b.User)
+	})
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "USER\tSTARTED\tDURATION\tMETHOD\tPATH")
+	for _, s := range sessions {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			s.User, s.Start.Format(time.RFC3339), time.Since(s.Start).Round(time.Second), s.Method, s.Path)
+	}
+	return w.Flush()
+}
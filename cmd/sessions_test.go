@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/spf13/viper"
+	"tailscale.com/tailcfg"
+)
+
+// runSessionsCmd runs the sessions subcommand against the management server and returns
+// the rows of its table without the header.
+func runSessionsCmd(t *testing.T, addr string) [][]string {
+	t.Helper()
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs([]string{"sessions", "--addr", addr})
+	t.Cleanup(func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetArgs(nil)
+	})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("sessions failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if got := strings.Fields(lines[0]); strings.Join(got, " ") != "USER STARTED DURATION METHOD PATH" {
+		t.Fatalf("header = %q, want the column names", lines[0])
+	}
+	var rows [][]string
+	for _, line := range lines[1:] {
+		rows = append(rows, strings.Fields(line))
+	}
+	return rows
+}
+
+func TestSessions(t *testing.T) {
+	testutil.Configure(t, nil)
+	api := testutil.NewFakeAPIServer(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	resolver := testutil.NewStaticResolver()
+	resolver.SetUser("127.0.0.1", &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{LoginName: "alice@example.com"},
+	})
+	server, err := newProxy(viper.GetViper(), api.Config(), resolver)
+	if err != nil {
+		t.Fatalf("newProxy() error = %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	mgmt := httptest.NewServer(management.JSONHandler(server.Sessions))
+	t.Cleanup(mgmt.Close)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(srv.URL + "/api/v1/namespaces/default/pods")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not reach the API server")
+	}
+
+	rows := runSessionsCmd(t, strings.TrimPrefix(mgmt.URL, "http://"))
+	if len(rows) != 1 {
+		t.Fatalf("sessions = %q, want the request in flight", rows)
+	}
+	if row := rows[0]; row[0] != "alice@example.com" || row[3] != http.MethodGet || row[4] != "/api/v1/namespaces/default/pods" {
+		t.Errorf("session = %q, want the GET of alice@example.com", row)
+	}
+
+	// The session is removed once the handler of the proxy returned, which may be just
+	// after the client received the response.
+	close(release)
+	<-done
+	deadline := time.Now().Add(5 * time.Second)
+	for rows = runSessionsCmd(t, mgmt.URL); len(rows) != 0; rows = runSessionsCmd(t, mgmt.URL) {
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions = %q, want none after the request completed", rows)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
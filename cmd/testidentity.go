@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"tailscale.com/tailcfg"
+)
+
+// testIdentityCmd shows how the proxy would handle a request of a Tailscale user.
+var testIdentityCmd = &cobra.Command{
+	Use:   "test-identity",
+	Short: "Show the identity and decision the proxy applies to a request of a Tailscale user",
+	Long: `Runs a request of the given Tailscale user through the proxy, without a tailnet or
+Kubernetes cluster, and prints whether it would be forwarded and with which identity
+headers. The proxy is configured from the same environment variables as when serving,
+so that settings like the username source, known principals and tag namespaces can be
+checked before rolling them out.`,
+	Args: cobra.NoArgs,
+	RunE: runTestIdentity,
+}
+
+func init() {
+	testIdentityCmd.Flags().String("login", "", "Tailscale login name of the user")
+	testIdentityCmd.Flags().String("display-name", "", "Display name of the user")
+	testIdentityCmd.Flags().Int64("id", 0, "Tailscale ID of the user")
+	testIdentityCmd.Flags().StringSlice("groups", nil, "Groups of the user")
+	testIdentityCmd.Flags().StringSlice("tags", nil, "ACL tags of the user's node")
+	testIdentityCmd.Flags().String("method", http.MethodGet, "HTTP method of the request")
+	testIdentityCmd.Flags().String("path", "/api/v1/namespaces/default/pods", "Path of the request")
+	_ = testIdentityCmd.MarkFlagRequired("login")
+	rootCmd.AddCommand(testIdentityCmd)
+}
+
+// staticIdentity resolves every client to the same Tailscale user.
+type staticIdentity struct {
+	user *tailscale.UserProfile
+}
+
+func (s staticIdentity) WhoIs(context.Context, string) (*tailscale.UserProfile, error) {
+	return s.user, nil
+}
+
+func runTestIdentity(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	login, _ := flags.GetString("login")
+	displayName, _ := flags.GetString("display-name")
+	id, _ := flags.GetInt64("id")
+	groups, _ := flags.GetStringSlice("groups")
+	tags, _ := flags.GetStringSlice("tags")
+	method, _ := flags.GetString("method")
+	path, _ := flags.GetString("path")
+
+	user := &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{
+			ID:          tailcfg.UserID(id),
+			LoginName:   login,
+			DisplayName: displayName,
+			Groups:      groups,
+		},
+		Tags: tags,
+	}
+
+	// Stand in for the API server, recording the headers the request is forwarded with.
+	var forwarded http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	// Don't record the test request in the audit log, stream or decision export of the
+	// real proxy, and don't contact the real API server.
+	applyEnvSlices(rootCmd)
+	settings := copySettings()
+	settings.Set("audit_log", "")
+	settings.Set("audit_stream_url", "")
+	settings.Set("otel_endpoint", "")
+	settings.Set("check_version_skew", false)
+	settings.Set("discover_api_endpoints", false)
+
+	p, err := proxy.NewKubeProxyWithSettings(settings, &rest.Config{Host: upstream.URL}, staticIdentity{user: user})
+	if err != nil {
+		return fmt.Errorf("failed to create proxy: %w", err)
+	}
+	defer p.Close()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = net.JoinHostPort("100.64.0.1", "41641")
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, req)
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	if forwarded == nil {
+		message := recorder.Body.String()
+		status := new(metav1.Status)
+		if json.Unmarshal(recorder.Body.Bytes(), status) == nil && status.Message != "" {
+			message = status.Message
+		}
+		_, _ = fmt.Fprintf(w, "DECISION\tdeny (%d)\n", recorder.Code)
+		_, _ = fmt.Fprintf(w, "REASON\t%s\n", strings.TrimSpace(message))
+		return w.Flush()
+	}
+
+	_, _ = fmt.Fprintln(w, "DECISION\tallow")
+	for _, name := range identityHeaders(settings, forwarded) {
+		for _, value := range forwarded.Values(name) {
+			_, _ = fmt.Fprintf(w, "%s\t%s\n", name, value)
+		}
+	}
+	return w.Flush()
+}
+
+// identityHeaders returns the names of the headers identifying the user to the API
+// server, either impersonation or front proxy headers.
+func identityHeaders(settings *viper.Viper, header http.Header) []string {
+	frontProxy := settings.GetBool("front_proxy.enabled")
+	extraPrefix := strings.ToLower(settings.GetString("front_proxy.extra_header_prefix"))
+
+	var names []string
+	for name := range header {
+		switch {
+		case strings.HasPrefix(name, "Impersonate-"),
+			frontProxy && strings.EqualFold(name, settings.GetString("front_proxy.user_header")),
+			frontProxy && strings.EqualFold(name, settings.GetString("front_proxy.group_header")),
+			frontProxy && extraPrefix != "" && strings.HasPrefix(strings.ToLower(name), extraPrefix):
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/spf13/viper"
+)
+
+// runTestIdentityCmd runs the test-identity subcommand with the arguments and returns its
+// output.
+func runTestIdentityCmd(t *testing.T, args ...string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs(append([]string{"test-identity"}, args...))
+	t.Cleanup(func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetArgs(nil)
+	})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("test-identity failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestTestIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		want   []string
+	}{
+		{
+			name:   "allow",
+			method: "GET",
+			want: []string{
+				"DECISION allow",
+				"Impersonate-User alice@example.com",
+				"Impersonate-Group developers",
+			},
+		},
+		{
+			name:   "deny",
+			method: "DELETE",
+			want: []string{
+				"DECISION deny (405)",
+				"REASON method DELETE is not allowed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.Configure(t, map[string]any{
+				"allow_methods": []string{"GET"},
+				"audit_log":     "/nonexistent/audit.log",
+			})
+
+			output := runTestIdentityCmd(t, "--login", "alice@example.com", "--groups", "developers", "--method", tt.method)
+			var lines []string
+			for line := range strings.Lines(output) {
+				lines = append(lines, strings.Join(strings.Fields(line), " "))
+			}
+			for _, line := range tt.want {
+				if !slices.Contains(lines, line) {
+					t.Errorf("output = %q, want the line %q", output, line)
+				}
+			}
+			if got := viper.GetString("audit_log"); got != "/nonexistent/audit.log" {
+				t.Errorf("audit log = %q after the command, want the global configuration unchanged", got)
+			}
+		})
+	}
+}
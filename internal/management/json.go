@@ -0,0 +1,30 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// JSONHandler returns a handler responding with the JSON encoded result of fn.
+func JSONHandler[T any](fn func() T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(fn())
+	})
+}
+
+// JSONErrorHandler returns a handler responding with the JSON encoded result of fn, or
+// with 503 and the error if fn fails.
+func JSONErrorHandler[T any](fn func(ctx context.Context) (T, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := fn(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		JSONHandler(func() T { return result }).ServeHTTP(w, r)
+	})
+}
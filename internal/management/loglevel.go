@@ -0,0 +1,33 @@
+package management
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// LogLeveler is implemented by components whose log verbosity can be changed at runtime.
+type LogLeveler interface {
+	LogLevel() string
+	SetLogLevel(level string) error
+}
+
+// LogLevelHandler returns a handler reporting the current log level of the component.
+// The level is changed by POSTing the new level as the "level" parameter.
+func LogLevelHandler(l LogLeveler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejectChange(w, r, "level") {
+			return
+		}
+		if level := r.FormValue("level"); r.Method == http.MethodPost && level != "" {
+			if err := l.SetLogLevel(level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Log level of %s changed to %s", r.URL.Path, level)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintln(w, l.LogLevel())
+	})
+}
@@ -0,0 +1,40 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Maintainer is implemented by components that can be put into maintenance mode.
+type Maintainer interface {
+	Maintenance() (bool, string)
+	SetMaintenance(enabled bool, message string)
+}
+
+// MaintenanceHandler returns a handler reporting whether maintenance mode is enabled.
+// It is toggled by POSTing "true" or "false" as the "enabled" parameter, and the message
+// returned to clients is changed with the "message" parameter.
+func MaintenanceHandler(m Maintainer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejectChange(w, r, "enabled", "message") {
+			return
+		}
+		if raw := r.FormValue("enabled"); r.Method == http.MethodPost && raw != "" {
+			enabled, err := strconv.ParseBool(raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value %q for enabled", raw), http.StatusBadRequest)
+				return
+			}
+			m.SetMaintenance(enabled, r.FormValue("message"))
+		}
+
+		enabled, message := m.Maintenance()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !enabled {
+			_, _ = fmt.Fprintln(w, "disabled")
+			return
+		}
+		_, _ = fmt.Fprintf(w, "enabled: %s\n", message)
+	})
+}
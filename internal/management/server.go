@@ -0,0 +1,140 @@
+package management
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// Server serves operational endpoints on a separate listener, which is only reachable
+// from within the cluster and never exposed to the Tailscale network.
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+
+	// tls is the configuration of HTTPS if enabled, using the certificate files.
+	tls      *tls.Config
+	certFile string
+	keyFile  string
+
+	// token is required as bearer token for all endpoints except the public ones if set.
+	token  string
+	public []string
+}
+
+// TLSConfig configures the management server to serve HTTPS.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile enables client certificate authentication against the CAs in the
+	// file if set.
+	ClientCAFile string
+
+	// MinVersion is the minimum TLS version, either "1.2" or "1.3".
+	MinVersion string
+}
+
+// tlsVersions maps the supported minimum TLS versions to their identifiers.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewServer creates a management server listening on the given address.
+func NewServer(addr string) *Server {
+	return &Server{
+		addr: addr,
+		mux:  http.NewServeMux(),
+	}
+}
+
+// Handle registers the handler for the given pattern.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// SetTLS serves the endpoints over HTTPS with the given configuration.
+func (s *Server) SetTLS(config TLSConfig) error {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return errors.New("TLS requires both a certificate and a key")
+	}
+	minVersion, ok := tlsVersions[config.MinVersion]
+	if !ok {
+		return fmt.Errorf("unsupported minimum TLS version %q (expected 1.2 or 1.3)", config.MinVersion)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	s.tls, s.certFile, s.keyFile = tlsConfig, config.CertFile, config.KeyFile
+	return nil
+}
+
+// SetAuthToken requires the token as bearer token for all endpoints except the public
+// paths, which are meant for probes that can't authenticate, like health checks.
+func (s *Server) SetAuthToken(token string, public ...string) {
+	s.token = token
+	s.public = public
+}
+
+// ServeHTTP authenticates the request if a token is required before serving it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && !slices.Contains(s.public, r.URL.Path) {
+		expected := "Bearer " + s.token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			log.Printf("Management request to %s rejected, invalid token ip=%s", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Listen starts serving the management endpoints.
+func (s *Server) Listen() error {
+	if s.tls == nil {
+		log.Printf("Starting management server on %s...", s.addr)
+		return http.ListenAndServe(s.addr, s)
+	}
+
+	log.Printf("Starting management server with TLS on %s...", s.addr)
+	server := &http.Server{Addr: s.addr, Handler: s, TLSConfig: s.tls}
+	return server.ListenAndServeTLS(s.certFile, s.keyFile)
+}
+
+// rejectChange rejects requests that would change state without being POSTed, so that a
+// link or a crawler can't change it by accident: GET and HEAD requests passing one of
+// the parameters, and methods other than GET, HEAD and POST. It reports whether the
+// request was rejected.
+func rejectChange(w http.ResponseWriter, r *http.Request, params ...string) bool {
+	switch r.Method {
+	case http.MethodPost:
+		return false
+	case http.MethodGet, http.MethodHead:
+		if !slices.ContainsFunc(params, r.URL.Query().Has) {
+			return false
+		}
+	}
+
+	w.Header().Set("Allow", "GET, HEAD, POST")
+	http.Error(w, fmt.Sprintf("%s %s can't change state, use POST", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+	return true
+}
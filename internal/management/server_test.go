@@ -0,0 +1,168 @@
+package management
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a management server with a /healthcheck and a /debug endpoint.
+func newTestServer() *Server {
+	s := NewServer("")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = io.WriteString(w, "ok") })
+	s.Handle("/healthcheck", ok)
+	s.Handle("/debug", ok)
+	return s
+}
+
+func TestServerAuthToken(t *testing.T) {
+	s := newTestServer()
+	s.SetAuthToken("s3cret", "/healthcheck")
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "valid token", path: "/debug", authorization: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "missing token", path: "/debug", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", path: "/debug", authorization: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "token without scheme", path: "/debug", authorization: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "public healthcheck", path: "/healthcheck", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed CA if the
+// parent is nil.
+func newCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	issuer, signer := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServerClientCA(t *testing.T) {
+	ca := newCertificate(t, "management CA", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	s := newTestServer()
+	err := s.SetTLS(TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: caFile, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("SetTLS() error = %v", err)
+	}
+
+	// The test server brings its own serving certificate.
+	srv := httptest.NewUnstartedServer(s)
+	srv.TLS = s.tls
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	get := func(certs ...tls.Certificate) error {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(srv.URL + "/debug")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		return nil
+	}
+
+	if err = get(newCertificate(t, "prometheus", &ca)); err != nil {
+		t.Errorf("request with a client certificate of the CA failed: %v", err)
+	}
+	if err = get(); err == nil {
+		t.Error("request without a client certificate succeeded, want a TLS error")
+	}
+	if err = get(newCertificate(t, "attacker", nil)); err == nil {
+		t.Error("request with a client certificate of another CA succeeded, want a TLS error")
+	}
+}
+
+func TestSetTLSErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config TLSConfig
+	}{
+		{name: "missing key", config: TLSConfig{CertFile: "tls.crt", MinVersion: "1.2"}},
+		{name: "unsupported version", config: TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.1"}},
+		{name: "missing CA file", config: TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", ClientCAFile: "missing.pem"}},
+		{name: "empty CA file", config: TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", ClientCAFile: empty}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewServer("").SetTLS(tt.config); err == nil {
+				t.Error("SetTLS() error = nil, want an error")
+			}
+		})
+	}
+}
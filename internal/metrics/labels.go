@@ -0,0 +1,39 @@
+package metrics
+
+import "sync"
+
+// OtherLabel is used for all label values beyond the limit of a LabelLimiter.
+const OtherLabel = "other"
+
+// LabelLimiter caps the number of distinct values of an unbounded label like the
+// username, to keep the cardinality of metrics in check. The first values seen are
+// passed through, all further values are replaced by OtherLabel.
+type LabelLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	values map[string]struct{}
+}
+
+// NewLabelLimiter creates a limiter allowing up to limit distinct values.
+func NewLabelLimiter(limit int) *LabelLimiter {
+	return &LabelLimiter{
+		limit:  limit,
+		values: make(map[string]struct{}),
+	}
+}
+
+// Value returns the label value to use for the given value.
+func (l *LabelLimiter) Value(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.values[value]; ok {
+		return value
+	}
+	if len(l.values) >= l.limit {
+		return OtherLabel
+	}
+
+	l.values[value] = struct{}{}
+	return value
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/podinfo"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the common prefix of all metrics of the proxy.
+const Namespace = "tailscale_kube_proxy"
+
+// Registry holds all metrics of the proxy.
+var Registry = prometheus.NewRegistry()
+
+// Registerer registers metrics in the Registry, labeled with the pod they originate
+// from to tell the metrics of multiple replicas apart.
+var Registerer = prometheus.WrapRegistererWith(prometheus.Labels{
+	"pod":       podinfo.Name(),
+	"namespace": podinfo.Namespace(),
+}, Registry)
+
+func init() {
+	Registerer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics of the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/podinfo"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPodLabels(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Namespace: Namespace, Name: "test_pod_labels_total"})
+	Registerer.MustRegister(counter)
+	t.Cleanup(func() { Registerer.Unregister(counter) })
+
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != Namespace+"_test_pod_labels_total" {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["pod"] != podinfo.Name() || labels["namespace"] != podinfo.Namespace() {
+			t.Errorf("labels = %v, want the pod %s/%s", labels, podinfo.Namespace(), podinfo.Name())
+		}
+		return
+	}
+	t.Fatal("metric is not exposed by the registry")
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/podinfo"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushJob is the job label of metrics pushed to a Pushgateway.
+const pushJob = "tailscale_kube_proxy"
+
+// Push periodically pushes the metrics of the registry to the Prometheus Pushgateway at
+// the URL until the context is canceled, for instances that can't be scraped. The
+// metrics of each pod are grouped by its name, so replicas don't overwrite each other.
+func Push(ctx context.Context, url string, interval time.Duration) {
+	pusher := push.New(url, pushJob).
+		Gatherer(Registry).
+		Grouping("instance", podinfo.Name())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: failed to push metrics to %s: %v", url, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
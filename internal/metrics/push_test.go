@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/podinfo"
+)
+
+func TestPush(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		pushes []*http.Request
+		bodies [][]byte
+	)
+	var failing atomic.Bool
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushes = append(pushes, r)
+		bodies = append(bodies, body)
+		mu.Unlock()
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(gateway.Close)
+
+	var buf bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(output) })
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Push(ctx, gateway.URL, 10*time.Millisecond)
+	}()
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(pushes)
+	}
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for count() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("gateway received %d pushes, want %d", count(), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	// The metrics are pushed right away and then on every tick.
+	waitFor(2)
+	failing.Store(true)
+	waitFor(count() + 1)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Push() did not return after the context was canceled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "/metrics/job/" + pushJob + "/instance/" + podinfo.Name()
+	for i, req := range pushes {
+		if req.Method != http.MethodPut || req.URL.Path != want {
+			t.Errorf("push %d = %s %s, want PUT %s", i, req.Method, req.URL.Path, want)
+		}
+		if !bytes.Contains(bodies[i], []byte("go_goroutines")) {
+			t.Errorf("push %d doesn't contain the metrics of the registry", i)
+		}
+	}
+	if !strings.Contains(buf.String(), "Warning: failed to push metrics to "+gateway.URL) {
+		t.Errorf("log = %q, want a warning about the failed push", buf.String())
+	}
+}
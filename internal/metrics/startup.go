@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// startTime approximates the start of the process, as packages are initialized first.
+var startTime = time.Now()
+
+var startupDuration = promauto.With(Registerer).NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "startup_duration_seconds",
+	Help:      "Time from process start until the proxy was ready to serve requests.",
+})
+
+// ObserveReady records the time from process start until now as startup duration and
+// returns it.
+func ObserveReady() time.Duration {
+	duration := time.Since(startTime)
+	startupDuration.Set(duration.Seconds())
+	return duration
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveReady(t *testing.T) {
+	time.Sleep(10 * time.Millisecond)
+
+	duration := ObserveReady()
+	if duration < 10*time.Millisecond {
+		t.Errorf("ObserveReady() = %s, want the time since the process started", duration)
+	}
+	if got := promtestutil.ToFloat64(startupDuration); got != duration.Seconds() {
+		t.Errorf("startup duration = %vs, want %vs", got, duration.Seconds())
+	}
+}
@@ -0,0 +1,26 @@
+// Package podinfo identifies the pod the proxy runs in, which disambiguates logs and
+// metrics of multiple replicas. The values are read from the POD_NAME and POD_NAMESPACE
+// environment variables, which are usually populated via the downward API.
+package podinfo
+
+import "os"
+
+// Name returns the name of the pod, falling back to the hostname, which Kubernetes sets
+// to the pod name by default.
+func Name() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "unknown"
+}
+
+// Namespace returns the namespace of the pod, or "unknown" if it is not set.
+func Namespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	return "unknown"
+}
@@ -0,0 +1,34 @@
+package podinfo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPodInfo(t *testing.T) {
+	t.Setenv("POD_NAME", "tailscale-kube-proxy-7d9f8-x2k4q")
+	t.Setenv("POD_NAMESPACE", "proxy")
+
+	if got := Name(); got != "tailscale-kube-proxy-7d9f8-x2k4q" {
+		t.Errorf("Name() = %q, want the name of the downward API", got)
+	}
+	if got := Namespace(); got != "proxy" {
+		t.Errorf("Namespace() = %q, want the namespace of the downward API", got)
+	}
+}
+
+func TestPodInfoDefaults(t *testing.T) {
+	t.Setenv("POD_NAME", "")
+	t.Setenv("POD_NAMESPACE", "")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("os.Hostname() error = %v", err)
+	}
+	if got := Name(); got != hostname {
+		t.Errorf("Name() = %q, want the hostname %q", got, hostname)
+	}
+	if got := Namespace(); got != "unknown" {
+		t.Errorf("Namespace() = %q, want unknown", got)
+	}
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// activeUserSet tracks the Tailscale users that sent a request within a sliding window,
+// showing how many humans and automations actively use the proxy.
+type activeUserSet struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   *lruMap[string, time.Time]
+	now    func() time.Time
+}
+
+// newActiveUserSet creates an empty set with the given window, tracking up to maxUsers.
+func newActiveUserSet(window time.Duration, maxUsers int) *activeUserSet {
+	return &activeUserSet{
+		window: window,
+		seen:   newLRUMap[string, time.Time](maxUsers),
+		now:    time.Now,
+	}
+}
+
+// observe records a request of the user.
+func (a *activeUserSet) observe(login string) {
+	if login == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seen.put(login, a.now())
+}
+
+// count removes the users whose last request is outside of the window and returns the
+// number of remaining users.
+func (a *activeUserSet) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Users are ordered by their last request, so the inactive ones are the oldest.
+	cutoff := a.now().Add(-a.window)
+	a.seen.removeOldest(func(last time.Time) bool {
+		return last.Before(cutoff)
+	})
+	return a.seen.len()
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestActiveUsersPerProxy(t *testing.T) {
+	before := promtestutil.ToFloat64(activeUsersGauge)
+	short, shortSrv, _ := newTestProxy(t, map[string]any{"active_user_window": time.Minute})
+	long, _, _ := newTestProxy(t, map[string]any{"active_user_window": time.Hour})
+
+	resp, err := http.Get(shortSrv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if short.activeUsers.window != time.Minute || long.activeUsers.window != time.Hour {
+		t.Errorf("windows = %s and %s, want the window of each proxy", short.activeUsers.window, long.activeUsers.window)
+	}
+	if got := long.activeUsers.count(); got != 0 {
+		t.Errorf("active users of the other proxy = %d, want 0", got)
+	}
+	if got := promtestutil.ToFloat64(activeUsersGauge) - before; got != 1 {
+		t.Errorf("active users gauge increased by %v, want 1", got)
+	}
+
+	if err = short.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := promtestutil.ToFloat64(activeUsersGauge) - before; got != 0 {
+		t.Errorf("active users gauge increased by %v after Close(), want 0", got)
+	}
+}
+
+func TestActiveUsersDecay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	users := newActiveUserSet(time.Hour, 0)
+	users.now = func() time.Time { return now }
+
+	users.observe("alice@example.com")
+	users.observe("")
+	now = now.Add(30 * time.Minute)
+	users.observe("bob@example.com")
+	if got := users.count(); got != 2 {
+		t.Errorf("count() = %d, want 2", got)
+	}
+
+	// Alice's request leaves the window, Bob's doesn't.
+	now = now.Add(31 * time.Minute)
+	if got := users.count(); got != 1 {
+		t.Errorf("count() after an hour = %d, want 1", got)
+	}
+
+	// A new request brings Alice back.
+	users.observe("alice@example.com")
+	if got := users.count(); got != 2 {
+		t.Errorf("count() after a new request = %d, want 2", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := users.count(); got != 0 {
+		t.Errorf("count() after two hours = %d, want 0", got)
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Authorization decisions recorded in the audit annotations, as used by the API server.
+const (
+	decisionAllow  = "allow"
+	decisionForbid = "forbid"
+)
+
+// auditEvent is the subset of the audit.k8s.io/v1 Event written by the proxy, so that
+// its decisions can be ingested by the same pipelines as the API server's audit log.
+type auditEvent struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Level                    string            `json:"level"`
+	AuditID                  types.UID         `json:"auditID"`
+	Stage                    string            `json:"stage"`
+	RequestURI               string            `json:"requestURI"`
+	Verb                     string            `json:"verb"`
+	User                     authnv1.UserInfo  `json:"user"`
+	SourceIPs                []string          `json:"sourceIPs,omitempty"`
+	UserAgent                string            `json:"userAgent,omitempty"`
+	ObjectRef                *auditObjectRef   `json:"objectRef,omitempty"`
+	ResponseStatus           *metav1.Status    `json:"responseStatus,omitempty"`
+	RequestReceivedTimestamp metav1.MicroTime  `json:"requestReceivedTimestamp"`
+	StageTimestamp           metav1.MicroTime  `json:"stageTimestamp"`
+	Annotations              map[string]string `json:"annotations,omitempty"`
+}
+
+// auditObjectRef is the audit.k8s.io/v1 ObjectReference of the requested resource.
+type auditObjectRef struct {
+	Resource    string `json:"resource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	APIGroup    string `json:"apiGroup,omitempty"`
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+}
+
+// auditLogger writes an audit event for every request handled by the proxy, one JSON
+// object per line, and publishes it to the audit stream if configured.
+type auditLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	stream *auditStream
+}
+
+// newAuditLogger returns a logger appending to the file, or writing to stdout if the
+// path is '-'. It returns nil if no path is configured.
+func newAuditLogger(path string) (*auditLogger, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return &auditLogger{w: os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open audit log: %w", ErrInvalidConfig, err)
+	}
+	return &auditLogger{w: file}, nil
+}
+
+// log writes the event for the completed request. Requests that were forwarded to the
+// API server are recorded as allowed, all others as forbidden by the proxy.
+func (a *auditLogger) log(req *http.Request, received time.Time, code int, forwarded bool) {
+	id := identityFrom(req.Context())
+	info := parseRequestInfo(req)
+
+	event := auditEvent{
+		TypeMeta:   metav1.TypeMeta{Kind: "Event", APIVersion: "audit.k8s.io/v1"},
+		Level:      "Metadata",
+		AuditID:    uuid.NewUUID(),
+		Stage:      "ResponseComplete",
+		RequestURI: req.URL.RequestURI(),
+		Verb:       info.Verb,
+		User: authnv1.UserInfo{
+			Username: id.User,
+			Groups:   id.Groups,
+		},
+		UserAgent:                req.UserAgent(),
+		ResponseStatus:           &metav1.Status{Code: int32(code)},
+		RequestReceivedTimestamp: metav1.NewMicroTime(received),
+		StageTimestamp:           metav1.NewMicroTime(time.Now()),
+		Annotations: map[string]string{
+			"authorization.k8s.io/decision": decisionForbid,
+		},
+	}
+	if id.Login != "" {
+		event.User.Extra = map[string]authnv1.ExtraValue{"tailscale.com/login": {id.Login}}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		event.SourceIPs = []string{host}
+	}
+	if info.IsResourceRequest {
+		event.ObjectRef = &auditObjectRef{
+			Resource:    info.Resource,
+			Namespace:   info.Namespace,
+			Name:        info.Name,
+			APIGroup:    info.APIGroup,
+			APIVersion:  info.APIVersion,
+			Subresource: info.Subresource,
+		}
+	}
+	if forwarded {
+		event.Annotations["authorization.k8s.io/decision"] = decisionAllow
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Warning: failed to encode audit event: %v", err)
+		return
+	}
+
+	if a.stream != nil {
+		a.stream.publish(line)
+	}
+	if a.w == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err = a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Warning: failed to write audit event: %v", err)
+	}
+}
+
+// statusRecorder captures the status code written to the response, and the message of
+// Status objects written by the proxy.
+type statusRecorder struct {
+	http.ResponseWriter
+	code    int
+	message string
+}
+
+// WriteHeader records the status code before writing it. Informational responses like
+// 103 Early Hints precede the final one and are skipped, except for 101 Switching
+// Protocols, which is the final response of an upgrade.
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the implicit status code if no header was written before.
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original writer, so that flushing and hijacking keep working
+// through http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
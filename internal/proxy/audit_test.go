@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []int
+		want  int
+	}{
+		{name: "final", codes: []int{http.StatusNotFound}, want: http.StatusNotFound},
+		{name: "early hints", codes: []int{http.StatusEarlyHints, http.StatusOK}, want: http.StatusOK},
+		{name: "continue", codes: []int{http.StatusContinue, http.StatusCreated}, want: http.StatusCreated},
+		{name: "switching protocols", codes: []int{http.StatusSwitchingProtocols}, want: http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+			for _, code := range tt.codes {
+				rec.WriteHeader(code)
+			}
+			if rec.code != tt.want {
+				t.Errorf("code = %d, want %d", rec.code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditEventSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	_, srv, _ := newTestProxy(t, map[string]any{"audit_log": path})
+
+	resp, err := http.Get(srv.URL + "/api/v1/namespaces/default/pods/web")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var event struct {
+		Kind       string `json:"kind"`
+		APIVersion string `json:"apiVersion"`
+		Stage      string `json:"stage"`
+		User       struct {
+			Username string              `json:"username"`
+			Groups   []string            `json:"groups"`
+			Extra    map[string][]string `json:"extra"`
+		} `json:"user"`
+		ObjectRef struct {
+			Resource string `json:"resource"`
+			Name     string `json:"name"`
+		} `json:"objectRef"`
+		ResponseStatus struct {
+			Code int `json:"code"`
+		} `json:"responseStatus"`
+	}
+	if err = json.Unmarshal([]byte(strings.TrimSpace(string(content))), &event); err != nil {
+		t.Fatalf("failed to decode audit event %q: %v", content, err)
+	}
+
+	if event.Kind != "Event" || event.APIVersion != "audit.k8s.io/v1" {
+		t.Errorf("kind = %q, apiVersion = %q, want an audit.k8s.io/v1 Event", event.Kind, event.APIVersion)
+	}
+	if event.Stage != "ResponseComplete" {
+		t.Errorf("stage = %q, want ResponseComplete", event.Stage)
+	}
+	if event.User.Username != testLogin || !slices.Contains(event.User.Groups, "developers") {
+		t.Errorf("user = %q in %v, want %q in developers", event.User.Username, event.User.Groups, testLogin)
+	}
+	if got := event.User.Extra["tailscale.com/login"]; !slices.Equal(got, []string{testLogin}) {
+		t.Errorf("login = %v, want %q", got, testLogin)
+	}
+	if event.ObjectRef.Resource != "pods" || event.ObjectRef.Name != "web" {
+		t.Errorf("objectRef = %+v, want the pod web", event.ObjectRef)
+	}
+	if event.ResponseStatus.Code != http.StatusOK {
+		t.Errorf("response code = %d, want %d", event.ResponseStatus.Code, http.StatusOK)
+	}
+}
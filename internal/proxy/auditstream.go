@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// auditStream publishes audit events to a NATS subject in the background. Events are
+// queued in a bounded buffer and dropped if it is full, so that a slow or unavailable
+// stream never delays requests.
+type auditStream struct {
+	conn    *nats.Conn
+	subject string
+
+	// mu guards closing the events, which are published until run returns and closes done.
+	mu     sync.RWMutex
+	closed bool
+	events chan []byte
+	done   chan struct{}
+}
+
+// newAuditStream connects to the NATS server at the URL, or returns nil if no URL is
+// configured. The connection is retried in the background if the server is unavailable,
+// so the proxy starts regardless.
+func newAuditStream(url, subject string, size int) (*auditStream, error) {
+	if url == "" {
+		return nil, nil
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("%w: audit stream subject must not be empty", ErrInvalidConfig)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: audit stream buffer must be positive", ErrInvalidConfig)
+	}
+
+	conn, err := nats.Connect(url,
+		nats.Name("tailscale-kube-proxy"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("Warning: disconnected from audit stream: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("Reconnected to audit stream at %s", conn.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid audit stream URL: %w", ErrInvalidConfig, err)
+	}
+
+	stream := &auditStream{conn: conn, subject: subject, events: make(chan []byte, size), done: make(chan struct{})}
+	go stream.run()
+	return stream, nil
+}
+
+// publish queues the event, dropping it if the buffer is full.
+func (s *auditStream) publish(event []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		auditStreamDropped.Inc()
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		auditStreamDropped.Inc()
+	}
+}
+
+// run publishes the queued events. While disconnected, the NATS client buffers them
+// itself until its reconnect buffer is full, after which they are dropped as well.
+func (s *auditStream) run() {
+	defer close(s.done)
+	for event := range s.events {
+		if err := s.conn.Publish(s.subject, event); err != nil {
+			auditStreamDropped.Inc()
+			log.Printf("Warning: failed to publish audit event: %v", err)
+		}
+	}
+}
+
+// close publishes the queued events and drains the connection, so that the events
+// buffered by the NATS client are sent as well, before closing it. Events published
+// afterwards are dropped.
+func (s *auditStream) close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+
+	closed := make(chan struct{})
+	s.conn.SetClosedHandler(func(*nats.Conn) { close(closed) })
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.conn.Close()
+		return fmt.Errorf("failed to publish the queued audit events: %w", ctx.Err())
+	}
+	if err := s.conn.Drain(); err != nil {
+		s.conn.Close()
+		return fmt.Errorf("failed to drain the audit stream: %w", err)
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		s.conn.Close()
+		return fmt.Errorf("failed to drain the audit stream: %w", ctx.Err())
+	}
+}
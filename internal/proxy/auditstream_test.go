@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a minimal NATS server recording the payloads published to it.
+type fakeNATS struct {
+	addr string
+
+	mu        sync.Mutex
+	published []string
+	closed    chan struct{}
+}
+
+// newFakeNATS starts a fake NATS server serving one client, which is stopped when the
+// test finishes.
+func newFakeNATS(t *testing.T) *fakeNATS {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	fake := &fakeNATS{addr: ln.Addr().String(), closed: make(chan struct{})}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fake.serve(conn)
+	}()
+	return fake
+}
+
+// serve speaks the NATS protocol on the connection until the client closes it.
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer close(f.closed)
+
+	_, _ = fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.published = append(f.published, string(payload[:size]))
+			f.mu.Unlock()
+		}
+	}
+}
+
+func (f *fakeNATS) events() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.published...)
+}
+
+func TestAuditStreamIsDrainedOnShutdown(t *testing.T) {
+	nats := newFakeNATS(t)
+	p, srv, _ := newTestProxy(t, map[string]any{
+		"audit_stream_url":     "nats://" + nats.addr,
+		"audit_stream_subject": "audit",
+		"audit_stream_buffer":  16,
+	})
+
+	for range 3 {
+		resp, err := http.Get(srv.URL + "/api/v1/pods")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	select {
+	case <-nats.closed:
+	case <-time.After(time.Second):
+		t.Fatal("the connection to the audit stream was not closed")
+	}
+	events := nats.events()
+	if len(events) != 3 {
+		t.Fatalf("published %d events, want 3", len(events))
+	}
+	if !strings.Contains(events[0], `"requestURI":"/api/v1/pods"`) {
+		t.Errorf("event = %s, want the audit event of the request", events[0])
+	}
+
+	// Events of requests after the shutdown are dropped.
+	resp, err := http.Get(srv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request after shutdown failed: %v", err)
+	}
+	resp.Body.Close()
+	if err = p.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown() error = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxLoggedBodySize limits the size of request bodies that are logged.
+const maxLoggedBodySize = 16 << 10
+
+// redacted replaces sensitive values in logged request bodies.
+const redacted = "<redacted>"
+
+// sensitiveFields contains substrings of JSON field names whose values are redacted.
+var sensitiveFields = []string{"password", "passwd", "token", "secret", "credential", "privatekey", "apikey"}
+
+// logRequestBody logs the JSON body of a mutating request for debugging, e.g. to see
+// why an admission webhook rejected it. Bodies of streaming requests, bodies without a
+// known length and bodies larger than maxLoggedBodySize are never read. The contents
+// of secrets and fields that look like credentials are redacted.
+func logRequestBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody || !isMutating(req) || isLongRunning(req) {
+		return
+	}
+
+	id := identityFrom(req.Context())
+	if req.ContentLength < 0 || req.ContentLength > maxLoggedBodySize {
+		log.Printf("%s %s body user=%s: %d bytes not logged", req.Method, req.URL.Path, id.LoginName(), req.ContentLength)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		log.Printf("%s %s body user=%s: %s not logged", req.Method, req.URL.Path, id.LoginName(), mediaType)
+		return
+	}
+
+	// Restore the body, so that it is forwarded unchanged.
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	log.Printf("%s %s body user=%s: %s", req.Method, req.URL.Path, id.LoginName(), redactBody(body, parseRequestInfo(req).Resource == "secrets"))
+}
+
+// redactBody returns the JSON body with sensitive values redacted. Bodies for the
+// secrets resource are redacted entirely, as even patches consist of secret data.
+func redactBody(body []byte, secret bool) string {
+	if secret {
+		return redacted
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return redacted
+	}
+
+	var out strings.Builder
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(redactValue(value))
+	return strings.TrimSpace(out.String())
+}
+
+// redactValue redacts sensitive fields in the decoded JSON value, including the data of
+// embedded Secret objects, e.g. in lists.
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		isSecret := v["kind"] == "Secret"
+		for key, field := range v {
+			if isSensitiveField(key) || (isSecret && (key == "data" || key == "stringData")) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// isSensitiveField reports whether the field name suggests that it holds a credential.
+func isSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		secret bool
+		want   string
+	}{
+		{
+			name: "credential fields",
+			body: `{"kind":"ConfigMap","data":{"password":"hunter2","apiToken":"abc","replicas":"3"}}`,
+			want: `{"data":{"apiToken":"<redacted>","password":"<redacted>","replicas":"3"},"kind":"ConfigMap"}`,
+		},
+		{
+			name: "embedded secret",
+			body: `{"kind":"List","items":[{"kind":"Secret","metadata":{"name":"db"},"stringData":{"user":"admin"}}]}`,
+			want: `{"items":[{"kind":"Secret","metadata":{"name":"db"},"stringData":"<redacted>"}],"kind":"List"}`,
+		},
+		{
+			name:   "secrets resource",
+			body:   `{"data":{"user":"YWRtaW4="}}`,
+			secret: true,
+			want:   redacted,
+		},
+		{
+			name: "invalid JSON",
+			body: `{"password":`,
+			want: redacted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactBody([]byte(tt.body), tt.secret); got != tt.want {
+				t.Errorf("redactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBodyLogging(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantLog  string
+		leakable string
+	}{
+		{
+			name:     "redacted",
+			path:     "/api/v1/namespaces/default/configmaps",
+			body:     `{"kind":"ConfigMap","data":{"apiToken":"abc123"}}`,
+			wantLog:  `{"data":{"apiToken":"<redacted>"},"kind":"ConfigMap"}`,
+			leakable: "abc123",
+		},
+		{
+			name:     "secret",
+			path:     "/api/v1/namespaces/default/secrets",
+			body:     `{"kind":"Secret","data":{"key":"c2VjcmV0"}}`,
+			wantLog:  "body user=" + testLogin + ": " + redacted,
+			leakable: "c2VjcmV0",
+		},
+		{
+			name:    "too large",
+			path:    "/api/v1/namespaces/default/configmaps",
+			body:    `{"kind":"ConfigMap","data":{"large":"` + strings.Repeat("x", maxLoggedBodySize) + `"}}`,
+			wantLog: "bytes not logged",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			_, srv, api := newTestProxy(t, map[string]any{"log_request_bodies": true})
+			received := make(chan string, 1)
+			api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received <- string(body)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			resp, err := http.Post(srv.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if got := <-received; got != tt.body {
+				t.Errorf("API server received %d bytes, want the unchanged body of %d bytes", len(got), len(tt.body))
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log = %q, want %q", logs.String(), tt.wantLog)
+			}
+			if tt.leakable != "" && strings.Contains(logs.String(), tt.leakable) {
+				t.Errorf("log contains the sensitive value %q", tt.leakable)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+
+	"cel.dev/cel-go/cel"
+	"cel.dev/cel-go/common/types"
+	"cel.dev/cel-go/common/types/traits"
+	"cel.dev/cel-go/ext"
+	"github.com/spf13/viper"
+)
+
+// celCostLimit bounds the cost of evaluating the identity expression, so that it can't
+// stall requests.
+const celCostLimit = 1_000_000
+
+// celMapper derives the Kubernetes identity of a Tailscale user with a CEL expression.
+// The expression has access to the variables
+//
+//	user         map with the login, displayName and id of the Tailscale user
+//	groups       list of the user's groups reported by the control server
+//	tags         list of the ACL tags of the client's node
+//	capabilities map of the application capabilities granted to the node
+//
+// as well as the string and list extension functions, and evaluates to either the
+// username, or a map with the "user" and "groups" keys.
+type celMapper struct {
+	program cel.Program
+}
+
+// newCELMapper compiles the identity expression from the configuration, or returns nil
+// if no expression is configured.
+func newCELMapper(settings *viper.Viper) (*celMapper, error) {
+	expr := settings.GetString("identity_cel")
+	if expr == "" {
+		return nil, nil
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("user", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("tags", cel.ListType(cel.StringType)),
+		cel.Variable("capabilities", cel.MapType(cel.StringType, cel.ListType(cel.DynType))),
+		ext.Strings(),
+		ext.Lists(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("%w: invalid identity expression: %v", ErrInvalidConfig, issues.Err())
+	}
+	switch ast.OutputType().Kind() {
+	case types.StringKind, types.MapKind, types.DynKind:
+	default:
+		return nil, fmt.Errorf("%w: identity expression returns %s, expected a string or map", ErrInvalidConfig, ast.OutputType())
+	}
+
+	program, err := env.Program(ast, cel.CostLimit(celCostLimit))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid identity expression: %v", ErrInvalidConfig, err)
+	}
+	return &celMapper{program: program}, nil
+}
+
+// evaluate returns the identity the expression derives for the Tailscale user.
+func (m *celMapper) evaluate(ctx context.Context, user *tailscale.UserProfile) (*mappedIdentity, error) {
+	capabilities := make(map[string][]any, len(user.Capabilities))
+	for name, raw := range user.Capabilities {
+		values := make([]any, 0, len(raw))
+		for _, msg := range raw {
+			var value any
+			if err := json.Unmarshal([]byte(msg), &value); err != nil {
+				return nil, fmt.Errorf("invalid value of capability %s: %w", name, err)
+			}
+			values = append(values, value)
+		}
+		capabilities[string(name)] = values
+	}
+
+	out, _, err := m.program.ContextEval(ctx, map[string]any{
+		"user": map[string]any{
+			"login":       user.LoginName,
+			"displayName": user.DisplayName,
+			"id":          int64(user.ID),
+		},
+		"groups":       nonNil(user.Groups),
+		"tags":         nonNil(user.Tags),
+		"capabilities": capabilities,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("identity expression failed: %w", err)
+	}
+
+	id := new(mappedIdentity)
+	switch out := out.(type) {
+	case types.String:
+		id.User = string(out)
+	case traits.Mapper:
+		if user, ok := out.Find(types.String("user")); ok {
+			name, ok := user.(types.String)
+			if !ok {
+				return nil, fmt.Errorf("identity expression returned user of type %s, expected string", user.Type())
+			}
+			id.User = string(name)
+		}
+		if groups, ok := out.Find(types.String("groups")); ok {
+			native, err := groups.ConvertToNative(reflect.TypeFor[[]string]())
+			if err != nil {
+				return nil, fmt.Errorf("identity expression returned invalid groups: %w", err)
+			}
+			id.Groups = native.([]string)
+		}
+	default:
+		return nil, fmt.Errorf("identity expression returned %s, expected a string or map", out.Type())
+	}
+
+	if id.User == "" {
+		return nil, fmt.Errorf("identity expression returned no user for %s", user.LoginName)
+	}
+	return id, nil
+}
+
+// nonNil returns an empty slice instead of nil, which CEL can't convert to a list.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+
+	"tailscale.com/tailcfg"
+)
+
+// compileCEL compiles the identity expression like the proxy's configuration would.
+func compileCEL(expr string) (*celMapper, error) {
+	return newCELMapper(settingsWith(map[string]any{"identity_cel": expr}))
+}
+
+func TestCELMapper(t *testing.T) {
+	user := &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{ID: 42, LoginName: "alice@example.com", DisplayName: "Alice", Groups: []string{"developers"}},
+		Tags:        []string{"tag:dev"},
+		Capabilities: tailcfg.PeerCapMap{
+			"example.com/cap/kubernetes": {`{"groups":["viewers"],"admin":true}`, `{"groups":["editors"]}`},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		expr       string
+		user       *tailscale.UserProfile
+		wantUser   string
+		wantGroups []string
+		wantErr    string
+	}{
+		{
+			name:     "string result",
+			expr:     `user.login.split("@")[0]`,
+			wantUser: "alice",
+		},
+		{
+			name:       "map result",
+			expr:       `{"user": "ts:" + user.login, "groups": groups + tags}`,
+			wantUser:   "ts:alice@example.com",
+			wantGroups: []string{"developers", "tag:dev"},
+		},
+		{
+			name:     "map without groups",
+			expr:     `{"user": user.displayName}`,
+			wantUser: "Alice",
+		},
+		{
+			name:       "capabilities",
+			expr:       `{"user": user.login, "groups": capabilities["example.com/cap/kubernetes"].map(c, c.groups).flatten()}`,
+			wantUser:   "alice@example.com",
+			wantGroups: []string{"viewers", "editors"},
+		},
+		{
+			name:     "capability field",
+			expr:     `capabilities["example.com/cap/kubernetes"].exists(c, has(c.admin) && c.admin) ? "admin" : user.login`,
+			wantUser: "admin",
+		},
+		{
+			name:     "id",
+			expr:     `"user-" + string(user.id)`,
+			wantUser: "user-42",
+		},
+		{
+			name:    "empty user",
+			expr:    `""`,
+			wantErr: "returned no user for alice@example.com",
+		},
+		{
+			name:    "user of wrong type",
+			expr:    `{"user": user.id}`,
+			wantErr: "returned user of type int, expected string",
+		},
+		{
+			name:    "dynamic result of wrong type",
+			expr:    `user.id`,
+			wantErr: "expected a string or map",
+		},
+		{
+			name:    "invalid capability",
+			expr:    `user.login`,
+			user:    &tailscale.UserProfile{UserProfile: user.UserProfile, Capabilities: tailcfg.PeerCapMap{"example.com/cap/kubernetes": {`{`}}},
+			wantErr: "invalid value of capability example.com/cap/kubernetes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := compileCEL(tt.expr)
+			if err != nil {
+				t.Fatalf("newCELMapper() error = %v", err)
+			}
+			u := user
+			if tt.user != nil {
+				u = tt.user
+			}
+
+			id, err := m.evaluate(context.Background(), u)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evaluate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evaluate() error = %v", err)
+			}
+			if id.User != tt.wantUser || !slices.Equal(id.Groups, tt.wantGroups) {
+				t.Errorf("evaluate() = %q in %v, want %q in %v", id.User, id.Groups, tt.wantUser, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestCELMapperCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "bool result", expr: `user.login == "alice@example.com"`},
+		{name: "list result", expr: `groups`},
+		{name: "int result", expr: `size(groups)`},
+		{name: "unknown variable", expr: `node.name`},
+		{name: "syntax error", expr: `user.login +`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileCEL(tt.expr); err == nil {
+				t.Errorf("newCELMapper(%q) error = nil, want a compile error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestCELMapperNotConfigured(t *testing.T) {
+	if m, err := compileCEL(""); m != nil || err != nil {
+		t.Errorf("newCELMapper() = %v, %v, want no mapper", m, err)
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// Supported handling of the Authorization header sent by clients. The credentials of
+// the proxy are only used for requests without an Authorization header, as the Kubernetes
+// client doesn't replace an existing one.
+const (
+	// clientAuthStrip removes the client's header, so the proxy's credentials are used.
+	clientAuthStrip = "strip"
+	// clientAuthHeader moves the client's header to another header, so the proxy's
+	// credentials are used and the client's remain available to downstream consumers.
+	clientAuthHeader = "header"
+	// clientAuthForward keeps the client's header, which then takes precedence over the
+	// proxy's credentials.
+	clientAuthForward = "forward"
+)
+
+// clientAuth decides what happens to the Authorization header of client requests.
+type clientAuth struct {
+	mode   string
+	header string
+}
+
+// newClientAuth creates the client authorization handling from the configuration.
+func newClientAuth(settings *viper.Viper) (*clientAuth, error) {
+	c := &clientAuth{
+		mode:   settings.GetString("client_authorization"),
+		header: http.CanonicalHeaderKey(settings.GetString("client_authorization_header")),
+	}
+
+	switch c.mode {
+	case clientAuthStrip, clientAuthForward:
+	case clientAuthHeader:
+		if c.header == "" || c.header == "Authorization" {
+			return nil, fmt.Errorf("%w: invalid client authorization header %q", ErrInvalidConfig, c.header)
+		}
+	default:
+		return nil, fmt.Errorf("%w: invalid client authorization %q (expected %s, %s or %s)", ErrInvalidConfig,
+			c.mode, clientAuthStrip, clientAuthHeader, clientAuthForward)
+	}
+	return c, nil
+}
+
+// apply sets the Authorization header of the outgoing request from the incoming one.
+// It runs after unknown headers were stripped, which doesn't affect the decision.
+func (c *clientAuth) apply(in, out http.Header) {
+	value := in.Get("Authorization")
+	out.Del("Authorization")
+
+	switch c.mode {
+	case clientAuthHeader:
+		// Never forward a value set by the client itself.
+		out.Del(c.header)
+		if value != "" {
+			out.Set(c.header, value)
+		}
+	case clientAuthForward:
+		if value != "" {
+			out.Set("Authorization", value)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+)
+
+func TestClientAuthorization(t *testing.T) {
+	const proxyToken, clientToken = "Bearer proxy-token", "Bearer client-token"
+	tests := []struct {
+		mode          string
+		authorization string
+		spoofed       string
+		wantAuth      string
+		wantForwarded string
+		stripUnknown  bool
+	}{
+		{mode: clientAuthStrip, wantAuth: proxyToken},
+		{mode: clientAuthStrip, authorization: clientToken, wantAuth: proxyToken},
+		{mode: clientAuthHeader, wantAuth: proxyToken},
+		{mode: clientAuthHeader, authorization: clientToken, wantAuth: proxyToken, wantForwarded: clientToken},
+		{mode: clientAuthHeader, spoofed: clientToken, wantAuth: proxyToken},
+		{mode: clientAuthHeader, authorization: clientToken, spoofed: "Bearer spoofed", wantAuth: proxyToken, wantForwarded: clientToken},
+		{mode: clientAuthHeader, authorization: clientToken, wantAuth: proxyToken, wantForwarded: clientToken, stripUnknown: true},
+		{mode: clientAuthForward, wantAuth: proxyToken},
+		{mode: clientAuthForward, authorization: clientToken, wantAuth: clientToken},
+		{mode: clientAuthForward, authorization: clientToken, wantAuth: clientToken, stripUnknown: true},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s authorization=%t spoofed=%t strip=%t", tt.mode, tt.authorization != "", tt.spoofed != "", tt.stripUnknown)
+		t.Run(name, func(t *testing.T) {
+			_, srv, _ := newTestProxy(t, map[string]any{
+				"client_authorization":  tt.mode,
+				"strip_unknown_headers": tt.stripUnknown,
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.spoofed != "" {
+				req.Header.Set("X-Forwarded-Authorization", tt.spoofed)
+			}
+			echo := doEcho(t, req)
+
+			if got := echo.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+			}
+			if got := echo.Header.Get("X-Forwarded-Authorization"); got != tt.wantForwarded {
+				t.Errorf("X-Forwarded-Authorization = %q, want %q", got, tt.wantForwarded)
+			}
+		})
+	}
+}
+
+func TestClientAuthorizationValidation(t *testing.T) {
+	tests := []map[string]any{
+		{"client_authorization": "keep"},
+		{"client_authorization": clientAuthHeader, "client_authorization_header": ""},
+		{"client_authorization": clientAuthHeader, "client_authorization_header": "authorization"},
+	}
+	for _, settings := range tests {
+		if _, err := newClientAuth(settingsWith(settings)); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("newClientAuth() with %v error = %v, want %v", settings, err, ErrInvalidConfig)
+		}
+	}
+
+	testutil.Configure(t, map[string]any{"client_authorization": "keep"})
+	if _, err := NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
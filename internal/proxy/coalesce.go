@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescer shares one upstream request among identical concurrent GET requests of
+// the same identity, e.g. the discovery requests of many kubectl invocations started at
+// once. It only applies to non-resource requests like discovery, OpenAPI and /version,
+// whose responses are small enough to be buffered and don't depend on the time they
+// were started, unlike lists or watches.
+type coalescer struct {
+	group singleflight.Group
+}
+
+// bufferedResponse is a response recorded for all requests of a coalesced group.
+type bufferedResponse struct {
+	code   int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+// coalescable returns true if the request may share the response of an identical one.
+// Conditional requests are excluded, as their responses depend on the client's state.
+func coalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet || parseRequestInfo(req).IsResourceRequest {
+		return false
+	}
+	return req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" &&
+		req.Header.Get("Upgrade") == ""
+}
+
+// serve forwards the request with next, unless an identical request is already in
+// flight, whose response is then copied to w. The upstream request isn't canceled if
+// the client that started it goes away, as others may still wait for it. Responses
+// copied to the other requests are counted as responses of the proxy, as the response
+// of the upstream request is only counted once.
+func (c *coalescer) serve(w http.ResponseWriter, req *http.Request, id *identity, next http.Handler) {
+	key := strings.Join([]string{id.User, id.GroupList(), req.URL.RequestURI(), req.Header.Get("Authorization"),
+		req.Header.Get("Accept"), req.Header.Get("Accept-Encoding")}, "\n")
+
+	leader := false
+	result, _, _ := c.group.Do(key, func() (any, error) {
+		leader = true
+		resp := &bufferedResponse{header: make(http.Header)}
+		next.ServeHTTP(resp, req.WithContext(context.WithoutCancel(req.Context())))
+		return resp, nil
+	})
+
+	resp := result.(*bufferedResponse)
+	if !leader {
+		countResponse(resp.code, originProxy)
+	}
+	maps.Copy(w.Header(), resp.header.Clone())
+	w.WriteHeader(resp.code)
+	_, _ = w.Write(resp.body.Bytes())
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentGets sends n concurrent GET requests while the API server holds the first
+// one, and returns the number of upstream calls and the response bodies.
+func concurrentGets(t *testing.T, path string, n int) (int32, []string) {
+	t.Helper()
+
+	_, srv, api := newTestProxy(t, map[string]any{"coalesce_requests": true})
+	var calls atomic.Int32
+	started := make(chan struct{}, n)
+	release := make(chan struct{})
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"kind":"APIGroupList","groups":[]}`)
+	}))
+
+	bodies := make([]string, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			resp, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		})
+	}
+
+	// Give all requests time to reach the proxy while the first one is held.
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	return calls.Load(), bodies
+}
+
+func TestCoalesceDiscovery(t *testing.T) {
+	calls, bodies := concurrentGets(t, "/apis", 10)
+
+	if calls != 1 {
+		t.Errorf("API server received %d requests, want 1", calls)
+	}
+	for i, body := range bodies {
+		if body != `{"kind":"APIGroupList","groups":[]}` {
+			t.Errorf("body of request %d = %q, want the shared response", i, body)
+		}
+	}
+}
+
+func TestCoalesceSkipsResourceRequests(t *testing.T) {
+	calls, _ := concurrentGets(t, "/api/v1/pods", 3)
+
+	if calls != 3 {
+		t.Errorf("API server received %d requests, want 3", calls)
+	}
+}
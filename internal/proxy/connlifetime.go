@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// connStartKey is the context key of the time a connection was accepted.
+type connStartKey struct{}
+
+// limitConnLifetime enforces the maximum lifetime of the request's connection, so that
+// clients have to reconnect and are identified again periodically. Once the lifetime is
+// exceeded, the connection is closed after the current request. Watches and other
+// long-running requests are cut when it ends, while upgraded connections like exec
+// sessions are exempt, as cutting them would interrupt interactive work. The returned
+// function releases the resources of the request.
+func (r *ReverseProxy) limitConnLifetime(w http.ResponseWriter, req *http.Request) (*http.Request, context.CancelFunc) {
+	start, ok := req.Context().Value(connStartKey{}).(time.Time)
+	if !ok {
+		return req, func() {}
+	}
+
+	deadline := start.Add(r.maxConnLifetime)
+	if !time.Now().Before(deadline) {
+		w.Header().Set("Connection", "close")
+		return req, func() {}
+	}
+
+	if !isLongRunning(req) || req.Header.Get("Upgrade") != "" {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	return req.WithContext(ctx), cancel
+}
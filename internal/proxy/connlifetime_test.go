@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConnLifetime(t *testing.T) {
+	p, _, _ := newTestProxy(t, map[string]any{"max_conn_lifetime": 100 * time.Millisecond})
+	url := serveListener(t, p) + "/api/v1/pods"
+
+	conn := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	defer conn.CloseIdleConnections()
+	closed := func() bool {
+		resp, err := conn.Get(url)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Close
+	}
+
+	if closed() {
+		t.Error("connection was closed before its lifetime ended")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !closed() {
+		t.Error("connection was kept open after its lifetime ended")
+	}
+	if closed() {
+		t.Error("new connection was closed immediately")
+	}
+}
+
+func TestConnLifetimeEndsWatch(t *testing.T) {
+	logs := captureLog(t)
+	p, _, api := newTestProxy(t, map[string]any{"max_conn_lifetime": 200 * time.Millisecond})
+	api.SetHandler(streamEvents(`{"type":"ADDED","object":{"kind":"Pod","metadata":{"name":"pod-1"}}}`))
+	url := serveListener(t, p) + "/api/v1/pods?watch=true"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	ended := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch was not ended with the connection's lifetime")
+	}
+	waitForLog(t, logs, "watch closed by proxy")
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackingReader records whether the body was read by the client's transport.
+type trackingReader struct {
+	io.Reader
+	read atomic.Bool
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	r.read.Store(true)
+	return r.Reader.Read(p)
+}
+
+func TestExpectContinue(t *testing.T) {
+	tests := []struct {
+		name         string
+		accept       bool
+		wantStatus   int
+		wantContinue bool
+	}{
+		{name: "accepted", accept: true, wantStatus: http.StatusCreated, wantContinue: true},
+		{name: "rejected", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv, api := newTestProxy(t, nil)
+			received := make(chan string, 1)
+			api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.accept {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				received <- string(body)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			var gotContinue atomic.Bool
+			ctx := httptrace.WithClientTrace(t.Context(), &httptrace.ClientTrace{
+				Got100Continue: func() { gotContinue.Store(true) },
+			})
+			body := &trackingReader{Reader: strings.NewReader(`{"kind":"ConfigMap"}`)}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/api/v1/namespaces/default/configmaps", body)
+			req.ContentLength = int64(len(`{"kind":"ConfigMap"}`))
+			req.Header.Set("Expect", "100-continue")
+
+			client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+			defer client.CloseIdleConnections()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if gotContinue.Load() != tt.wantContinue {
+				t.Errorf("received 100 Continue = %t, want %t", gotContinue.Load(), tt.wantContinue)
+			}
+			if tt.accept {
+				if got := <-received; got != `{"kind":"ConfigMap"}` {
+					t.Errorf("API server received body %q", got)
+				}
+			} else if body.read.Load() {
+				t.Error("body was uploaded although the API server rejected the request")
+			}
+		})
+	}
+}
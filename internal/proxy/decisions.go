@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/version"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// decisionLogger emits the decision of the proxy for every request as OpenTelemetry log
+// record. Records are exported in batches in the background and dropped if the queue is
+// full, so that a slow collector never delays requests.
+type decisionLogger struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+// newDecisionLogger creates the logger exporting to the OTLP/HTTP endpoint, or returns
+// nil if no endpoint is configured. The standard OTEL_EXPORTER_OTLP_* environment
+// variables, e.g. for headers, are honored as well.
+func newDecisionLogger(endpoint string) (*decisionLogger, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	exporter, err := otlploghttp.New(context.Background(), otlploghttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid OpenTelemetry endpoint: %w", ErrInvalidConfig, err)
+	}
+
+	return newProcessorDecisionLogger(sdklog.NewBatchProcessor(exporter)), nil
+}
+
+// newProcessorDecisionLogger creates the logger emitting the records to the processor.
+func newProcessorDecisionLogger(processor sdklog.Processor) *decisionLogger {
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(processor),
+		sdklog.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "tailscale-kube-proxy"),
+			attribute.String("service.version", version.Get()),
+		)),
+	)
+	return &decisionLogger{
+		provider: provider,
+		logger:   provider.Logger("codeberg.org/0x2321/tailscale-kube-proxy/internal/proxy"),
+	}
+}
+
+// shutdown exports the buffered records and stops the logger.
+func (d *decisionLogger) shutdown(ctx context.Context) error {
+	return d.provider.Shutdown(ctx)
+}
+
+// log emits the record for the completed request. Requests that were forwarded to the
+// API server are allowed, all others denied by the proxy with the reason it responded with.
+func (d *decisionLogger) log(req *http.Request, code int, reason string, forwarded bool) {
+	id := identityFrom(req.Context())
+	info := parseRequestInfo(req)
+
+	decision, severity := decisionForbid, otellog.SeverityWarn
+	if forwarded {
+		decision, severity, reason = decisionAllow, otellog.SeverityInfo, ""
+	}
+
+	var record otellog.Record
+	record.SetTimestamp(time.Now())
+	record.SetEventName("tailscale_kube_proxy.decision")
+	record.SetSeverity(severity)
+	record.SetSeverityText(severity.String())
+	record.SetBody(attribute.StringValue(fmt.Sprintf("%s %s %s", decision, req.Method, req.URL.Path)))
+	record.AddAttributes(
+		attribute.String("decision", decision),
+		attribute.String("user", id.User),
+		attribute.String("groups", id.GroupList()),
+		attribute.String("tailscale.login", id.LoginName()),
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.path", req.URL.Path),
+		attribute.String("verb", info.Verb),
+		attribute.Int("http.response.status_code", code),
+	)
+	if reason != "" {
+		record.AddAttributes(attribute.String("reason", reason))
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		record.AddAttributes(attribute.String("client.address", host))
+	}
+
+	d.logger.Emit(req.Context(), record)
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// memoryExporter keeps the exported records in memory.
+type memoryExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, record := range records {
+		e.records = append(e.records, record.Clone())
+	}
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error   { return nil }
+func (e *memoryExporter) ForceFlush(context.Context) error { return nil }
+
+// attributes returns the attributes of the record by key.
+func attributes(record sdklog.Record) map[string]attribute.Value {
+	attrs := map[string]attribute.Value{}
+	record.WalkAttributes(func(kv attribute.KeyValue) bool {
+		attrs[string(kv.Key)] = kv.Value
+		return true
+	})
+	return attrs
+}
+
+func TestDecisionsAreExportedOnShutdown(t *testing.T) {
+	p, srv, _ := newTestProxy(t, map[string]any{"allow_methods": []string{http.MethodGet}})
+	exporter := &memoryExporter{}
+	p.decisions = newProcessorDecisionLogger(sdklog.NewBatchProcessor(exporter))
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req, _ := http.NewRequest(method, srv.URL+"/api/v1/namespaces/default/pods", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		resp.Body.Close()
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if len(exporter.records) != 2 {
+		t.Fatalf("exported %d records, want 2", len(exporter.records))
+	}
+
+	allow := attributes(exporter.records[0])
+	if got := allow["decision"].AsString(); got != decisionAllow {
+		t.Errorf("decision of GET = %q, want %q", got, decisionAllow)
+	}
+	if got := allow["user"].AsString(); got != testLogin {
+		t.Errorf("user = %q, want %q", got, testLogin)
+	}
+	if got := allow["http.response.status_code"].AsInt64(); got != http.StatusOK {
+		t.Errorf("status code of GET = %d, want %d", got, http.StatusOK)
+	}
+	if _, ok := allow["reason"]; ok {
+		t.Errorf("allowed request has reason %q", allow["reason"].AsString())
+	}
+
+	forbid := attributes(exporter.records[1])
+	if got := forbid["decision"].AsString(); got != decisionForbid {
+		t.Errorf("decision of DELETE = %q, want %q", got, decisionForbid)
+	}
+	if got := forbid["http.request.method"].AsString(); got != http.MethodDelete {
+		t.Errorf("method = %q, want %q", got, http.MethodDelete)
+	}
+	if forbid["reason"].AsString() == "" {
+		t.Error("denied request has no reason")
+	}
+}
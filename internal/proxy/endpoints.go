@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// endpointDiscovery tracks the ready endpoints of the API server from the EndpointSlices
+// of the default/kubernetes service, so that upstream connections can be spread across
+// them and don't depend on the service VIP. Connections to the configured address are
+// redirected to the endpoints in turn, and fall back to that address if no endpoint is
+// known or reachable. As HTTP/2 multiplexes all requests over a single connection, the
+// load is balanced per connection rather than per request.
+type endpointDiscovery struct {
+	// target is the address of the configured API server, as dialed by the transport.
+	target string
+
+	// factory runs the informer watching the endpoints.
+	factory informers.SharedInformerFactory
+
+	mu        sync.RWMutex
+	endpoints []string
+	next      atomic.Uint64
+}
+
+// newEndpointDiscovery starts watching the endpoints of the API server until stop is
+// closed, or returns nil if discovery is disabled.
+func newEndpointDiscovery(settings *viper.Viper, config *rest.Config, stop <-chan struct{}) (*endpointDiscovery, error) {
+	if !settings.GetBool("discover_api_endpoints") {
+		return nil, nil
+	}
+	if socket, _ := unixSocket(config.Host); socket != "" {
+		return nil, fmt.Errorf("%w: API endpoint discovery requires a TCP API server URL", ErrInvalidConfig)
+	}
+
+	target, err := dialAddress(config.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for endpoint discovery: %w", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(metav1.NamespaceDefault),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = discoveryv1.LabelServiceName + "=kubernetes"
+		}))
+	informer := factory.Discovery().V1().EndpointSlices().Informer()
+
+	discovery := &endpointDiscovery{target: target, factory: factory}
+	update := func() {
+		discovery.update(informer.GetStore().List())
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { update() },
+		UpdateFunc: func(any, any) { update() },
+		DeleteFunc: func(any) { update() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch API server endpoints: %w", err)
+	}
+
+	factory.Start(stop)
+	return discovery, nil
+}
+
+// wait waits for the informer to stop after the stop channel was closed.
+func (d *endpointDiscovery) wait() {
+	d.factory.Shutdown()
+}
+
+// update replaces the endpoints with the ready endpoints of the slices.
+func (d *endpointDiscovery) update(objects []any) {
+	var endpoints []string
+	for _, obj := range objects {
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			continue
+		}
+		endpoints = append(endpoints, sliceEndpoints(slice)...)
+	}
+	slices.Sort(endpoints)
+	endpoints = slices.Compact(endpoints)
+
+	d.mu.Lock()
+	changed := !slices.Equal(d.endpoints, endpoints)
+	d.endpoints = endpoints
+	d.mu.Unlock()
+
+	if changed {
+		log.Printf("Discovered %d API server endpoints: %s", len(endpoints), strings.Join(endpoints, ","))
+	}
+}
+
+// sliceEndpoints returns the addresses of the ready endpoints of the slice.
+func sliceEndpoints(slice *discoveryv1.EndpointSlice) []string {
+	var port int32
+	for _, p := range slice.Ports {
+		if p.Port != nil && (p.Name == nil || *p.Name == "https") {
+			port = *p.Port
+			break
+		}
+	}
+	if port == 0 {
+		return nil
+	}
+
+	var endpoints []string
+	for _, endpoint := range slice.Endpoints {
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, addr := range endpoint.Addresses {
+			endpoints = append(endpoints, net.JoinHostPort(addr, strconv.Itoa(int(port))))
+		}
+	}
+	return endpoints
+}
+
+// pick returns the next endpoint in turn, or an empty string if none is known.
+func (d *endpointDiscovery) pick() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.endpoints) == 0 {
+		return ""
+	}
+	return d.endpoints[d.next.Add(1)%uint64(len(d.endpoints))]
+}
+
+// dial redirects connections to the API server to the discovered endpoints. Other
+// addresses, e.g. of an HTTP proxy, are dialed unchanged.
+func (d *endpointDiscovery) dial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != d.target {
+			return dial(ctx, network, addr)
+		}
+
+		if endpoint := d.pick(); endpoint != "" {
+			conn, err := dial(ctx, network, endpoint)
+			if err == nil {
+				return conn, nil
+			}
+			log.Printf("Warning: failed to connect to API server endpoint %s, using %s: %v", endpoint, d.target, err)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// dialAddress returns the host and port the transport dials for the URL.
+func dialAddress(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("failed to parse API server URL %q", host)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "443"), nil
+}
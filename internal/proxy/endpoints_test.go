@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// endpointSlices serves the EndpointSlice of the default/kubernetes service with the
+// endpoint, as list or as initial events of a watch. Watches are held open until the
+// client goes away, which is reported on stopped.
+func endpointSlices(endpoint string, stopped chan<- struct{}) http.Handler {
+	name, port := "https", int32(6443)
+	slice := discoveryv1.EndpointSlice{
+		TypeMeta:    metav1.TypeMeta{Kind: "EndpointSlice", APIVersion: "discovery.k8s.io/v1"},
+		ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes", Namespace: "default", ResourceVersion: "1"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{endpoint}}},
+		Ports:       []discoveryv1.EndpointPort{{Name: &name, Port: &port}},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/default/endpointslices" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("watch") != "true" {
+			_ = json.NewEncoder(w).Encode(discoveryv1.EndpointSliceList{
+				TypeMeta: metav1.TypeMeta{Kind: "EndpointSliceList", APIVersion: "discovery.k8s.io/v1"},
+				ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+				Items:    []discoveryv1.EndpointSlice{slice},
+			})
+			return
+		}
+
+		// Watches requesting the initial events end them with a bookmark.
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("sendInitialEvents") == "true" {
+			bookmark := discoveryv1.EndpointSlice{
+				TypeMeta: slice.TypeMeta,
+				ObjectMeta: metav1.ObjectMeta{
+					ResourceVersion: "1",
+					Annotations:     map[string]string{metav1.InitialEventsAnnotationKey: "true"},
+				},
+			}
+			_ = encoder.Encode(map[string]any{"type": "ADDED", "object": slice})
+			_ = encoder.Encode(map[string]any{"type": "BOOKMARK", "object": bookmark})
+		}
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		select {
+		case stopped <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func TestEndpointDiscoveryStopsOnClose(t *testing.T) {
+	stopped := make(chan struct{}, 1)
+	api := testutil.NewFakeAPIServer(t)
+	api.SetHandler(endpointSlices("10.0.0.1", stopped))
+	p, _ := serveTestProxy(t, api.Config(), map[string]any{"discover_api_endpoints": true})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.endpoints.pick() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("no endpoint was discovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := p.endpoints.pick(); got != "10.0.0.1:6443" {
+		t.Errorf("pick() = %q, want 10.0.0.1:6443", got)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("the watch of the endpoints was not stopped by Close()")
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+)
+
+// frontProxy identifies users with the headers of the API server's requestheader
+// authenticator instead of impersonation. The API server only trusts these headers
+// on connections authenticated with a client certificate signed by its
+// --requestheader-client-ca-file, but it doesn't require any impersonation RBAC.
+type frontProxy struct {
+	userHeader  string
+	groupHeader string
+	extraPrefix string
+}
+
+// newFrontProxy returns the front proxy configuration, or nil if the mode is disabled.
+func newFrontProxy(settings *viper.Viper) *frontProxy {
+	if !settings.GetBool("front_proxy.enabled") {
+		return nil
+	}
+
+	return &frontProxy{
+		userHeader:  http.CanonicalHeaderKey(settings.GetString("front_proxy.user_header")),
+		groupHeader: http.CanonicalHeaderKey(settings.GetString("front_proxy.group_header")),
+		extraPrefix: http.CanonicalHeaderKey(settings.GetString("front_proxy.extra_header_prefix")),
+	}
+}
+
+// isIdentityHeader returns true if the header is interpreted by the requestheader authenticator.
+func (f *frontProxy) isIdentityHeader(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	return key == f.userHeader || key == f.groupHeader ||
+		(f.extraPrefix != "" && strings.HasPrefix(key, f.extraPrefix))
+}
+
+// frontProxyConfig authenticates with the front proxy client certificate instead of the
+// service account token, as the API server would otherwise ignore the request headers.
+func frontProxyConfig(settings *viper.Viper, config *rest.Config) (*rest.Config, error) {
+	certFile := settings.GetString("front_proxy.cert_file")
+	keyFile := settings.GetString("front_proxy.key_file")
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%w: front proxy mode requires a client certificate and key", ErrInvalidConfig)
+	}
+
+	config = rest.CopyConfig(config)
+	config.BearerToken = ""
+	config.BearerTokenFile = ""
+	config.CertFile = certFile
+	config.KeyFile = keyFile
+	config.CertData = nil
+	config.KeyData = nil
+
+	return config, nil
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+)
+
+// writeClientCert writes a self-signed client certificate with the common name and its
+// key, returning the paths of both files.
+func writeClientCert(t *testing.T, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestFrontProxyHeaders(t *testing.T) {
+	api := testutil.NewUnstartedFakeAPIServer(t)
+	api.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	api.StartTLS()
+
+	certFile, keyFile := writeClientCert(t, "front-proxy-client")
+	_, srv := serveTestProxy(t, api.Config(), map[string]any{
+		"front_proxy.enabled":             true,
+		"front_proxy.cert_file":           certFile,
+		"front_proxy.key_file":            keyFile,
+		"front_proxy.user_header":         "x-remote-user",
+		"front_proxy.group_header":        "X-Remote-Group",
+		"front_proxy.extra_header_prefix": "X-Remote-Extra-",
+	})
+
+	// Clients must not be able to set any of the identity headers themselves.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	req.Header.Set("X-Remote-User", "admin")
+	req.Header.Add("X-Remote-Group", "system:masters")
+	req.Header.Set("X-Remote-Extra-Scopes", "all")
+	req.Header.Set("Impersonate-User", "admin")
+	echo := doEcho(t, req)
+
+	if got := echo.Header.Get("X-Remote-User"); got != testLogin {
+		t.Errorf("X-Remote-User = %q, want %q", got, testLogin)
+	}
+	if got := echo.Header.Values("X-Remote-Group"); !slices.Equal(got, []string{"developers"}) {
+		t.Errorf("X-Remote-Group = %v, want [developers]", got)
+	}
+	if got := echo.Header.Get("X-Remote-Extra-Scopes"); got != "" {
+		t.Errorf("X-Remote-Extra-Scopes = %q, want it removed", got)
+	}
+	if echo.User != "" || len(echo.Groups) != 0 {
+		t.Errorf("impersonated %q with groups %v, want no impersonation headers", echo.User, echo.Groups)
+	}
+	if got := echo.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want no service account token", got)
+	}
+
+	// The API server only trusts the headers on connections with the client certificate.
+	requests := api.Requests()
+	if len(requests) != 1 || requests[0].TLS == nil || len(requests[0].TLS.PeerCertificates) == 0 {
+		t.Fatal("the API server received no client certificate")
+	}
+	if got := requests[0].TLS.PeerCertificates[0].Subject.CommonName; got != "front-proxy-client" {
+		t.Errorf("client certificate = %q, want front-proxy-client", got)
+	}
+}
+
+func TestFrontProxyRequiresCertificate(t *testing.T) {
+	testutil.Configure(t, map[string]any{"front_proxy.enabled": true, "front_proxy.cert_file": "tls.crt"})
+	_, err := NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver())
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() without a key error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
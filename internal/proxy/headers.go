@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// protocolHeaders are always forwarded if unknown client headers are stripped, as
+// requests, streaming and protocol upgrades of exec and port-forward depend on them.
+// The hop-by-hop headers Connection, Te and Upgrade only reach the filter as restored
+// by the reverse proxy for upgrades and trailers, so they are safe to keep.
+var protocolHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Connection",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Expect",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Version",
+	"Te",
+	"Upgrade",
+	"User-Agent",
+	"X-Stream-Protocol-Version",
+}
+
+// headerFilter removes client headers that are neither protocol headers nor explicitly
+// allowed, e.g. to only forward the headers admission webhooks are known to depend on.
+type headerFilter struct {
+	allowed map[string]bool
+}
+
+// newHeaderFilter creates the filter from the configuration, or returns nil if unknown
+// client headers are forwarded.
+func newHeaderFilter(settings *viper.Viper) *headerFilter {
+	if !settings.GetBool("strip_unknown_headers") {
+		return nil
+	}
+
+	filter := &headerFilter{allowed: make(map[string]bool)}
+	for _, name := range append(protocolHeaders, settings.GetStringSlice("forward_client_headers")...) {
+		filter.allowed[http.CanonicalHeaderKey(name)] = true
+	}
+	return filter
+}
+
+// apply removes all headers that are not allowed.
+func (f *headerFilter) apply(header http.Header) {
+	if f == nil {
+		return
+	}
+
+	for name := range header {
+		if !f.allowed[http.CanonicalHeaderKey(name)] {
+			header.Del(name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	_, srv, _ := newTestProxy(t, map[string]any{
+		"strip_unknown_headers":  true,
+		"forward_client_headers": []string{"X-Dry-Run-Token"},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	req.Header.Set("X-Dry-Run-Token", "abc")
+	req.Header.Set("X-Unknown", "value")
+	req.Header.Set("Accept", "application/json")
+	echo := doEcho(t, req)
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"X-Dry-Run-Token", "abc"},
+		{"Accept", "application/json"},
+		{"X-Unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := echo.Header.Get(tt.header); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderFilterKeepsUpgrades(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"strip_unknown_headers": true})
+
+	// Switch protocols like the API server does for exec, then echo the stream.
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "SPDY/3.1" || r.Header.Get("Connection") != "Upgrade" {
+			http.Error(w, "upgrade headers missing", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: SPDY/3.1\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	}))
+
+	u, _ := url.Parse(srv.URL)
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_, _ = fmt.Fprintf(conn, "POST /api/v1/namespaces/default/pods/web/exec HTTP/1.1\r\nHost: %s\r\n"+
+		"Connection: Upgrade\r\nUpgrade: SPDY/3.1\r\n\r\n", u.Host)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d (%s), want %d", resp.StatusCode, body, http.StatusSwitchingProtocols)
+	}
+
+	_, _ = conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(reader, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("stream = %q, %v, want ping", buf, err)
+	}
+}
+
+func TestTrailersAreForwarded(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strip unknown headers %t", strip), func(t *testing.T) {
+			_, srv, api := newTestProxy(t, map[string]any{"strip_unknown_headers": strip})
+			api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "X-Stream-Status, X-Request-Te")
+				w.WriteHeader(http.StatusOK)
+				_, _ = io.WriteString(w, "body")
+				w.Header().Set("X-Stream-Status", "complete")
+				w.Header().Set("X-Request-Te", r.Header.Get("Te"))
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			req.Header.Set("Te", "trailers")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if _, err = io.ReadAll(resp.Body); err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			if got := resp.Trailer.Get("X-Stream-Status"); got != "complete" {
+				t.Errorf("trailer X-Stream-Status = %q, want complete", got)
+			}
+			if got := resp.Trailer.Get("X-Request-Te"); got != "trailers" {
+				t.Errorf("API server received Te = %q, want trailers", got)
+			}
+		})
+	}
+}
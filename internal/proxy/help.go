@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	_ "embed"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultHelpPage explains unidentified browser users what the proxy is for.
+//
+//go:embed static/help.html
+var defaultHelpPage []byte
+
+// loadHelpPage returns the HTML page shown to unidentified browser users, read from
+// the file if configured.
+func loadHelpPage(path string) ([]byte, error) {
+	if path == "" {
+		return defaultHelpPage, nil
+	}
+
+	page, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read help page: %w", ErrInvalidConfig, err)
+	}
+	return page, nil
+}
+
+// acceptsHTML reports whether the request prefers HTML, as sent by browsers. API
+// clients like kubectl accept JSON or protobuf and keep receiving Status errors.
+func acceptsHTML(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(accept); mediaType == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHelpPage responds with the help page.
+func writeHelpPage(w http.ResponseWriter, page []byte) {
+	countResponse(http.StatusForbidden, originProxy)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write(page)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+)
+
+func TestHelpPageNegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		wantHTML bool
+	}{
+		{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", wantHTML: true},
+		{name: "HTML with parameters", accept: "application/json, text/html; charset=utf-8", wantHTML: true},
+		{name: "kubectl", accept: "application/json;as=Table;v=v1;g=meta.k8s.io,application/json"},
+		{name: "protobuf", accept: "application/vnd.kubernetes.protobuf, */*"},
+		{name: "wildcard", accept: "*/*"},
+		{name: "no accept header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, srv, api := newTestProxy(t, nil)
+			p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{})
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if !tt.wantHTML {
+				if resp.StatusCode != http.StatusOK || len(api.Requests()) != 1 {
+					t.Errorf("status = %d, want the request to be forwarded as unidentified", resp.StatusCode)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusForbidden || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+				t.Errorf("response = %d %s, want the HTML help page", resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+			if string(body) != string(defaultHelpPage) {
+				t.Errorf("body = %q, want the default help page", body)
+			}
+			if len(api.Requests()) != 0 {
+				t.Errorf("API server received %d requests, want none", len(api.Requests()))
+			}
+		})
+	}
+}
+
+func TestHelpPageOnlyForUnidentifiedUsers(t *testing.T) {
+	_, srv, _ := newTestProxy(t, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	req.Header.Set("Accept", "text/html")
+	if echo := doEcho(t, req); echo.User != testLogin {
+		t.Errorf("Impersonate-User = %q, want the request of %s to be forwarded", echo.User, testLogin)
+	}
+}
+
+func TestCustomHelpPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "help.html")
+	if err := os.WriteFile(path, []byte("<p>Ask #platform for access</p>"), 0o600); err != nil {
+		t.Fatalf("failed to write help page: %v", err)
+	}
+	p, srv, _ := newTestProxy(t, map[string]any{"help_page": path})
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "<p>Ask #platform for access</p>" {
+		t.Errorf("body = %q, want the custom help page", body)
+	}
+
+	testutil.Configure(t, map[string]any{"help_page": filepath.Join(t.TempDir(), "missing.html")})
+	if _, err = NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() with a missing help page error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
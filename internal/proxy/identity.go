@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+)
+
+// Supported values for the username source, selecting which field of the Tailscale
+// user profile becomes the impersonated Kubernetes user.
+const (
+	usernameSourceLogin       = "login"
+	usernameSourceDisplayName = "displayName"
+	usernameSourceID          = "id"
+)
+
+// errIdentityTimeout is returned if the Tailscale user could not be resolved within the
+// WhoIs timeout.
+var errIdentityTimeout = errors.New("timed out resolving the Tailscale identity")
+
+// IdentityResolver resolves the Tailscale user behind the remote address of a request.
+// It is implemented by the tsnet server, but can be replaced in tests.
+type IdentityResolver interface {
+	WhoIs(ctx context.Context, remoteAddr string) (*tailscale.UserProfile, error)
+}
+
+// validateUsernameSource returns an error if the username source is not supported.
+func validateUsernameSource(source string) error {
+	switch source {
+	case usernameSourceLogin, usernameSourceDisplayName, usernameSourceID:
+		return nil
+	default:
+		return fmt.Errorf("%w: invalid username source %q (expected %s, %s or %s)", ErrInvalidConfig,
+			source, usernameSourceLogin, usernameSourceDisplayName, usernameSourceID)
+	}
+}
+
+// username returns the Kubernetes username for the given Tailscale user profile.
+func (r *ReverseProxy) username(user *tailscale.UserProfile) string {
+	switch r.usernameSource {
+	case usernameSourceDisplayName:
+		// Display names are optional with some control servers, like Headscale.
+		if user.DisplayName == "" {
+			return user.LoginName
+		}
+		return user.DisplayName
+	case usernameSourceID:
+		return strconv.FormatInt(int64(user.ID), 10)
+	default:
+		return user.LoginName
+	}
+}
+
+// identity is the Kubernetes identity a request is forwarded with.
+type identity struct {
+	User   string
+	Groups []string
+
+	// Login is the Tailscale login name, or empty if the user could not be identified.
+	Login string
+
+	// Tags are the ACL tags of the client's node.
+	Tags []string
+}
+
+// LoginName returns the Tailscale login name for logging purposes.
+func (id *identity) LoginName() string {
+	if id.Login == "" {
+		return "unknown"
+	}
+	return id.Login
+}
+
+// GroupList returns the impersonated groups as comma separated list for logging purposes.
+func (id *identity) GroupList() string {
+	return strings.Join(id.Groups, ",")
+}
+
+// identify returns the Kubernetes identity of the request. If connection identities are
+// enabled, the identity resolved for the first request of a connection is reused for all
+// later requests on it, so it can't drift while the connection is open.
+func (r *ReverseProxy) identify(req *http.Request) (*identity, error) {
+	conn, ok := req.Context().Value(connIdentityKey{}).(*connIdentity)
+	if !ok {
+		return r.resolveIdentity(req)
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.id != nil {
+		return conn.id, nil
+	}
+
+	// Unidentified requests are retried on the next request, as the failure may be transient.
+	id, err := r.resolveIdentity(req)
+	if err == nil && id.Login != "" {
+		conn.id = id
+	}
+	return id, err
+}
+
+// resolveIdentity resolves the Tailscale user of the request and derives the Kubernetes
+// identity. Unidentified requests are assigned the configured fallback user. It returns
+// errIdentityTimeout if the WhoIs lookup times out, as the user is then unknown rather
+// than unidentified.
+func (r *ReverseProxy) resolveIdentity(req *http.Request) (*identity, error) {
+	if id, ok := r.identityCache.get(req.RemoteAddr); ok {
+		return id, nil
+	}
+
+	user, err := r.whoIs(req)
+	if errors.Is(err, errIdentityTimeout) {
+		log.Printf("Warning: failed to identify Tailscale user for %s: %v", req.RemoteAddr, err)
+		return nil, err
+	} else if err != nil {
+		log.Printf("Warning: failed to identify Tailscale user for %s: %v", req.RemoteAddr, err)
+		return &identity{User: r.unidentifiedUser}, nil
+	}
+	if user.LoginName == "" {
+		log.Printf("Warning: Tailscale user of %s has no login name, treating request as unidentified", req.RemoteAddr)
+		return &identity{User: r.unidentifiedUser}, nil
+	}
+
+	id := &identity{
+		User:   r.username(user),
+		Groups: user.Groups,
+		Login:  user.LoginName,
+		Tags:   user.Tags,
+	}
+
+	// Derive the identity with the CEL expression instead of the username source. An
+	// expression failing for a user is treated as unidentified, like a failing mapper.
+	if r.cel != nil {
+		mapped, err := r.cel.evaluate(req.Context(), user)
+		if err != nil {
+			log.Printf("Warning: %v, treating request of %s as unidentified", err, user.LoginName)
+			return &identity{User: r.unidentifiedUser}, nil
+		}
+		id.User = mapped.User
+		id.Groups = mapped.Groups
+	}
+
+	// Let the external mapper override the derived identity. If it is unavailable, the
+	// derived identity is used when failing open, otherwise the request is treated as
+	// unidentified, so it only gets the permissions of the fallback user.
+	if r.mapper != nil {
+		mapped, err := r.mapper.lookup(req.Context(), user.LoginName)
+		switch {
+		case err != nil && r.mapper.failOpen:
+			log.Printf("Warning: identity mapper failed for %s, using Tailscale identity: %v", user.LoginName, err)
+		case err != nil:
+			log.Printf("Warning: identity mapper failed for %s, treating request as unidentified: %v", user.LoginName, err)
+			return &identity{User: r.unidentifiedUser}, nil
+		case mapped != nil:
+			id.User = mapped.User
+			id.Groups = mapped.Groups
+		}
+	}
+
+	// Give users without groups a baseline, which RBAC can grant minimal permissions to.
+	if len(id.Groups) == 0 && len(r.defaultGroups) > 0 {
+		id.Groups = r.defaultGroups
+	}
+
+	// Cap the number of groups after they have been derived, so that a misconfiguration
+	// can't produce pathological requests.
+	if r.maxGroups > 0 && len(id.Groups) > r.maxGroups {
+		log.Printf("Warning: truncating %d groups of user %s to %d", len(id.Groups), id.Login, r.maxGroups)
+		id.Groups = id.Groups[:r.maxGroups]
+	}
+
+	r.identityCache.put(req.RemoteAddr, id)
+
+	return id, nil
+}
+
+// whoIs resolves the Tailscale user of the request within the WhoIs timeout, so that a
+// slow local API can't hold the request until the client gives up.
+func (r *ReverseProxy) whoIs(req *http.Request) (*tailscale.UserProfile, error) {
+	if r.whoIsTimeout <= 0 {
+		return r.identities.WhoIs(req.Context(), req.RemoteAddr)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), r.whoIsTimeout)
+	defer cancel()
+	user, err := r.identities.WhoIs(ctx, req.RemoteAddr)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+		return nil, fmt.Errorf("%w after %s", errIdentityTimeout, r.whoIsTimeout)
+	}
+	return user, err
+}
+
+// connIdentity holds the identity of a connection once it is resolved.
+type connIdentity struct {
+	mu sync.Mutex
+	id *identity
+}
+
+// connIdentityKey is the context key of the connection's identity.
+type connIdentityKey struct{}
+
+// connContext prepares the context of a new connection. It records when the connection
+// was accepted if its lifetime is limited, and holds its identity if connection
+// identities are enabled. The identity is resolved lazily by the first request, as
+// this is called from the accept loop of the server.
+func (r *ReverseProxy) connContext(ctx context.Context, _ net.Conn) context.Context {
+	if r.maxConnLifetime > 0 {
+		ctx = context.WithValue(ctx, connStartKey{}, time.Now())
+	}
+	if r.connIdentity {
+		ctx = context.WithValue(ctx, connIdentityKey{}, new(connIdentity))
+	}
+	return ctx
+}
+
+// identityKey is the context key of the request's identity.
+type identityKey struct{}
+
+// withIdentity returns a copy of the context carrying the identity.
+func withIdentity(ctx context.Context, id *identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityFrom returns the identity of the request context. Requests which were not
+// identified are treated as unidentified with an empty user.
+func identityFrom(ctx context.Context) *identity {
+	if id, ok := ctx.Value(identityKey{}).(*identity); ok {
+		return id
+	}
+	return new(identity)
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"tailscale.com/tailcfg"
+)
+
+// serveListener serves the proxy with Serve on a loopback listener, as some features
+// depend on the connection handling of its server, and returns the URL.
+func serveListener(t *testing.T, p *ReverseProxy) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() { _ = p.Serve(ln) }()
+
+	return "http://" + ln.Addr().String()
+}
+
+func TestConnectionIdentity(t *testing.T) {
+	p, _, _ := newTestProxy(t, map[string]any{"connection_identity": true})
+	url := serveListener(t, p) + "/api/v1/pods"
+	groups := func(client *http.Client) []string {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		return doEchoWith(t, client, req).Groups
+	}
+
+	conn := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	defer conn.CloseIdleConnections()
+	if got := groups(conn); !slices.Equal(got, []string{"developers"}) {
+		t.Fatalf("groups = %v, want [developers]", got)
+	}
+
+	// The identity of the open connection doesn't change, while new connections are
+	// resolved again.
+	setTestUser(p, "admins")
+	if got := groups(conn); !slices.Equal(got, []string{"developers"}) {
+		t.Errorf("groups on the same connection = %v, want [developers]", got)
+	}
+	newConn := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if got := groups(newConn); !slices.Equal(got, []string{"admins"}) {
+		t.Errorf("groups on a new connection = %v, want [admins]", got)
+	}
+}
+
+func TestIdentityPerRequest(t *testing.T) {
+	p, _, _ := newTestProxy(t, nil)
+	url := serveListener(t, p) + "/api/v1/pods"
+
+	conn := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	defer conn.CloseIdleConnections()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	doEchoWith(t, conn, req)
+
+	setTestUser(p, "admins")
+	req, _ = http.NewRequest(http.MethodGet, url, nil)
+	if got := doEchoWith(t, conn, req).Groups; !slices.Equal(got, []string{"admins"}) {
+		t.Errorf("groups on the same connection = %v, want [admins]", got)
+	}
+}
+
+// blockingResolver blocks every WhoIs lookup until it is canceled, like a stuck local API.
+type blockingResolver struct{}
+
+func (blockingResolver) WhoIs(ctx context.Context, _ string) (*tailscale.UserProfile, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestWhoIsTimeout(t *testing.T) {
+	api := testutil.NewFakeAPIServer(t)
+	testutil.Configure(t, map[string]any{"whois_timeout": 50 * time.Millisecond})
+	p, err := NewKubeProxy(api.Config(), blockingResolver{})
+	if err != nil {
+		t.Fatalf("NewKubeProxy() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+
+	started := time.Now()
+	resp, status := getStatus(t, serveListener(t, p)+"/api/v1/pods")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if want := "your Tailscale identity could not be resolved in time, please try again"; status.Message != want {
+		t.Errorf("message = %q, want %q", status.Message, want)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("request took %s, want it to fail after the WhoIs timeout", elapsed)
+	}
+	if n := len(api.Requests()); n != 0 {
+		t.Errorf("upstream received %d requests, want none", n)
+	}
+}
+
+func TestDefaultGroups(t *testing.T) {
+	p, srv, _ := newTestProxy(t, map[string]any{"default_groups": []string{"tailnet-users", "viewers"}})
+	groups := func() []string {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+		return doEcho(t, req).Groups
+	}
+
+	// Users with groups of their own don't get the default groups.
+	if got := groups(); !slices.Equal(got, []string{"developers"}) {
+		t.Errorf("groups = %v, want [developers]", got)
+	}
+
+	setTestUser(p)
+	if got := groups(); !slices.Equal(got, []string{"tailnet-users", "viewers"}) {
+		t.Errorf("groups of a user without groups = %v, want the default groups", got)
+	}
+
+	// Unidentified requests never get the default groups.
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	if echo := doEcho(t, req); echo.User != "system:anonymous" || len(echo.Groups) != 0 {
+		t.Errorf("unidentified request impersonated %q with groups %v, want no groups", echo.User, echo.Groups)
+	}
+}
+
+func TestMaxGroups(t *testing.T) {
+	logs := captureLog(t)
+	p, srv, _ := newTestProxy(t, map[string]any{
+		"max_groups":     2,
+		"default_groups": []string{"tailnet-users", "viewers", "auditors"},
+	})
+	groups := func() []string {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+		return doEcho(t, req).Groups
+	}
+
+	if got := groups(); !slices.Equal(got, []string{"developers"}) {
+		t.Errorf("groups = %v, want [developers]", got)
+	}
+	if strings.Contains(logs.String(), "truncating") {
+		t.Errorf("log = %q, want no warning below the limit", logs.String())
+	}
+
+	setTestUser(p, "developers", "operators", "admins")
+	if got := groups(); !slices.Equal(got, []string{"developers", "operators"}) {
+		t.Errorf("groups = %v, want the first 2 groups", got)
+	}
+	waitForLog(t, logs, "truncating 3 groups of user "+testLogin+" to 2")
+
+	// The limit also applies to the default groups.
+	setTestUser(p)
+	if got := groups(); !slices.Equal(got, []string{"tailnet-users", "viewers"}) {
+		t.Errorf("groups = %v, want the first 2 default groups", got)
+	}
+}
+
+func TestUsernameSource(t *testing.T) {
+	tests := []struct {
+		source      string
+		displayName string
+		want        string
+	}{
+		{source: usernameSourceLogin, displayName: "Alice", want: testLogin},
+		{source: usernameSourceDisplayName, displayName: "Alice", want: "Alice"},
+		{source: usernameSourceDisplayName, want: testLogin},
+		{source: usernameSourceID, displayName: "Alice", want: "12345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source+"/"+tt.displayName, func(t *testing.T) {
+			p, srv, _ := newTestProxy(t, map[string]any{"username_source": tt.source})
+			p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{
+				UserProfile: tailcfg.UserProfile{ID: 12345, LoginName: testLogin, DisplayName: tt.displayName},
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			if got := doEcho(t, req).User; got != tt.want {
+				t.Errorf("Impersonate-User = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	testutil.Configure(t, map[string]any{"username_source": "email"})
+	if _, err := NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() with an invalid source error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestUserWithoutLoginName(t *testing.T) {
+	logs := captureLog(t)
+	p, srv, _ := newTestProxy(t, nil)
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{ID: 2, DisplayName: "Alice"},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	if got := doEcho(t, req).User; got != "system:anonymous" {
+		t.Errorf("Impersonate-User = %q, want the unidentified user", got)
+	}
+	waitForLog(t, logs, "has no login name, treating request as unidentified")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// identityCache caches the fully derived identities by the client's IP address, so
+// that the many requests of a kubectl session don't have to be resolved one by one.
+// Entries expire after a short TTL, as Tailscale IPs may be reassigned to other nodes.
+type identityCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries *lruMap[string, cachedIdentity]
+}
+
+// cachedIdentity is an entry of the identity cache.
+type cachedIdentity struct {
+	id      *identity
+	expires time.Time
+}
+
+// newIdentityCache creates a cache with the given TTL holding up to maxEntries clients,
+// or nil if caching is disabled.
+func newIdentityCache(ttl time.Duration, maxEntries int) *identityCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &identityCache{
+		ttl:     ttl,
+		entries: newLRUMap[string, cachedIdentity](maxEntries),
+	}
+}
+
+// get returns the cached identity for the remote address, if any.
+func (c *identityCache) get(remoteAddr string) (*identity, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	key := cacheKey(remoteAddr)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries.get(key)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		c.entries.remove(key)
+		return nil, false
+	}
+	return entry.id, true
+}
+
+// put caches the identity for the remote address.
+func (c *identityCache) put(remoteAddr string, id *identity) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries.put(cacheKey(remoteAddr), cachedIdentity{
+		id:      id,
+		expires: time.Now().Add(c.ttl),
+	})
+}
+
+// clear removes all cached identities, e.g. after the configuration they were derived
+// from changed.
+func (c *identityCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = newLRUMap[string, cachedIdentity](c.entries.limit)
+}
+
+// cacheKey returns the IP of the remote address, as every request of a client
+// may use a different source port.
+func cacheKey(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"tailscale.com/tailcfg"
+)
+
+// setTestUser changes the groups of testLogin as resolved by the test proxy.
+func setTestUser(p *ReverseProxy, groups ...string) {
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{LoginName: testLogin, Groups: groups},
+	})
+}
+
+func TestIdentityCacheIsInvalidatedOnReload(t *testing.T) {
+	p, srv, _ := newTestProxy(t, map[string]any{"identity_cache_ttl": time.Hour})
+	groups := func() []string {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+		return doEcho(t, req).Groups
+	}
+
+	if got := groups(); !slices.Equal(got, []string{"developers"}) {
+		t.Fatalf("groups = %v, want [developers]", got)
+	}
+
+	setTestUser(p, "admins")
+	if got := groups(); !slices.Equal(got, []string{"developers"}) {
+		t.Errorf("groups before reload = %v, want the cached [developers]", got)
+	}
+
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := groups(); !slices.Equal(got, []string{"admins"}) {
+		t.Errorf("groups after reload = %v, want [admins]", got)
+	}
+}
+
+func TestKnownPrincipalsAreReloaded(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "principals")
+	writePrincipals := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func(srv *httptest.Server) int {
+		resp, err := http.Get(srv.URL + "/api/v1/pods")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	writePrincipals("user:" + testLogin + "\ngroup:developers\n")
+	p, srv, _ := newTestProxy(t, map[string]any{"known_principals_file": file})
+	if got := status(srv); got != http.StatusOK {
+		t.Fatalf("status = %d, want %d", got, http.StatusOK)
+	}
+
+	writePrincipals("user:" + testLogin + "\n")
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := status(srv); got != http.StatusForbidden {
+		t.Errorf("status after reload = %d, want %d", got, http.StatusForbidden)
+	}
+
+	// An invalid file keeps the previous principals.
+	writePrincipals("developers\n")
+	if err := p.Reload(); err == nil {
+		t.Error("Reload() of an invalid file succeeded")
+	}
+	if got := status(srv); got != http.StatusForbidden {
+		t.Errorf("status after failed reload = %d, want %d", got, http.StatusForbidden)
+	}
+}
+
+// BenchmarkResolveIdentity compares deriving the identity of every request with
+// reusing the identity cached for the client.
+func BenchmarkResolveIdentity(b *testing.B) {
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		b.Run("cache ttl "+ttl.String(), func(b *testing.B) {
+			p, _, _ := newTestProxy(b, map[string]any{
+				"identity_cache_ttl": ttl,
+				"identity_cel":       `{"user": user.login, "groups": groups.map(g, "tailnet:" + g)}`,
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+			req.RemoteAddr = "127.0.0.1:40000"
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := p.resolveIdentity(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
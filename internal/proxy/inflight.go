@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// inflightLimiter bounds the number of concurrently proxied requests, similar to the
+// max-in-flight filter of the kube-apiserver. Read-only and mutating requests are
+// accounted in separate buckets, so that a burst of writes can't starve reads.
+type inflightLimiter struct {
+	readOnly chan struct{}
+	mutating chan struct{}
+	wait     time.Duration
+}
+
+// newInflightLimiter creates a limiter. A limit of zero disables the respective bucket.
+func newInflightLimiter(readOnly int, mutating int, wait time.Duration) *inflightLimiter {
+	limiter := &inflightLimiter{wait: wait}
+	if readOnly > 0 {
+		limiter.readOnly = make(chan struct{}, readOnly)
+	}
+	if mutating > 0 {
+		limiter.mutating = make(chan struct{}, mutating)
+	}
+	return limiter
+}
+
+// acquire reserves a slot for the request, waiting up to the configured duration for
+// one to become available. It returns false if the proxy is saturated.
+func (l *inflightLimiter) acquire(req *http.Request) (release func(), ok bool) {
+	// Long-running requests like watches and exec sessions would hold a slot for
+	// their whole lifetime, so they are exempt just like in the kube-apiserver.
+	if isLongRunning(req) {
+		return func() {}, true
+	}
+
+	bucket := l.readOnly
+	if isMutating(req) {
+		bucket = l.mutating
+	}
+	if bucket == nil {
+		return func() {}, true
+	}
+
+	release = func() { <-bucket }
+	select {
+	case bucket <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case bucket <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-req.Context().Done():
+		return nil, false
+	}
+}
+
+// isMutating returns true if the request may modify resources on the API server.
+func isMutating(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
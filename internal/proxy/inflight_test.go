@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInflightSaturation(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{
+		"max_in_flight":      1,
+		"max_in_flight_wait": 50 * time.Millisecond,
+	})
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/slow" {
+			close(started)
+			<-unblock
+		}
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(srv.URL + "/api/v1/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	<-started
+	defer func() {
+		close(unblock)
+		if err := <-done; err != nil {
+			t.Errorf("blocking request failed: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "read-only", method: http.MethodGet, path: "/api/v1/pods", wantStatus: http.StatusTooManyRequests},
+		{name: "mutating", method: http.MethodPost, path: "/api/v1/pods", wantStatus: http.StatusOK},
+		{name: "watch", method: http.MethodGet, path: "/api/v1/pods?watch=true", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Retry-After"); tt.wantStatus == http.StatusTooManyRequests && got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+		})
+	}
+}
+
+func TestInflightWaitsForSlot(t *testing.T) {
+	limiter := newInflightLimiter(1, 0, time.Second)
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+
+	release, ok := limiter.acquire(req)
+	if !ok {
+		t.Fatal("first request was rejected")
+	}
+	time.AfterFunc(20*time.Millisecond, release)
+
+	if _, ok = limiter.acquire(req); !ok {
+		t.Error("request was rejected instead of waiting for the released slot")
+	}
+}
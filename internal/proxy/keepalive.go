@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// upstreamPinger periodically requests /healthz of the API server, so that the path to
+// it doesn't go cold due to idle timeouts of NATs or firewalls. It shares the transport of
+// the proxy, so that the pings keep the pooled connections of proxied requests warm.
+type upstreamPinger struct {
+	client   *http.Client
+	url      string
+	interval time.Duration
+}
+
+// newUpstreamPinger creates the pinger for the target, or returns nil if it is disabled.
+func newUpstreamPinger(settings *viper.Viper, transport http.RoundTripper, target *url.URL) *upstreamPinger {
+	interval := settings.GetDuration("upstream_keepalive_interval")
+	if interval <= 0 {
+		return nil
+	}
+
+	return &upstreamPinger{
+		client:   &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		url:      target.JoinPath("healthz").String(),
+		interval: interval,
+	}
+}
+
+// run pings the API server until the context is canceled.
+func (p *upstreamPinger) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.ping(ctx); err != nil {
+			upstreamKeepalives.WithLabelValues("failure").Inc()
+			log.Printf("Warning: keepalive request to the Kubernetes API failed: %v", err)
+		} else {
+			upstreamKeepalives.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+// ping requests /healthz once.
+func (p *upstreamPinger) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// RunKeepalive pings the API server periodically until the context is canceled, if an
+// upstream keepalive interval is configured.
+func (r *ReverseProxy) RunKeepalive(ctx context.Context) {
+	if r.pinger != nil {
+		r.pinger.run(ctx)
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpstreamKeepalive(t *testing.T) {
+	logs := captureLog(t)
+	p, _, api := newTestProxy(t, map[string]any{
+		"upstream_keepalive_interval": 20 * time.Millisecond,
+		"upstream_path_prefix":        "/cluster-a",
+	})
+	var pings atomic.Int32
+	var healthy atomic.Bool
+	healthy.Store(true)
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cluster-a/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pings.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	success := promtestutil.ToFloat64(upstreamKeepalives.WithLabelValues("success"))
+	failure := promtestutil.ToFloat64(upstreamKeepalives.WithLabelValues("failure"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.RunKeepalive(ctx)
+	}()
+
+	waitFor := func(n int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for pings.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("API server was pinged %d times, want %d", pings.Load(), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(2)
+	healthy.Store(false)
+	waitFor(pings.Load() + 2)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunKeepalive() did not return after the context was canceled")
+	}
+
+	if got := promtestutil.ToFloat64(upstreamKeepalives.WithLabelValues("success")) - success; got < 1 {
+		t.Errorf("successful keepalives increased by %v, want at least 1", got)
+	}
+	if got := promtestutil.ToFloat64(upstreamKeepalives.WithLabelValues("failure")) - failure; got < 1 {
+		t.Errorf("failed keepalives increased by %v, want at least 1", got)
+	}
+	waitForLog(t, logs, "Warning: keepalive request to the Kubernetes API failed: unexpected status 500 Internal Server Error")
+}
+
+func TestUpstreamKeepaliveDisabled(t *testing.T) {
+	p, _, _ := newTestProxy(t, nil)
+	if p.pinger != nil {
+		t.Fatal("pinger is set, want none without an interval")
+	}
+
+	// Without a pinger, RunKeepalive returns right away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.RunKeepalive(t.Context())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunKeepalive() blocked without a pinger")
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import "container/list"
+
+// defaultMaxTrackedUsers is the default limit of users and clients in per-user state.
+const defaultMaxTrackedUsers = 4096
+
+// lruMap is a map bounded to a number of entries, evicting the least recently used
+// entry once the limit is exceeded. It backs all state kept per user or client, so
+// that the memory of a long-running proxy doesn't grow with the number of distinct
+// identities. It is not safe for concurrent use.
+type lruMap[K comparable, V any] struct {
+	limit int
+	order *list.List // most recently used first
+	items map[K]*list.Element
+}
+
+// lruEntry is an element of the order list of an lruMap.
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLRUMap creates a map holding up to limit entries, or any number if limit is 0.
+func newLRUMap[K comparable, V any](limit int) *lruMap[K, V] {
+	return &lruMap[K, V]{
+		limit: limit,
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+// get returns the value of the key and marks it as recently used.
+func (m *lruMap[K, V]) get(key K) (V, bool) {
+	elem, ok := m.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	m.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// put sets the value of the key and evicts the least recently used entry if the
+// limit is exceeded.
+func (m *lruMap[K, V]) put(key K, value V) {
+	if elem, ok := m.items[key]; ok {
+		elem.Value.(*lruEntry[K, V]).value = value
+		m.order.MoveToFront(elem)
+		return
+	}
+
+	m.items[key] = m.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if m.limit > 0 && m.order.Len() > m.limit {
+		m.remove(m.order.Back().Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// remove deletes the key.
+func (m *lruMap[K, V]) remove(key K) {
+	if elem, ok := m.items[key]; ok {
+		m.order.Remove(elem)
+		delete(m.items, key)
+	}
+}
+
+// removeOldest deletes the least recently used entries as long as stale returns true
+// for their values.
+func (m *lruMap[K, V]) removeOldest(stale func(V) bool) {
+	for elem := m.order.Back(); elem != nil; elem = m.order.Back() {
+		entry := elem.Value.(*lruEntry[K, V])
+		if !stale(entry.value) {
+			return
+		}
+		m.remove(entry.key)
+	}
+}
+
+// len returns the number of entries.
+func (m *lruMap[K, V]) len() int {
+	return len(m.items)
+}
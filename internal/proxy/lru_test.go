@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLRUMapEviction(t *testing.T) {
+	m := newLRUMap[string, int](2)
+	m.put("a", 1)
+	m.put("b", 2)
+	m.get("a")
+	m.put("c", 3)
+
+	if _, ok := m.get("b"); ok {
+		t.Error("least recently used entry b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := m.get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+
+	unbounded := newLRUMap[int, int](0)
+	for i := range 100 {
+		unbounded.put(i, i)
+	}
+	if n := len(unbounded.items); n != 100 {
+		t.Errorf("unbounded map holds %d entries, want 100", n)
+	}
+}
+
+func TestIdentityCacheEviction(t *testing.T) {
+	cache := newIdentityCache(time.Hour, 2)
+	for i := 1; i <= 2; i++ {
+		cache.put(fmt.Sprintf("100.64.0.%d:1234", i), &identity{User: fmt.Sprintf("user%d", i)})
+	}
+	// Other ports of the same client hit the same entry.
+	if _, ok := cache.get("100.64.0.1:5678"); !ok {
+		t.Fatal("identity of the first client is not cached")
+	}
+	cache.put("100.64.0.3:1234", &identity{User: "user3"})
+
+	if _, ok := cache.get("100.64.0.2:1234"); ok {
+		t.Error("least recently used client was not evicted at max_tracked_users")
+	}
+	for _, addr := range []string{"100.64.0.1:1234", "100.64.0.3:1234"} {
+		if _, ok := cache.get(addr); !ok {
+			t.Errorf("identity of %s was evicted", addr)
+		}
+	}
+}
+
+func TestIdentityMapperEviction(t *testing.T) {
+	mapper, calls := stubMapper(t, mapTo("alice"))
+	m, err := newIdentityMapper(settingsWith(map[string]any{
+		"identity_mapper_url":       mapper.URL,
+		"identity_mapper_cache_ttl": time.Hour,
+		"max_tracked_users":         2,
+	}))
+	if err != nil {
+		t.Fatalf("newIdentityMapper() error = %v", err)
+	}
+
+	lookup := func(login string) {
+		t.Helper()
+		if _, err := m.lookup(t.Context(), login); err != nil {
+			t.Fatalf("lookup(%s) error = %v", login, err)
+		}
+	}
+	lookup(testLogin)
+	lookup("bob@example.com")
+	lookup("carol@example.com")
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("mapper called %d times, want 3", got)
+	}
+
+	lookup("carol@example.com")
+	if got := calls.Load(); got != 3 {
+		t.Errorf("mapper called %d times for a cached user, want 3", got)
+	}
+	lookup(testLogin)
+	if got := calls.Load(); got != 4 {
+		t.Errorf("mapper called %d times for an evicted user, want 4", got)
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maintenanceMode rejects all requests with a message while it is enabled, keeping the
+// node up so that clients can show why the cluster is unavailable.
+type maintenanceMode struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+}
+
+// Maintenance reports whether maintenance mode is enabled and the message returned
+// to clients.
+func (r *ReverseProxy) Maintenance() (bool, string) {
+	r.maintenance.mu.RLock()
+	defer r.maintenance.mu.RUnlock()
+	return r.maintenance.enabled, r.maintenance.message
+}
+
+// SetMaintenance enables or disables maintenance mode. The message is only replaced
+// if it is not empty.
+func (r *ReverseProxy) SetMaintenance(enabled bool, message string) {
+	r.maintenance.mu.Lock()
+	defer r.maintenance.mu.Unlock()
+
+	r.maintenance.enabled = enabled
+	if message != "" {
+		r.maintenance.message = message
+	}
+	if enabled {
+		log.Printf("Maintenance mode enabled: %s", r.maintenance.message)
+	} else {
+		log.Println("Maintenance mode disabled")
+	}
+}
+
+// serveMaintenance rejects the request with the maintenance message and returns true
+// if maintenance mode is enabled.
+func (r *ReverseProxy) serveMaintenance(w http.ResponseWriter, req *http.Request) bool {
+	enabled, message := r.Maintenance()
+	if !enabled {
+		return false
+	}
+
+	log.Printf("%s %s rejected during maintenance ip=%s", req.Method, req.URL.Path, req.RemoteAddr)
+	writeStatus(w, http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, message)
+	return true
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// callHandler sends the request with the form to the handler and returns the status
+// code and body of its response.
+func callHandler(handler http.Handler, method string, target string, form url.Values) (int, string) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestMaintenanceToggle(t *testing.T) {
+	p, srv, _ := newTestProxy(t, nil)
+	maintenance := management.MaintenanceHandler(p)
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/pods")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// GET only reports the state, even with the parameters of a change.
+	if code, _ := callHandler(maintenance, http.MethodGet, "/maintenance?enabled=true", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET with enabled = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+	if code, body := callHandler(maintenance, http.MethodGet, "/maintenance", nil); code != http.StatusOK || body != "disabled\n" {
+		t.Errorf("GET = %d %q, want maintenance disabled", code, body)
+	}
+	if resp := get(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status before maintenance = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	code, body := callHandler(maintenance, http.MethodPost, "/maintenance", url.Values{"enabled": {"true"}, "message": {"upgrading the cluster"}})
+	if code != http.StatusOK || body != "enabled: upgrading the cluster\n" {
+		t.Errorf("POST enabled = %d %q, want maintenance enabled", code, body)
+	}
+	resp := get()
+	var status metav1.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || status.Message != "upgrading the cluster" {
+		t.Errorf("status during maintenance = %d %q, want 503 with the maintenance message", resp.StatusCode, status.Message)
+	}
+
+	if code, _ = callHandler(maintenance, http.MethodPost, "/maintenance", url.Values{"enabled": {"false"}}); code != http.StatusOK {
+		t.Errorf("POST disabled = %d, want %d", code, http.StatusOK)
+	}
+	if resp = get(); resp.StatusCode != http.StatusOK {
+		t.Errorf("status after maintenance = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
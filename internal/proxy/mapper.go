@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// mapperTimeout bounds the time a lookup at the identity mapper may take.
+const mapperTimeout = 5 * time.Second
+
+// mappedIdentity is the response of the identity mapper.
+type mappedIdentity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+}
+
+// identityMapper resolves the Kubernetes identity of a Tailscale user with an external
+// HTTP service. It is called with GET <url>?login=<login> and responds with a
+// mappedIdentity, or 404 if the user is not known, in which case the identity derived
+// from the Tailscale profile is used. Results are cached by login.
+type identityMapper struct {
+	url      *url.URL
+	client   *http.Client
+	failOpen bool
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries *lruMap[string, cachedMapping]
+}
+
+// cachedMapping is an entry of the mapper cache, whose identity is nil if the mapper
+// doesn't know the user.
+type cachedMapping struct {
+	id      *mappedIdentity
+	expires time.Time
+}
+
+// newIdentityMapper creates the mapper from the configuration, or returns nil if no
+// mapper is configured.
+func newIdentityMapper(settings *viper.Viper) (*identityMapper, error) {
+	raw := settings.GetString("identity_mapper_url")
+	if raw == "" {
+		return nil, nil
+	}
+
+	mapperURL, err := url.Parse(raw)
+	if err != nil || (mapperURL.Scheme != "http" && mapperURL.Scheme != "https") {
+		return nil, fmt.Errorf("%w: invalid identity mapper URL %q", ErrInvalidConfig, raw)
+	}
+
+	return &identityMapper{
+		url:      mapperURL,
+		client:   &http.Client{Timeout: mapperTimeout},
+		failOpen: settings.GetBool("identity_mapper_fail_open"),
+		ttl:      settings.GetDuration("identity_mapper_cache_ttl"),
+		entries:  newLRUMap[string, cachedMapping](settings.GetInt("max_tracked_users")),
+	}, nil
+}
+
+// lookup returns the identity of the Tailscale login, or nil if the mapper doesn't
+// know the user.
+func (m *identityMapper) lookup(ctx context.Context, login string) (*mappedIdentity, error) {
+	m.mu.Lock()
+	entry, ok := m.entries.get(login)
+	m.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.id, nil
+	}
+
+	id, err := m.fetch(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.ttl > 0 {
+		m.mu.Lock()
+		m.entries.put(login, cachedMapping{id: id, expires: time.Now().Add(m.ttl)})
+		m.mu.Unlock()
+	}
+
+	return id, nil
+}
+
+// fetch queries the mapper for the identity of the Tailscale login.
+func (m *identityMapper) fetch(ctx context.Context, login string) (*mappedIdentity, error) {
+	u := *m.url
+	query := u.Query()
+	query.Set("login", login)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("identity mapper request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("identity mapper responded with %s", resp.Status)
+	}
+
+	id := new(mappedIdentity)
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(id); err != nil {
+		return nil, fmt.Errorf("failed to decode identity mapper response: %w", err)
+	}
+	if id.User == "" {
+		return nil, fmt.Errorf("identity mapper returned no user for %s", login)
+	}
+	return id, nil
+}
+
+// clear removes all cached mappings, so that changes of the mapper take effect
+// immediately.
+func (m *identityMapper) clear() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = newLRUMap[string, cachedMapping](m.entries.limit)
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubMapper serves the identity mapper with the handler and counts its lookups.
+func stubMapper(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	calls := new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+// mapTo responds with the identity for testLogin, and 404 for other logins.
+func mapTo(user string, groups ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("login") != testLogin {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(mappedIdentity{User: user, Groups: groups})
+	}
+}
+
+func TestIdentityMapper(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		failOpen   bool
+		wantUser   string
+		wantGroups []string
+	}{
+		{
+			name:       "mapped",
+			handler:    mapTo("alice", "platform"),
+			wantUser:   "alice",
+			wantGroups: []string{"platform"},
+		},
+		{
+			name:       "unknown user",
+			handler:    http.NotFound,
+			wantUser:   testLogin,
+			wantGroups: []string{"developers"},
+		},
+		{
+			name:       "error fails open",
+			handler:    func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			failOpen:   true,
+			wantUser:   testLogin,
+			wantGroups: []string{"developers"},
+		},
+		{
+			name:     "error fails closed",
+			handler:  func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			wantUser: "system:anonymous",
+		},
+		{
+			name:     "no user fails closed",
+			handler:  mapTo(""),
+			wantUser: "system:anonymous",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper, _ := stubMapper(t, tt.handler)
+			_, srv, _ := newTestProxy(t, map[string]any{
+				"identity_mapper_url":       mapper.URL,
+				"identity_mapper_fail_open": tt.failOpen,
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			echo := doEcho(t, req)
+			if echo.User != tt.wantUser || !slices.Equal(echo.Groups, tt.wantGroups) {
+				t.Errorf("identity = %q in %v, want %q in %v", echo.User, echo.Groups, tt.wantUser, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestIdentityMapperCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantCalls int32
+	}{
+		{name: "cached", ttl: time.Hour, wantCalls: 1},
+		{name: "not cached", ttl: 0, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper, calls := stubMapper(t, mapTo("alice", "platform"))
+			p, srv, _ := newTestProxy(t, map[string]any{
+				"identity_mapper_url":       mapper.URL,
+				"identity_mapper_cache_ttl": tt.ttl,
+			})
+
+			for range 3 {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+				if echo := doEcho(t, req); echo.User != "alice" {
+					t.Fatalf("user = %q, want the mapped user", echo.User)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("mapper called %d times, want %d", got, tt.wantCalls)
+			}
+
+			// Reloading clears the cache.
+			if err := p.Reload(); err != nil {
+				t.Fatalf("Reload() error = %v", err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			doEcho(t, req)
+			if got := calls.Load(); got != tt.wantCalls+1 {
+				t.Errorf("mapper called %d times after reload, want %d", got, tt.wantCalls+1)
+			}
+		})
+	}
+}
+
+func TestIdentityMapperCacheExpires(t *testing.T) {
+	mapper, calls := stubMapper(t, mapTo("alice"))
+	m, err := newIdentityMapper(settingsWith(map[string]any{
+		"identity_mapper_url":       mapper.URL,
+		"identity_mapper_cache_ttl": 50 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("newIdentityMapper() error = %v", err)
+	}
+
+	for range 2 {
+		if _, err = m.lookup(t.Context(), testLogin); err != nil {
+			t.Fatalf("lookup() error = %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = m.lookup(t.Context(), testLogin); err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("mapper called %d times, want 2 with the entry expired once", got)
+	}
+}
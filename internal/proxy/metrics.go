@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"strconv"
+	"sync"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// activeUserSets contains the active users of each open proxy, which are summed up by
+// the active users gauge like the other metrics of several instances.
+var activeUserSets sync.Map
+
+var (
+	activeUsersGauge = promauto.With(metrics.Registerer).NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "active_users",
+		Help:      "Number of distinct Tailscale users with a request within the active user window, summed over the proxy instances.",
+	}, func() float64 {
+		total := 0
+		activeUserSets.Range(func(set, _ any) bool {
+			total += set.(*activeUserSet).count()
+			return true
+		})
+		return float64(total)
+	})
+
+	requestDuration = promauto.With(metrics.Registerer).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of proxied requests by Tailscale user, excluding long-running requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"user"})
+
+	auditStreamDropped = promauto.With(metrics.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "audit_stream_dropped_total",
+		Help:      "Number of audit events dropped because the audit stream was full or unavailable.",
+	})
+
+	upstreamKeepalives = promauto.With(metrics.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "upstream_keepalives_total",
+		Help:      "Number of keepalive requests to the API server by result (success or failure).",
+	}, []string{"result"})
+
+	insecureMode = promauto.With(metrics.Registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "insecure_mode",
+		Help:      "Whether TLS verification of the API server is disabled (1) or not (0).",
+	})
+
+	responses = promauto.With(metrics.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "responses_total",
+		Help:      "Number of responses by status class (e.g. 4xx) and origin, either the API server (upstream) or the proxy itself.",
+	}, []string{"class", "origin"})
+)
+
+// Origins of responses, telling API server errors apart from rejections by the proxy.
+const (
+	originUpstream = "upstream"
+	originProxy    = "proxy"
+)
+
+// countResponse counts a response with the status code by its class and origin.
+func countResponse(code int, origin string) {
+	responses.WithLabelValues(strconv.Itoa(code/100)+"xx", origin).Inc()
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"tailscale.com/tailcfg"
+)
+
+// responseCounts returns the current values of the response counters by class and origin.
+func responseCounts() map[[2]string]float64 {
+	counts := make(map[[2]string]float64)
+	for _, class := range []string{"2xx", "4xx", "5xx"} {
+		for _, origin := range []string{originUpstream, originProxy} {
+			counts[[2]string{class, origin}] = promtestutil.ToFloat64(responses.WithLabelValues(class, origin))
+		}
+	}
+	return counts
+}
+
+// assertResponseCounts fails the test unless the counters increased by the expected
+// values since before, with all other counters unchanged.
+func assertResponseCounts(t *testing.T, before map[[2]string]float64, want map[[2]string]float64) {
+	t.Helper()
+
+	for key, value := range responseCounts() {
+		if got := value - before[key]; got != want[key] {
+			t.Errorf("%s responses of origin %s increased by %v, want %v", key[0], key[1], got, want[key])
+		}
+	}
+}
+
+// send sends the request and discards the response.
+func send(t *testing.T, req *http.Request) {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestResponseCounters(t *testing.T) {
+	p, srv, api := newTestProxy(t, map[string]any{"root_response": "info", "allow_methods": []string{"GET"}})
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/secrets" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	get := func(path string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		return req
+	}
+
+	before := responseCounts()
+	send(t, get("/api/v1/pods"))
+	send(t, get("/api/v1/secrets"))
+	assertResponseCounts(t, before, map[[2]string]float64{{"2xx", originUpstream}: 1, {"4xx", originUpstream}: 1})
+
+	// Rejections, the root info and the help page are responses of the proxy.
+	before = responseCounts()
+	req := get("/api/v1/pods")
+	req.Method = http.MethodDelete
+	send(t, req)
+	send(t, get("/"))
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{})
+	req = get("/api/v1/pods")
+	req.Header.Set("Accept", "text/html")
+	send(t, req)
+	assertResponseCounts(t, before, map[[2]string]float64{{"4xx", originProxy}: 2, {"2xx", originProxy}: 1})
+
+	// Errors reaching the API server are responses of the proxy as well.
+	api.Close()
+	before = responseCounts()
+	send(t, get("/api/v1/pods"))
+	assertResponseCounts(t, before, map[[2]string]float64{{"5xx", originProxy}: 1})
+}
+
+func TestResponseCountersOfCoalescedRequests(t *testing.T) {
+	before := responseCounts()
+	if calls, _ := concurrentGets(t, "/apis", 5); calls != 1 {
+		t.Fatalf("API server received %d requests, want 1", calls)
+	}
+
+	// Only the shared request reached the API server, the others were served by the proxy.
+	assertResponseCounts(t, before, map[[2]string]float64{{"2xx", originUpstream}: 1, {"2xx", originProxy}: 4})
+}
+
+// observedRequests returns the number of requests observed by the duration histogram of
+// the user label.
+func observedRequests(t *testing.T, user string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := requestDuration.WithLabelValues(user).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRequestDurationLabels(t *testing.T) {
+	p, srv, _ := newTestProxy(t, map[string]any{"metrics_max_users": 2})
+	users := []string{"latency-a@example.com", "latency-b@example.com", "latency-c@example.com"}
+	others := observedRequests(t, metrics.OtherLabel)
+
+	for _, user := range users {
+		p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{
+			UserProfile: tailcfg.UserProfile{LoginName: user},
+		})
+		for range 2 {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+			send(t, req)
+		}
+	}
+	// Watches are long-running and not observed.
+	watch, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods?watch=true", nil)
+	send(t, watch)
+
+	for _, user := range users[:2] {
+		if got := observedRequests(t, user); got != 2 {
+			t.Errorf("observed %d requests of %s, want 2", got, user)
+		}
+	}
+	// The third user is beyond the limit and counted as other.
+	if got := observedRequests(t, users[2]); got != 0 {
+		t.Errorf("observed %d requests of %s, want none beyond the limit", got, users[2])
+	}
+	if got := observedRequests(t, metrics.OtherLabel) - others; got != 2 {
+		t.Errorf("observed %d requests of %s, want 2", got, metrics.OtherLabel)
+	}
+}
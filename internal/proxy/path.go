@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// apiRoots are the first path segments served by the Kubernetes API server, which a
+// stripped prefix must not start with, as it would break clients addressing the root.
+var apiRoots = map[string]bool{
+	"api":         true,
+	"apis":        true,
+	"version":     true,
+	"openapi":     true,
+	"healthz":     true,
+	"livez":       true,
+	"readyz":      true,
+	"metrics":     true,
+	"logs":        true,
+	".well-known": true,
+}
+
+// validateStripPrefix returns an error if the normalized prefix collides with the
+// paths of the Kubernetes API.
+func validateStripPrefix(prefix string) error {
+	first, _, _ := strings.Cut(strings.TrimPrefix(prefix, "/"), "/")
+	if apiRoots[first] {
+		return fmt.Errorf("%w: strip path prefix %s collides with the Kubernetes API path /%s", ErrInvalidConfig, prefix, first)
+	}
+	return nil
+}
+
+// normalizePathPrefix returns the prefix with a leading and without a trailing slash,
+// or an empty string if the prefix addresses the root.
+func normalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// stripPathPrefix removes the normalized prefix from the path of the URL and reports
+// whether it was present. Paths that are not located below the prefix are left
+// untouched, so that clients configured with and without the prefix both work.
+func stripPathPrefix(u *url.URL, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+
+	path, ok := trimPathPrefix(u.Path, prefix)
+	if !ok {
+		return false
+	}
+	u.Path = path
+
+	// Keep the escaped form in sync, falling back to the decoded path if it can't be.
+	if u.RawPath != "" {
+		if u.RawPath, ok = trimPathPrefix(u.RawPath, prefix); !ok {
+			u.RawPath = ""
+		}
+	}
+	return true
+}
+
+// trimPathPrefix removes the prefix if it matches whole path segments.
+func trimPathPrefix(path string, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// serveVersionAndPods answers /version and the pods of the default namespace like the
+// API server would.
+func serveVersionAndPods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/version":
+		_ = json.NewEncoder(w).Encode(version.Info{Major: "1", Minor: "36", GitVersion: "v1.36.0"})
+	case "/api/v1/namespaces/default/pods":
+		_ = json.NewEncoder(w).Encode(corev1.PodList{
+			TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+			Items:    []corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(apierrors.NewNotFound(corev1.Resource("pods"), r.URL.Path).ErrStatus)
+	}
+}
+
+func TestClientGoThroughPathPrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     map[string]any
+		serverPath   string
+		wantNotFound bool
+	}{
+		{name: "root"},
+		{name: "prefix", settings: map[string]any{"strip_path_prefix": "/k8s/"}, serverPath: "/k8s"},
+		{name: "root with prefix configured", settings: map[string]any{"strip_path_prefix": "/k8s"}},
+		{
+			name:         "root with prefix required",
+			settings:     map[string]any{"strip_path_prefix": "/k8s", "require_path_prefix": true},
+			wantNotFound: true,
+		},
+		{
+			name:       "prefix required",
+			settings:   map[string]any{"strip_path_prefix": "/k8s", "require_path_prefix": true},
+			serverPath: "/k8s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv, api := newTestProxy(t, tt.settings)
+			api.SetHandler(http.HandlerFunc(serveVersionAndPods))
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL + tt.serverPath})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			info, err := clientset.Discovery().ServerVersion()
+			if tt.wantNotFound {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("ServerVersion() error = %v, want not found", err)
+				}
+				if len(api.Requests()) != 0 {
+					t.Errorf("API server received %d requests, want none", len(api.Requests()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ServerVersion() error = %v", err)
+			}
+			if info.GitVersion != "v1.36.0" {
+				t.Errorf("GitVersion = %q, want v1.36.0", info.GitVersion)
+			}
+
+			pods, err := clientset.CoreV1().Pods("default").List(t.Context(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(pods.Items) != 1 || pods.Items[0].Name != "web" {
+				t.Errorf("pods = %v, want [web]", pods.Items)
+			}
+
+			var paths []string
+			for _, req := range api.Requests() {
+				paths = append(paths, req.URL.Path)
+			}
+			if want := []string{"/version", "/api/v1/namespaces/default/pods"}; !slices.Equal(paths, want) {
+				t.Errorf("API server received %v, want %v", paths, want)
+			}
+		})
+	}
+}
+
+func TestPathPrefixRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]any
+		path     string
+		wantPath string
+	}{
+		{name: "no prefixes", path: "/api/v1/pods", wantPath: "/api/v1/pods"},
+		{
+			name:     "upstream prefix",
+			settings: map[string]any{"upstream_path_prefix": "/cluster-a/"},
+			path:     "/api/v1/pods",
+			wantPath: "/cluster-a/api/v1/pods",
+		},
+		{
+			name:     "strip prefix",
+			settings: map[string]any{"strip_path_prefix": "/k8s"},
+			path:     "/k8s/api/v1/pods",
+			wantPath: "/api/v1/pods",
+		},
+		{
+			name:     "strip prefix not present",
+			settings: map[string]any{"strip_path_prefix": "/k8s"},
+			path:     "/api/v1/pods",
+			wantPath: "/api/v1/pods",
+		},
+		{
+			name:     "strip prefix matching part of a segment",
+			settings: map[string]any{"strip_path_prefix": "/k8s"},
+			path:     "/k8s-staging/api/v1/pods",
+			wantPath: "/k8s-staging/api/v1/pods",
+		},
+		{
+			name:     "strip and upstream prefix",
+			settings: map[string]any{"strip_path_prefix": "/k8s", "upstream_path_prefix": "cluster-a"},
+			path:     "/k8s/api/v1/pods",
+			wantPath: "/cluster-a/api/v1/pods",
+		},
+		{
+			name:     "same strip and upstream prefix",
+			settings: map[string]any{"strip_path_prefix": "/k8s", "upstream_path_prefix": "/k8s"},
+			path:     "/k8s/api/v1/pods",
+			wantPath: "/k8s/api/v1/pods",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv, _ := newTestProxy(t, tt.settings)
+
+			// The query of watches must survive the rewrite of the path.
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path+"?watch=true&resourceVersion=42", nil)
+			echo := doEcho(t, req)
+
+			if echo.Path != tt.wantPath {
+				t.Errorf("path = %q, want %q", echo.Path, tt.wantPath)
+			}
+			if echo.Query.Get("watch") != "true" || echo.Query.Get("resourceVersion") != "42" {
+				t.Errorf("query = %v, want watch=true and resourceVersion=42", echo.Query)
+			}
+		})
+	}
+}
+
+func TestPathPrefixValidation(t *testing.T) {
+	tests := []map[string]any{
+		{"strip_path_prefix": "/apis/k8s"},
+		{"strip_path_prefix": "version"},
+		{"require_path_prefix": true},
+	}
+	for _, settings := range tests {
+		api := testutil.NewFakeAPIServer(t)
+		testutil.Configure(t, settings)
+
+		if _, err := NewKubeProxy(api.Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewKubeProxy() with %v error = %v, want %v", settings, err, ErrInvalidConfig)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// pathFilter restricts the API paths accessible through the proxy, independent of the
+// user's RBAC permissions. Deny patterns take precedence over allow patterns, and if
+// any allow pattern is configured, a path must match at least one of them.
+type pathFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// newPathFilter compiles the allow and deny patterns.
+func newPathFilter(allow []string, deny []string) (*pathFilter, error) {
+	filter := new(pathFilter)
+
+	var err error
+	if filter.allow, err = compilePatterns(allow); err != nil {
+		return nil, err
+	}
+	if filter.deny, err = compilePatterns(deny); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// allowed returns true if the request path may be forwarded. Only the path is matched,
+// the query string is not considered. The path is cleaned first, so that patterns
+// can't be bypassed with redundant slashes or dot segments.
+func (f *pathFilter) allowed(p string) bool {
+	p = path.Clean("/" + p)
+
+	for _, re := range f.deny {
+		if re.MatchString(p) {
+			return false
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, re := range f.allow {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles the regular expressions.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid path pattern %q: %w", ErrInvalidConfig, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
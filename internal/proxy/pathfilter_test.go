@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		path  string
+		want  bool
+	}{
+		{name: "no patterns", path: "/api/v1/secrets", want: true},
+		{name: "allowed", allow: []string{"^/api/v1/pods"}, path: "/api/v1/pods", want: true},
+		{name: "not allowed", allow: []string{"^/api/v1/pods"}, path: "/api/v1/secrets", want: false},
+		{name: "denied", deny: []string{"/secrets"}, path: "/api/v1/namespaces/default/secrets", want: false},
+		{name: "not denied", deny: []string{"/secrets"}, path: "/api/v1/pods", want: true},
+		{name: "deny over allow", allow: []string{"^/api/v1/"}, deny: []string{"/secrets"}, path: "/api/v1/secrets", want: false},
+		{name: "any allow pattern", allow: []string{"^/apis", "^/api/v1/pods"}, path: "/api/v1/pods", want: true},
+		{name: "redundant slashes", deny: []string{"^/api/v1/secrets"}, path: "//api//v1/secrets", want: false},
+		{name: "dot segments", allow: []string{"^/api/v1/pods$"}, path: "/api/v1/pods/../secrets", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newPathFilter(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("newPathFilter() error = %v", err)
+			}
+			if got := filter.allowed(tt.path); got != tt.want {
+				t.Errorf("allowed(%q) = %t, want %t", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathFilterIgnoresQuery(t *testing.T) {
+	_, srv, _ := newTestProxy(t, map[string]any{
+		"allow_paths": []string{`^/api/v1/pods$`, `\?watch=true`},
+		"deny_paths":  []string{`^/api/v1/pods\?`, `labelSelector`},
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{name: "query not denied", path: "/api/v1/pods?labelSelector=app%3Dweb", want: http.StatusOK},
+		{name: "query not allowed", path: "/api/v1/secrets?watch=true", want: http.StatusForbidden},
+		{name: "path in query", path: "/api/v1/secrets?path=/api/v1/pods", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathFilterInvalidPattern(t *testing.T) {
+	if _, err := newPathFilter([]string{"("}, nil); err == nil {
+		t.Error("newPathFilter() error = nil, want the invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// knownPrincipals contains the users and groups that may be impersonated. It catches
+// identities the RBAC configuration doesn't expect, e.g. due to a typo.
+type knownPrincipals struct {
+	users  map[string]bool
+	groups map[string]bool
+}
+
+// loadKnownPrincipals reads the known principals from the file, or returns nil if no
+// file is configured. Each line is either 'user:<name>' or 'group:<name>', empty
+// lines and lines starting with '#' are ignored.
+func loadKnownPrincipals(path string) (*knownPrincipals, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open known principals file: %w", ErrInvalidConfig, err)
+	}
+	defer file.Close()
+
+	principals := &knownPrincipals{
+		users:  make(map[string]bool),
+		groups: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		kind, name, _ := strings.Cut(text, ":")
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("%w: %s:%d: missing principal name", ErrInvalidConfig, path, line)
+		case kind == "user":
+			principals.users[name] = true
+		case kind == "group":
+			principals.groups[name] = true
+		default:
+			return nil, fmt.Errorf("%w: %s:%d: expected 'user:<name>' or 'group:<name>'", ErrInvalidConfig, path, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to read known principals file: %w", ErrInvalidConfig, err)
+	}
+
+	return principals, nil
+}
+
+// unknown returns the first principal of the identity that is not known, or an empty
+// string if all of them are known.
+func (p *knownPrincipals) unknown(id *identity) string {
+	if !p.users[id.User] {
+		return "user " + id.User
+	}
+	for _, group := range id.Groups {
+		if !p.groups[group] {
+			return "group " + group
+		}
+	}
+	return ""
+}
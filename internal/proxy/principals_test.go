@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+)
+
+// writePrincipalsFile writes the known principals to a file and returns its path.
+func writePrincipalsFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "principals")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write principals: %v", err)
+	}
+	return path
+}
+
+func TestKnownPrincipals(t *testing.T) {
+	principals, err := loadKnownPrincipals(writePrincipalsFile(t, `
+# Kubernetes identities of the tailnet
+user:alice@example.com
+user: system:anonymous
+group:developers
+group:system:authenticated
+`))
+	if err != nil {
+		t.Fatalf("loadKnownPrincipals() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   *identity
+		want string
+	}{
+		{name: "known user", id: &identity{User: "alice@example.com"}, want: ""},
+		{name: "known user and groups", id: &identity{User: "alice@example.com", Groups: []string{"developers", "system:authenticated"}}, want: ""},
+		{name: "unknown user", id: &identity{User: "mallory@example.com"}, want: "user mallory@example.com"},
+		{name: "unknown group", id: &identity{User: "alice@example.com", Groups: []string{"developers", "admins"}}, want: "group admins"},
+		{name: "anonymous", id: &identity{User: "system:anonymous"}, want: ""},
+		{name: "anonymous with unknown group", id: &identity{User: "system:anonymous", Groups: []string{"system:masters"}}, want: "group system:masters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := principals.unknown(tt.id); got != tt.want {
+				t.Errorf("unknown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownPrincipalsErrors(t *testing.T) {
+	for _, content := range []string{"developers\n", "user:\n", "role:admin\n"} {
+		if _, err := loadKnownPrincipals(writePrincipalsFile(t, content)); err == nil {
+			t.Errorf("loadKnownPrincipals(%q) error = nil, want an error", content)
+		}
+	}
+	if _, err := loadKnownPrincipals(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadKnownPrincipals() of a missing file succeeded")
+	}
+	if principals, err := loadKnownPrincipals(""); principals != nil || err != nil {
+		t.Errorf("loadKnownPrincipals(\"\") = %v, %v, want no principals", principals, err)
+	}
+}
+
+func TestUnknownAnonymousUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		principals string
+		want       int
+	}{
+		{name: "known", principals: "user:system:anonymous\n", want: http.StatusOK},
+		{name: "unknown", principals: "user:" + testLogin + "\n", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, srv, _ := newTestProxy(t, map[string]any{"known_principals_file": writePrincipalsFile(t, tt.principals)})
+			p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{})
+
+			resp, err := http.Get(srv.URL + "/api/v1/pods")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -1,30 +1,364 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
 
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
 )
 
+// ErrInvalidConfig is returned if the proxy is created with an invalid configuration.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// closeTimeout bounds the time closing a proxy may take to export the buffered events.
+const closeTimeout = 5 * time.Second
+
+// minMaxHeaderBytes is the lowest accepted header limit, as kubectl alone sends a few
+// hundred bytes of headers and tokens of OIDC based setups are larger still.
+const minMaxHeaderBytes = 4 << 10
+
 // ReverseProxy handles requests between Tailscale and the Kubernetes API.
 type ReverseProxy struct {
-	target *url.URL
-	http   *httputil.ReverseProxy
-	ts     *tailscale.Server
+	target     *url.URL
+	http       *httputil.ReverseProxy
+	identities IdentityResolver
+
+	// methods contains the allowed HTTP methods.
+	methods map[string]bool
+
+	// subresources blocks subresources like pods/exec for some principals.
+	subresources subresourceBlocks
+
+	// sources restricts the client addresses accepted by the proxy.
+	sources sourceFilter
+
+	// paths restricts the API paths accessible through the proxy.
+	paths *pathFilter
+
+	// timeouts limit the duration of requests per verb and path.
+	timeouts timeoutRules
+
+	// inflight bounds the number of concurrently proxied requests.
+	inflight *inflightLimiter
+
+	// sessions tracks the requests that are currently being proxied.
+	sessions *sessionRegistry
+
+	// activeUsers tracks the users with a recent request for the active users gauge.
+	activeUsers *activeUserSet
+
+	// userLabels caps the number of distinct users in metric labels.
+	userLabels *metrics.LabelLimiter
+
+	// stripPrefix is removed from incoming request paths before forwarding.
+	stripPrefix string
+
+	// requirePrefix rejects requests outside of the stripped prefix.
+	requirePrefix bool
+
+	// identityCache caches resolved identities by client IP if enabled.
+	identityCache *identityCache
+
+	// cel derives the Kubernetes identity with a CEL expression if set.
+	cel *celMapper
+
+	// mapper resolves the Kubernetes identity with an external service if set.
+	mapper *identityMapper
+
+	// maxHeaderBytes limits the size of request headers.
+	maxHeaderBytes int
+
+	// connIdentity resolves the identity once per connection instead of per request.
+	connIdentity bool
+
+	// principals restricts the users and groups that may be impersonated if set. It is
+	// replaced when the proxy is reloaded.
+	principals     atomic.Pointer[knownPrincipals]
+	principalsFile string
+
+	// tagNamespaces confines tagged nodes to the namespaces of their tags.
+	tagNamespaces tagNamespaces
+
+	// defaultGroups are impersonated for identified users without any derived groups.
+	defaultGroups []string
+
+	// whoIsTimeout bounds the time resolving the Tailscale user may take if positive.
+	whoIsTimeout time.Duration
+
+	// maxGroups caps the number of impersonated groups if positive.
+	maxGroups int
+
+	// usernameSource selects the profile field used as the impersonated user.
+	usernameSource string
+
+	// headers strips client headers that are not allowed if set.
+	headers *headerFilter
+
+	// clientAuth decides whether the client's Authorization header is forwarded.
+	clientAuth *clientAuth
+
+	// logWarnings enables logging of warnings returned by the API server.
+	logWarnings bool
+
+	// endpoints spreads the connections to the API server across its endpoints if set.
+	endpoints *endpointDiscovery
+
+	// pinger keeps the connection to the API server warm if set.
+	pinger *upstreamPinger
+
+	// audit writes an audit event for every request if set.
+	audit *auditLogger
+
+	// decisions emits the decision for every request as OpenTelemetry log record if set.
+	decisions *decisionLogger
+
+	// logBodies enables logging of request bodies for debugging.
+	logBodies bool
+
+	// maxConnLifetime closes client connections after this age if set.
+	maxConnLifetime time.Duration
+
+	// versionSkew warns about kubectl versions too far off the API server's if set.
+	versionSkew *versionSkewChecker
+
+	// maintenance rejects all requests with a message while it is enabled.
+	maintenance maintenanceMode
+
+	// coalesce shares upstream requests among identical concurrent requests if set.
+	coalesce *coalescer
+
+	// logSampleRate is the fraction of forwarded requests that are logged.
+	logSampleRate float64
+
+	// frontProxy identifies users with authenticating proxy headers instead of
+	// impersonation if set.
+	frontProxy *frontProxy
+
+	// rootResponse selects whether requests of the root path are forwarded or answered
+	// with information about the proxy.
+	rootResponse string
+
+	// helpPage is shown to browsers whose Tailscale identity cannot be resolved.
+	helpPage []byte
+
+	// unidentifiedUser is impersonated for requests whose Tailscale identity
+	// cannot be resolved, so they remain constrained by RBAC.
+	unidentifiedUser string
+
+	// hostname is the Tailscale hostname of the instance, reported by the root response.
+	hostname string
+
+	// done is closed when the proxy is closed, stopping its background work tracked by
+	// background and the servers started by Serve.
+	done       chan struct{}
+	closeOnce  sync.Once
+	background sync.WaitGroup
+	serversMu  sync.Mutex
+	servers    []*http.Server
 }
 
 // NewKubeProxy creates a new proxy instance with specialized TLS and rewrite logic.
-func NewKubeProxy(config *rest.Config, ts *tailscale.Server) (*ReverseProxy, error) {
+// The identities of clients are resolved by the given resolver, usually the tsnet server.
+func NewKubeProxy(config *rest.Config, identities IdentityResolver) (*ReverseProxy, error) {
+	return NewKubeProxyWithSettings(viper.GetViper(), config, identities)
+}
+
+// NewKubeProxyWithSettings creates a proxy like NewKubeProxy, but configured by the given
+// settings instead of the global configuration, e.g. for one of several instances
+// served by the same process.
+func NewKubeProxyWithSettings(settings *viper.Viper, config *rest.Config, identities IdentityResolver) (_ *ReverseProxy, err error) {
 	proxy := &ReverseProxy{
-		http: &httputil.ReverseProxy{},
-		ts:   ts,
+		done:             make(chan struct{}),
+		http:             &httputil.ReverseProxy{},
+		identities:       identities,
+		hostname:         settings.GetString("ts.hostname"),
+		stripPrefix:      normalizePathPrefix(settings.GetString("strip_path_prefix")),
+		requirePrefix:    settings.GetBool("require_path_prefix"),
+		usernameSource:   settings.GetString("username_source"),
+		defaultGroups:    settings.GetStringSlice("default_groups"),
+		maxGroups:        settings.GetInt("max_groups"),
+		whoIsTimeout:     settings.GetDuration("whois_timeout"),
+		identityCache:    newIdentityCache(settings.GetDuration("identity_cache_ttl"), settings.GetInt("max_tracked_users")),
+		connIdentity:     settings.GetBool("connection_identity"),
+		maxHeaderBytes:   settings.GetInt("max_header_bytes"),
+		rootResponse:     settings.GetString("root_response"),
+		unidentifiedUser: settings.GetString("unidentified_user"),
+		frontProxy:       newFrontProxy(settings),
+		headers:          newHeaderFilter(settings),
+		logWarnings:      settings.GetBool("log_api_warnings"),
+		logBodies:        settings.GetBool("log_request_bodies"),
+		logSampleRate:    settings.GetFloat64("log_sample_rate"),
+		maxConnLifetime:  settings.GetDuration("max_conn_lifetime"),
+		sessions:         newSessionRegistry(),
+		userLabels:       metrics.NewLabelLimiter(settings.GetInt("metrics_max_users")),
+		inflight: newInflightLimiter(
+			settings.GetInt("max_in_flight"),
+			settings.GetInt("max_mutating_in_flight"),
+			settings.GetDuration("max_in_flight_wait"),
+		),
+	}
+
+	// Stop what was already started if the configuration turns out to be invalid.
+	defer func() {
+		if err != nil {
+			_ = proxy.Close()
+		}
+	}()
+
+	if err := validateUsernameSource(proxy.usernameSource); err != nil {
+		return nil, err
+	}
+
+	if err := validateStripPrefix(proxy.stripPrefix); err != nil {
+		return nil, err
+	}
+	if proxy.requirePrefix && proxy.stripPrefix == "" {
+		return nil, fmt.Errorf("%w: requiring the path prefix needs a strip path prefix", ErrInvalidConfig)
+	}
+
+	if settings.GetInt("max_tracked_users") < 0 {
+		return nil, fmt.Errorf("%w: max tracked users must not be negative", ErrInvalidConfig)
+	}
+	proxy.activeUsers = newActiveUserSet(settings.GetDuration("active_user_window"), settings.GetInt("max_tracked_users"))
+	activeUserSets.Store(proxy.activeUsers, struct{}{})
+
+	if proxy.logSampleRate < 0 || proxy.logSampleRate > 1 {
+		return nil, fmt.Errorf("%w: log sample rate must be between 0 and 1", ErrInvalidConfig)
+	}
+
+	if err := validateRootResponse(proxy.rootResponse); err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := newClientAuth(settings)
+	if err != nil {
+		return nil, err
+	}
+	proxy.clientAuth = clientAuth
+
+	if proxy.maxHeaderBytes < minMaxHeaderBytes {
+		return nil, fmt.Errorf("%w: max header bytes must be at least %d", ErrInvalidConfig, minMaxHeaderBytes)
+	}
+
+	// Restrict the HTTP methods, as e.g. TRACE and CONNECT have no use for the Kubernetes API.
+	proxy.methods = make(map[string]bool)
+	for _, method := range settings.GetStringSlice("allow_methods") {
+		proxy.methods[strings.ToUpper(method)] = true
+	}
+
+	helpPage, err := loadHelpPage(settings.GetString("help_page"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.helpPage = helpPage
+
+	celMapper, err := newCELMapper(settings)
+	if err != nil {
+		return nil, err
+	}
+	proxy.cel = celMapper
+
+	mapper, err := newIdentityMapper(settings)
+	if err != nil {
+		return nil, err
+	}
+	proxy.mapper = mapper
+
+	audit, err := newAuditLogger(settings.GetString("audit_log"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.audit = audit
+
+	decisions, err := newDecisionLogger(settings.GetString("otel_endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.decisions = decisions
+
+	stream, err := newAuditStream(settings.GetString("audit_stream_url"), settings.GetString("audit_stream_subject"),
+		settings.GetInt("audit_stream_buffer"))
+	if err != nil {
+		return nil, err
+	}
+	if stream != nil {
+		if proxy.audit == nil {
+			proxy.audit = new(auditLogger)
+		}
+		proxy.audit.stream = stream
+	}
+
+	tagNamespaces, err := parseTagNamespaces(settings.GetStringSlice("tag_namespaces"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.tagNamespaces = tagNamespaces
+
+	// Only impersonate users and groups known to RBAC if configured.
+	proxy.principalsFile = settings.GetString("known_principals_file")
+	principals, err := loadKnownPrincipals(proxy.principalsFile)
+	if err != nil {
+		return nil, err
+	}
+	proxy.principals.Store(principals)
+
+	subresources, err := parseSubresourceBlocks(settings.GetStringSlice("block_subresources"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.subresources = subresources
+
+	sources, err := parseSourceFilter(settings.GetStringSlice("allowed_source_cidrs"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.sources = sources
+
+	// Restrict the API paths accessible through the proxy.
+	paths, err := newPathFilter(settings.GetStringSlice("allow_paths"), settings.GetStringSlice("deny_paths"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.paths = paths
+
+	timeouts, err := parseTimeoutRules(settings.GetStringSlice("timeout_rules"))
+	if err != nil {
+		return nil, err
+	}
+	proxy.timeouts = timeouts
+
+	proxy.maintenance.enabled = settings.GetBool("maintenance")
+	proxy.maintenance.message = settings.GetString("maintenance_message")
+
+	if settings.GetBool("coalesce_requests") {
+		proxy.coalesce = new(coalescer)
+	}
+
+	// Never forward unidentified requests without impersonation, as they would
+	// otherwise run with the full privileges of the proxy's service account.
+	if proxy.unidentifiedUser == "" {
+		return nil, fmt.Errorf("%w: unidentified user must not be empty", ErrInvalidConfig)
+	}
+
+	// Forward to another address of the API server than the client config, e.g. a socket.
+	if apiURL := settings.GetString("api_url"); apiURL != "" {
+		config = rest.CopyConfig(config)
+		config.Host = apiURL
 	}
 
 	// Parse the target URL.
@@ -33,53 +367,351 @@ func NewKubeProxy(config *rest.Config, ts *tailscale.Server) (*ReverseProxy, err
 		return nil, fmt.Errorf("failed to parse target URL: %w", err)
 	}
 
+	// Requests over a Unix socket are addressed to a placeholder host, as the
+	// transport dials the socket regardless of the address.
+	if socket, plain := unixSocket(config.Host); socket != "" {
+		targetUrl = &url.URL{Scheme: "https", Host: "localhost"}
+		if plain {
+			targetUrl.Scheme = "http"
+		}
+	}
+
+	// Forward requests below an optional path, e.g. if the API server is behind an ingress.
+	if prefix := normalizePathPrefix(settings.GetString("upstream_path_prefix")); prefix != "" {
+		// JoinPath drops the leading slash if the target has no path yet.
+		if targetUrl.Path == "" {
+			targetUrl.Path = "/"
+		}
+		targetUrl = targetUrl.JoinPath(prefix)
+	}
+
 	// Rewrite the URL to the Kubernetes API server.
 	proxy.target = targetUrl
 	proxy.http.Rewrite = proxy.rewrite
+	proxy.http.ModifyResponse = proxy.modifyResponse
+	proxy.http.ErrorHandler = proxy.handleError
+
+	// Discover the endpoints of the API server with the service account's credentials.
+	endpoints, err := newEndpointDiscovery(settings, config, proxy.done)
+	if err != nil {
+		return nil, err
+	}
+	proxy.endpoints = endpoints
+
+	// Authenticate with the front proxy certificate instead of the service account.
+	if proxy.frontProxy != nil {
+		if config, err = frontProxyConfig(settings, config); err != nil {
+			return nil, err
+		}
+	}
 
 	// Use the same configuration as the Kubernetes client.
-	transport, err := rest.TransportFor(config)
+	transport, err := newTransport(settings, config, endpoints)
 	if err != nil {
 		return nil, err
 	}
 	proxy.http.Transport = transport
 
+	// Keep reminding that the API server is not verified, so it isn't forgotten.
+	if interval := settings.GetDuration("insecure_warn_interval"); settings.GetBool("insecure") && interval > 0 {
+		proxy.background.Go(func() { proxy.warnInsecure(interval) })
+	}
+
+	proxy.pinger = newUpstreamPinger(settings, transport, targetUrl)
+
+	versionSkew, err := newVersionSkewChecker(settings, config, targetUrl, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	proxy.versionSkew = versionSkew
+
 	return proxy, nil
 }
 
 func (r *ReverseProxy) rewrite(req *httputil.ProxyRequest) {
+	// The query string is forwarded verbatim, so that parameters like 'limit', 'continue'
+	// and 'allowWatchBookmarks' used by informers reach the API server untouched.
 	req.SetURL(r.target)
 	req.Out.Host = r.target.Host
-	req.Out.Header = make(http.Header)
 
 	// Stripping incoming impersonation headers to prevent users from spoofing identities.
-	// We only allow identities verified by the Tailscale 'WhoIs' check.
-	for k, v := range req.In.Header {
+	// We only allow identities verified by the Tailscale 'WhoIs' check. The remaining
+	// headers were already cleaned of hop-by-hop headers, which must not be forwarded as
+	// they would interfere with the chunked streaming of watches and large lists.
+	for k := range req.Out.Header {
 		lowercaseKey := strings.ToLower(k)
 		if strings.HasPrefix(lowercaseKey, "impersonate-") {
-			continue
+			req.Out.Header.Del(k)
+		}
+		if r.frontProxy != nil && r.frontProxy.isIdentityHeader(k) {
+			req.Out.Header.Del(k)
 		}
-		req.Out.Header[k] = v
 	}
 
-	if user, err := r.ts.WhoIs(req.Out.Context(), req.In.RemoteAddr); err == nil {
-		// Bridge Tailscale identity to Kubernetes by using the proxy's own token
-		// and adding impersonation headers for the identified user.
-		req.Out.Header.Set("Impersonate-User", user.LoginName)
-		for _, group := range user.Groups {
-			req.Out.Header.Add("Impersonate-Group", group)
+	// Only forward the client headers that are known to be needed if configured.
+	r.headers.apply(req.Out.Header)
+	r.clientAuth.apply(req.In.Header, req.Out.Header)
+
+	// Bridge Tailscale identity to Kubernetes by using the proxy's own token
+	// and adding impersonation headers for the identified user.
+	id := identityFrom(req.In.Context())
+	r.setIdentity(req.Out.Header, id.User, id.Groups)
+
+	// Only the access log is sampled. Denials, rejections by the API server and errors
+	// are logged separately and in full.
+	if r.logSampleRate >= 1 || rand.Float64() < r.logSampleRate {
+		log.Printf("%s %s user=%s groups=%s ip=%s", req.In.Method, req.In.URL.Path, id.LoginName(), id.GroupList(), req.In.RemoteAddr)
+	}
+	if r.logBodies {
+		logRequestBody(req.Out)
+	}
+}
+
+// ServeHTTP applies the proxy's admission checks before forwarding the request.
+func (r *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Audit the outcome once the request is complete, including rejections by the proxy.
+	forwarded := false
+	if r.audit != nil || r.decisions != nil {
+		received := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		w = recorder
+		defer func() {
+			if r.audit != nil {
+				r.audit.log(req, received, recorder.code, forwarded)
+			}
+			if r.decisions != nil {
+				r.decisions.log(req, recorder.code, recorder.message, forwarded)
+			}
+		}()
+	}
+
+	if !r.sources.allowed(req.RemoteAddr) {
+		log.Printf("%s %s denied, source address not allowed ip=%s", req.Method, req.URL.Path, req.RemoteAddr)
+		writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, "requests from this address are not allowed")
+		return
+	}
+
+	if r.serveMaintenance(w, req) {
+		return
+	}
+
+	if !r.methods[req.Method] {
+		log.Printf("%s %s rejected, method not allowed ip=%s", req.Method, req.URL.Path, req.RemoteAddr)
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, fmt.Sprintf("method %s is not allowed", req.Method))
+		return
+	}
+
+	// Protocol upgrades as used by exec, attach and port-forward require HTTP/1.1. Other
+	// requests of HTTP/1.0 clients are served, with responses of unknown length delimited
+	// by closing the connection. The Host header is always set to the API server's.
+	if !req.ProtoAtLeast(1, 1) && req.Header.Get("Upgrade") != "" {
+		log.Printf("%s %s rejected, upgrade requires HTTP/1.1 proto=%s ip=%s", req.Method, req.URL.Path, req.Proto, req.RemoteAddr)
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "protocol upgrades require HTTP/1.1")
+		return
+	}
+
+	// Strip the prefix first, so that all checks see the path of the API server.
+	if !stripPathPrefix(req.URL, r.stripPrefix) && r.requirePrefix {
+		log.Printf("%s %s rejected, path outside of prefix %s ip=%s", req.Method, req.URL.Path, r.stripPrefix, req.RemoteAddr)
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("the API is served below %s", r.stripPrefix))
+		return
+	}
+
+	if r.rootResponse == rootResponseInfo && req.URL.Path == "/" && req.Method == http.MethodGet {
+		r.serveInfo(w, req)
+		return
+	}
+
+	// Resolve the identity once, so that it is available to all later stages.
+	id, err := r.identify(req)
+	if err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable, "your Tailscale identity could not be resolved in time, please try again")
+		return
+	}
+	req = req.WithContext(withIdentity(req.Context(), id))
+	r.activeUsers.observe(id.Login)
+
+	// Explain browser users that this is an API proxy rather than forwarding them anonymously.
+	if id.Login == "" && acceptsHTML(req) {
+		log.Printf("%s %s unidentified browser request ip=%s", req.Method, req.URL.Path, req.RemoteAddr)
+		writeHelpPage(w, r.helpPage)
+		return
+	}
+
+	if principals := r.principals.Load(); principals != nil {
+		if principal := principals.unknown(id); principal != "" {
+			log.Printf("%s %s denied, unknown principal %s user=%s ip=%s", req.Method, req.URL.Path, principal, id.LoginName(), req.RemoteAddr)
+			writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, fmt.Sprintf("%s is not a known principal", principal))
+			return
 		}
+	}
+
+	if !r.tagNamespaces.allowed(id.Tags, parseRequestInfo(req)) {
+		log.Printf("%s %s denied outside of tag namespaces user=%s tags=%s ip=%s", req.Method, req.URL.Path, id.LoginName(), strings.Join(id.Tags, ","), req.RemoteAddr)
+		writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, "tagged nodes may only access the namespaces of their tags")
+		return
+	}
+
+	if blocked := r.subresources.blocked(id, parseRequestInfo(req)); blocked != "" {
+		log.Printf("%s %s denied, subresource %s is blocked user=%s groups=%s ip=%s", req.Method, req.URL.Path, blocked, id.LoginName(), id.GroupList(), req.RemoteAddr)
+		writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, fmt.Sprintf("access to %s is blocked by this proxy", blocked))
+		return
+	}
+
+	if !r.paths.allowed(req.URL.Path) {
+		log.Printf("%s %s denied by path filter user=%s groups=%s ip=%s", req.Method, req.URL.Path, id.LoginName(), id.GroupList(), req.RemoteAddr)
+		writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, fmt.Sprintf("access to %s is not allowed through this proxy", req.URL.Path))
+		return
+	}
+
+	release, ok := r.inflight.acquire(req)
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		writeStatus(w, http.StatusTooManyRequests, metav1.StatusReasonTooManyRequests, "too many requests, please try again later")
+		return
+	}
+	defer release()
+
+	defer r.sessions.add(req, id.LoginName())()
+
+	req, cancel := r.limitConnLifetime(w, req)
+	defer cancel()
+
+	if parseRequestInfo(req).Verb == "watch" {
+		start := time.Now()
+		defer logWatchEnd(req, id, start)
+	}
+
+	// Watches and streams would distort the latency, so they are not observed.
+	if !isLongRunning(req) {
+		start := time.Now()
+		defer func() {
+			requestDuration.WithLabelValues(r.userLabels.Value(id.LoginName())).Observe(time.Since(start).Seconds())
+		}()
+	}
 
-		log.Printf("%s %s user=%s ip=%s", req.In.Method, req.In.URL.Path, user.LoginName, req.In.RemoteAddr)
-	} else {
-		req.Out.Header.Set("Impersonate-User", "system:anonymous")
-		log.Printf("Warning: failed to identify Tailscale user for %s: %v", req.In.RemoteAddr, err)
-		log.Printf("%s %s user=unknown ip=%s", req.In.Method, req.In.URL.Path, req.In.RemoteAddr)
+	if timeout := r.timeouts.timeout(req); timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	r.versionSkew.check(req, id)
+
+	forwarded = true
+	if r.coalesce != nil && coalescable(req) {
+		r.coalesce.serve(w, req, id, r.http)
+		return
+	}
+	r.http.ServeHTTP(w, req)
+}
+
+// Reload re-reads the known principals file and drops the cached identities and
+// mappings, so that changed RBAC expectations and mappings take effect without a
+// restart. The previous principals are kept if the file is invalid. Identities bound to
+// open connections are kept until the connection is closed.
+func (r *ReverseProxy) Reload() error {
+	principals, err := loadKnownPrincipals(r.principalsFile)
+	if err != nil {
+		return err
 	}
+	r.principals.Store(principals)
+	r.mapper.clear()
+	r.identityCache.clear()
+	return nil
 }
 
-// Listen starts the proxy server on the Tailscale listener.
-func (r *ReverseProxy) Listen() error {
+// Shutdown exports the decisions and publishes the audit events still buffered, so that
+// they aren't lost on exit.
+func (r *ReverseProxy) Shutdown(ctx context.Context) error {
+	var errs []error
+	if r.decisions != nil {
+		errs = append(errs, r.decisions.shutdown(ctx))
+	}
+	if r.audit != nil && r.audit.stream != nil {
+		errs = append(errs, r.audit.stream.close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the servers and background work of the proxy, closes its connections to
+// the API server and exports the buffered events, e.g. before an instance is restarted.
+// The proxy must not be used afterwards.
+func (r *ReverseProxy) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+
+	r.serversMu.Lock()
+	for _, server := range r.servers {
+		_ = server.Close()
+	}
+	r.serversMu.Unlock()
+	if r.activeUsers != nil {
+		activeUserSets.Delete(r.activeUsers)
+	}
+	r.background.Wait()
+	if r.endpoints != nil {
+		r.endpoints.wait()
+	}
+	if transport, ok := r.http.Transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+	if r.mapper != nil {
+		r.mapper.client.CloseIdleConnections()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return r.Shutdown(ctx)
+}
+
+// warnInsecure repeats the warning that the API server is not verified at the interval
+// until the proxy is closed.
+func (r *ReverseProxy) warnInsecure(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			log.Println(insecureWarning)
+		}
+	}
+}
+
+// setIdentity adds the headers identifying the user to the API server.
+func (r *ReverseProxy) setIdentity(header http.Header, user string, groups []string) {
+	userHeader, groupHeader := "Impersonate-User", "Impersonate-Group"
+	if r.frontProxy != nil {
+		userHeader, groupHeader = r.frontProxy.userHeader, r.frontProxy.groupHeader
+	}
+
+	header.Set(userHeader, user)
+	for _, group := range groups {
+		header.Add(groupHeader, group)
+	}
+}
+
+// Serve starts the proxy server on the listener, usually the Tailscale listener.
+func (r *ReverseProxy) Serve(ln net.Listener) error {
 	log.Println("Starting proxy server...")
-	return http.Serve(r.ts.Listener(), r.http)
+	// Requests with headers exceeding the limit are rejected with 431 by the server.
+	server := &http.Server{
+		Handler:        r,
+		ConnContext:    r.connContext,
+		MaxHeaderBytes: r.maxHeaderBytes,
+	}
+
+	r.serversMu.Lock()
+	select {
+	case <-r.done:
+		r.serversMu.Unlock()
+		return http.ErrServerClosed
+	default:
+	}
+	r.servers = append(r.servers, server)
+	r.serversMu.Unlock()
+
+	return server.Serve(ln)
 }
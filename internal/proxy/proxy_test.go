@@ -0,0 +1,369 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"tailscale.com/tailcfg"
+)
+
+// testLogin is the Tailscale user of requests to test proxies.
+const testLogin = "alice@example.com"
+
+// newTestProxy serves a proxy configured with the settings, which forwards to a fake API
+// server and resolves requests from the loopback address to testLogin in the
+// "developers" group.
+func newTestProxy(t testing.TB, settings map[string]any) (*ReverseProxy, *httptest.Server, *testutil.FakeAPIServer) {
+	t.Helper()
+
+	api := testutil.NewFakeAPIServer(t)
+	p, srv := serveTestProxy(t, api.Config(), settings)
+
+	return p, srv, api
+}
+
+// serveTestProxy serves a proxy configured with the settings, which forwards to the API
+// server of the config and resolves requests like newTestProxy.
+func serveTestProxy(t testing.TB, config *rest.Config, settings map[string]any) (*ReverseProxy, *httptest.Server) {
+	t.Helper()
+
+	testutil.Configure(t, settings)
+	resolver := testutil.NewStaticResolver()
+	resolver.SetUser("127.0.0.1", &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{LoginName: testLogin, Groups: []string{"developers"}},
+	})
+
+	p, err := NewKubeProxy(config, resolver)
+	if err != nil {
+		t.Fatalf("NewKubeProxy() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+
+	return p, srv
+}
+
+// settingsWith returns settings with the values, for parts of the proxy that are tested
+// on their own.
+func settingsWith(values map[string]any) *viper.Viper {
+	settings := viper.New()
+	for key, value := range values {
+		settings.Set(key, value)
+	}
+	return settings
+}
+
+// doEcho sends the request and decodes the echo of the fake API server.
+func doEcho(t *testing.T, req *http.Request) testutil.EchoResponse {
+	t.Helper()
+	return doEchoWith(t, http.DefaultClient, req)
+}
+
+// doEchoWith sends the request with the client and decodes the echo of the fake API
+// server.
+func doEchoWith(t *testing.T, client *http.Client, req *http.Request) testutil.EchoResponse {
+	t.Helper()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var echo testutil.EchoResponse
+	if err = json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("failed to decode echo: %v", err)
+	}
+	return echo
+}
+
+func TestImpersonation(t *testing.T) {
+	_, srv, _ := newTestProxy(t, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/namespaces/default/pods", nil)
+	echo := doEcho(t, req)
+
+	if echo.User != testLogin {
+		t.Errorf("Impersonate-User = %q, want %q", echo.User, testLogin)
+	}
+	if !slices.Equal(echo.Groups, []string{"developers"}) {
+		t.Errorf("Impersonate-Group = %v, want [developers]", echo.Groups)
+	}
+	if got := echo.Header.Get("Authorization"); got != "Bearer proxy-token" {
+		t.Errorf("Authorization = %q, want the proxy's token", got)
+	}
+	if echo.Path != "/api/v1/namespaces/default/pods" {
+		t.Errorf("path = %q, want /api/v1/namespaces/default/pods", echo.Path)
+	}
+}
+
+func TestImpersonationHeadersOfClientsAreRemoved(t *testing.T) {
+	_, srv, _ := newTestProxy(t, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	req.Header.Set("Impersonate-User", "admin")
+	req.Header.Add("Impersonate-Group", "system:masters")
+	req.Header.Set("Impersonate-Extra-Scopes", "all")
+	echo := doEcho(t, req)
+
+	if echo.User != testLogin {
+		t.Errorf("Impersonate-User = %q, want %q", echo.User, testLogin)
+	}
+	if slices.Contains(echo.Groups, "system:masters") {
+		t.Errorf("Impersonate-Group = %v, contains the client's group", echo.Groups)
+	}
+	if got := echo.Header.Get("Impersonate-Extra-Scopes"); got != "" {
+		t.Errorf("Impersonate-Extra-Scopes = %q, want it removed", got)
+	}
+}
+
+func TestUnidentifiedUser(t *testing.T) {
+	testutil.Configure(t, map[string]any{"unidentified_user": "tailscale:unknown"})
+	p, err := NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver())
+	if err != nil {
+		t.Fatalf("NewKubeProxy() error = %v", err)
+	}
+
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	echo := doEcho(t, req)
+
+	if echo.User != "tailscale:unknown" {
+		t.Errorf("Impersonate-User = %q, want the unidentified user", echo.User)
+	}
+	if len(echo.Groups) != 0 {
+		t.Errorf("Impersonate-Group = %v, want none", echo.Groups)
+	}
+}
+
+func TestMethodRestriction(t *testing.T) {
+	tests := []struct {
+		name        string
+		settings    map[string]any
+		method      string
+		wantAllowed bool
+	}{
+		{name: "GET", method: http.MethodGet, wantAllowed: true},
+		{name: "DELETE", method: http.MethodDelete, wantAllowed: true},
+		{name: "TRACE", method: http.MethodTrace},
+		{name: "CONNECT", method: http.MethodConnect},
+		{name: "custom method", method: "PROPFIND"},
+		{
+			name:        "configured in lowercase",
+			settings:    map[string]any{"allow_methods": []string{"get"}},
+			method:      http.MethodGet,
+			wantAllowed: true,
+		},
+		{
+			name:     "not configured",
+			settings: map[string]any{"allow_methods": []string{"get"}},
+			method:   http.MethodDelete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv, api := newTestProxy(t, tt.settings)
+
+			req, _ := http.NewRequest(tt.method, srv.URL+"/api/v1/namespaces/default/pods", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if tt.wantAllowed {
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+				}
+				return
+			}
+			var status metav1.Status
+			if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatalf("failed to decode status: %v", err)
+			}
+			if resp.StatusCode != http.StatusMethodNotAllowed || status.Reason != metav1.StatusReasonMethodNotAllowed {
+				t.Errorf("status = %d (%s), want %d", resp.StatusCode, status.Reason, http.StatusMethodNotAllowed)
+			}
+			if len(api.Requests()) != 0 {
+				t.Errorf("API server received %d requests, want none", len(api.Requests()))
+			}
+		})
+	}
+}
+
+// sendRaw writes the raw request to the proxy and reads the response.
+func sendRaw(t *testing.T, srv *httptest.Server, raw string) *http.Response {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if _, err = io.WriteString(conn, raw); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestHTTP10Clients(t *testing.T) {
+	_, srv, api := newTestProxy(t, nil)
+
+	// Plain requests are served even without a Host header.
+	resp := sendRaw(t, srv, "GET /api/v1/pods HTTP/1.0\r\n\r\n")
+	var echo testutil.EchoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("failed to decode echo: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || echo.User != testLogin {
+		t.Errorf("status = %d, user = %q, want %d for %s", resp.StatusCode, echo.User, http.StatusOK, testLogin)
+	}
+	requests := api.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("API server received %d requests, want 1", len(requests))
+	}
+	if want := api.Listener.Addr().String(); requests[0].Host != want {
+		t.Errorf("Host = %q, want the API server's %q", requests[0].Host, want)
+	}
+
+	// Protocol upgrades are rejected, as they require HTTP/1.1.
+	resp = sendRaw(t, srv, "POST /api/v1/namespaces/default/pods/web/exec HTTP/1.0\r\n"+
+		"Host: proxy\r\nConnection: Upgrade\r\nUpgrade: SPDY/3.1\r\n\r\n")
+	var status metav1.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || status.Reason != metav1.StatusReasonBadRequest {
+		t.Errorf("status = %d (%s), want %d", resp.StatusCode, status.Reason, http.StatusBadRequest)
+	}
+	if len(api.Requests()) != 1 {
+		t.Errorf("API server received %d requests, want the upgrade to be rejected", len(api.Requests()))
+	}
+}
+
+func TestOversizedHeaders(t *testing.T) {
+	p, _, api := newTestProxy(t, map[string]any{"max_header_bytes": 8 << 10})
+	url := serveListener(t, p) + "/api/v1/pods"
+	get := func(size int) int {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Padding", strings.Repeat("a", size))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(4 << 10); code != http.StatusOK {
+		t.Errorf("status with headers below the limit = %d, want %d", code, http.StatusOK)
+	}
+	// The server allows some slack over the limit, so clearly exceed it.
+	if code := get(16 << 10); code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("status with headers over the limit = %d, want %d", code, http.StatusRequestHeaderFieldsTooLarge)
+	}
+	if len(api.Requests()) != 1 {
+		t.Errorf("API server received %d requests, want 1", len(api.Requests()))
+	}
+
+	testutil.Configure(t, map[string]any{"max_header_bytes": 1 << 10})
+	if _, err := NewKubeProxy(api.Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() with a limit below %d error = %v, want %v", minMaxHeaderBytes, err, ErrInvalidConfig)
+	}
+}
+
+func TestAccessLogGroups(t *testing.T) {
+	logs := captureLog(t)
+	p, srv, _ := newTestProxy(t, map[string]any{"max_groups": 2})
+	setTestUser(p, "developers", "operators", "admins")
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// The impersonated groups are logged, after truncation.
+	waitForLog(t, logs, "GET /api/v1/pods user="+testLogin+" groups=developers,operators ip=127.0.0.1:")
+
+	setTestUser(p)
+	resp, err = http.Get(srv.URL + "/api/v1/namespaces")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	waitForLog(t, logs, "GET /api/v1/namespaces user="+testLogin+" groups= ip=127.0.0.1:")
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	const requests = 200
+	tests := []struct {
+		rate     float64
+		min, max int
+	}{
+		{rate: 0, min: 0, max: 0},
+		{rate: 0.5, min: 50, max: 150},
+		{rate: 1, min: requests, max: requests},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.rate), func(t *testing.T) {
+			logs := captureLog(t)
+			_, srv, _ := newTestProxy(t, map[string]any{"log_sample_rate": tt.rate})
+			for range requests {
+				resp, err := http.Get(srv.URL + "/api/v1/pods")
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				resp.Body.Close()
+			}
+
+			if got := strings.Count(logs.String(), "GET /api/v1/pods user="); got < tt.min || got > tt.max {
+				t.Errorf("logged %d of %d requests, want between %d and %d", got, requests, tt.min, tt.max)
+			}
+		})
+	}
+
+	// Denials are logged in full regardless of the sampling.
+	logs := captureLog(t)
+	_, srv, _ := newTestProxy(t, map[string]any{"log_sample_rate": 0, "allow_methods": []string{"GET"}})
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/v1/pods", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	waitForLog(t, logs, "DELETE /api/v1/pods rejected, method not allowed")
+
+	for _, rate := range []float64{-0.1, 1.5} {
+		testutil.Configure(t, map[string]any{"log_sample_rate": rate})
+		if _, err = NewKubeProxy(testutil.NewFakeAPIServer(t).Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewKubeProxy() with rate %v error = %v, want %v", rate, err, ErrInvalidConfig)
+		}
+	}
+}
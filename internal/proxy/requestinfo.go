@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// requestInfo holds the attributes of a Kubernetes API request as derived from its
+// path, following the same conventions as the kube-apiserver.
+type requestInfo struct {
+	IsResourceRequest bool
+	Verb              string
+	APIGroup          string
+	APIVersion        string
+	Namespace         string
+	Resource          string
+	Name              string
+	Subresource       string
+}
+
+// parseRequestInfo derives the requestInfo from the URL and method of the request.
+// Paths that don't address a resource (e.g. /version or discovery) are returned as
+// non-resource requests with the lowercase HTTP method as verb.
+func parseRequestInfo(req *http.Request) requestInfo {
+	info := requestInfo{Verb: strings.ToLower(req.Method)}
+
+	// The path is cleaned, so that redundant slashes or dot segments can't hide a
+	// resource or subresource from the checks relying on it.
+	parts := strings.Split(strings.Trim(path.Clean("/"+req.URL.Path), "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		info.APIVersion = parts[1]
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		info.APIGroup = parts[1]
+		info.APIVersion = parts[2]
+		parts = parts[3:]
+	default:
+		return info
+	}
+	info.IsResourceRequest = true
+
+	watch := false
+	if parts[0] == "watch" && len(parts) > 1 {
+		watch = true
+		parts = parts[1:]
+	}
+
+	// Namespaced resources are nested below /namespaces/{namespace}, which is itself
+	// also the path of the namespace resource.
+	if parts[0] == "namespaces" && len(parts) > 1 {
+		info.Namespace = parts[1]
+		if len(parts) > 2 {
+			parts = parts[2:]
+		}
+	}
+
+	info.Resource = parts[0]
+	if len(parts) > 1 {
+		info.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		info.Subresource = parts[2]
+	}
+
+	if q := req.URL.Query().Get("watch"); q == "true" || q == "1" {
+		watch = true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case watch:
+			info.Verb = "watch"
+		case info.Name == "":
+			info.Verb = "list"
+		default:
+			info.Verb = "get"
+		}
+	case http.MethodPost:
+		info.Verb = "create"
+	case http.MethodPut:
+		info.Verb = "update"
+	case http.MethodPatch:
+		info.Verb = "patch"
+	case http.MethodDelete:
+		info.Verb = "delete"
+		if info.Name == "" {
+			info.Verb = "deletecollection"
+		}
+	}
+
+	return info
+}
+
+// isLongRunning returns true for watches and streaming subresources, which stay open
+// for an unbounded amount of time.
+func isLongRunning(req *http.Request) bool {
+	info := parseRequestInfo(req)
+	if info.Verb == "watch" {
+		return true
+	}
+
+	switch info.Subresource {
+	case "exec", "attach", "portforward", "proxy":
+		return true
+	case "log":
+		return req.URL.Query().Get("follow") == "true"
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// maxStatusBodySize limits the size of error bodies that are parsed for logging.
+const maxStatusBodySize = 64 << 10
+
+// modifyResponse inspects responses from the API server before they are returned to the client.
+// Bodies are streamed to the client, so large lists and watches don't need to fit into
+// memory. Inspections must therefore stick to headers and bounded bodies like readStatus.
+// Trailers, e.g. of aggregated API servers, are forwarded by the reverse proxy once the
+// body is copied. Responses with trailers are never replaced, as their length is unknown.
+func (r *ReverseProxy) modifyResponse(resp *http.Response) error {
+	countResponse(resp.StatusCode, originUpstream)
+	if r.logWarnings {
+		logWarnings(resp)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		status, err := readStatus(resp)
+		if err != nil || status == nil {
+			return nil
+		}
+
+		logRejection(resp, status)
+		if isImpersonationDenied(status) {
+			return explainImpersonationDenied(resp, status)
+		}
+	}
+	return nil
+}
+
+// handleError responds with a Kubernetes Status object if the request could not be
+// forwarded to the API server, e.g. because it is unreachable.
+func (r *ReverseProxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	id := identityFrom(req.Context())
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("%s %s timed out user=%s ip=%s", req.Method, req.URL.Path, id.LoginName(), req.RemoteAddr)
+		writeStatus(w, http.StatusGatewayTimeout, metav1.StatusReasonTimeout, "the request did not complete within the timeout configured for it")
+		return
+	}
+	log.Printf("%s %s failed to reach API server user=%s ip=%s: %v", req.Method, req.URL.Path, id.LoginName(), req.RemoteAddr, err)
+	writeStatus(w, http.StatusBadGateway, metav1.StatusReasonServiceUnavailable, "the Kubernetes API server is unavailable")
+}
+
+// logRejection logs why the API server rejected a request, which helps diagnosing
+// missing RBAC permissions without enabling the API server's audit log.
+func logRejection(resp *http.Response, status *metav1.Status) {
+	req := resp.Request
+	id := identityFrom(req.Context())
+	log.Printf("%s %s rejected with %d user=%s groups=%s reason=%s message=%q",
+		req.Method, req.URL.Path, resp.StatusCode, id.User, id.GroupList(), status.Reason, status.Message)
+}
+
+// logWarnings logs the warnings returned by the API server, e.g. about the usage of
+// deprecated APIs, together with the user who triggered them.
+func logWarnings(resp *http.Response) {
+	warnings, _ := utilnet.ParseWarningHeaders(resp.Header.Values("Warning"))
+	if len(warnings) == 0 {
+		return
+	}
+
+	req := resp.Request
+	id := identityFrom(req.Context())
+	for _, warning := range warnings {
+		log.Printf("Warning from API server for %s %s user=%s: %s", req.Method, req.URL.Path, id.User, warning.Text)
+	}
+}
+
+// isImpersonationDenied reports whether the API server rejected the request because the
+// proxy itself is not allowed to impersonate the user or its groups.
+func isImpersonationDenied(status *metav1.Status) bool {
+	return status.Reason == metav1.StatusReasonForbidden && strings.Contains(status.Message, "cannot impersonate")
+}
+
+// explainImpersonationDenied rewrites the message of the Status, as users would otherwise
+// assume that their own RBAC permissions are missing.
+func explainImpersonationDenied(resp *http.Response, status *metav1.Status) error {
+	log.Printf("Warning: the proxy is not allowed to impersonate users, check the RBAC permissions of its service account: %s", status.Message)
+	status.Message = fmt.Sprintf("the Kubernetes API proxy is misconfigured and not allowed to impersonate you, "+
+		"this is not an issue with your permissions: %s", status.Message)
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// readStatus decodes a Kubernetes Status object from the response body and restores
+// the body afterward. Only small, uncompressed JSON bodies with a known length are
+// considered, so streaming responses are never consumed. It returns nil if the body
+// is not eligible.
+func readStatus(resp *http.Response) (*metav1.Status, error) {
+	if resp.ContentLength < 0 || resp.ContentLength > maxStatusBodySize {
+		return nil, nil
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		return nil, nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	status := new(metav1.Status)
+	if err = json.Unmarshal(body, status); err != nil {
+		return nil, err
+	}
+	if status.Kind != "Status" {
+		return nil, nil
+	}
+
+	return status, nil
+}
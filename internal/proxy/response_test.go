@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// listItem is a pod of the synthetic lists, about 300 bytes in size.
+const listItem = `{"metadata":{"name":"pod","namespace":"default","uid":"0b6f2a52-2b1e-4c55-9d6c-6f1c1c7e6d2a","resourceVersion":"12345","labels":{"app":"benchmark"}},"spec":{"containers":[{"name":"app","image":"registry.example.com/app:1.0"}]},"status":{"phase":"Running","podIP":"10.0.0.1"}}`
+
+// writeList streams a pod list of at least size bytes, without holding it in memory.
+func writeList(w io.Writer, size int) {
+	_, _ = io.WriteString(w, `{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"12345"},"items":[`)
+	_, _ = io.WriteString(w, listItem)
+	for written := len(listItem); written < size; written += len(listItem) + 1 {
+		_, _ = io.WriteString(w, ",")
+		_, _ = io.WriteString(w, listItem)
+	}
+	_, _ = io.WriteString(w, "]}")
+}
+
+// peakHeap samples the heap size until stop is closed and returns the peak.
+func peakHeap(stop <-chan struct{}) <-chan uint64 {
+	peak := make(chan uint64, 1)
+	go func() {
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		var peakSize uint64
+		for {
+			metrics.Read(sample)
+			peakSize = max(peakSize, sample[0].Value.Uint64())
+			select {
+			case <-stop:
+				peak <- peakSize
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return peak
+}
+
+// BenchmarkLargeList proxies lists of growing size. The peak heap must stay flat instead
+// of growing with the list, as the responses are streamed to the client.
+func BenchmarkLargeList(b *testing.B) {
+	for _, mib := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("%dMiB", mib), func(b *testing.B) {
+			size := mib << 20
+			_, srv, api := newTestProxy(b, nil)
+			api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				writeList(w, size)
+			}))
+
+			runtime.GC()
+			stop := make(chan struct{})
+			peak := peakHeap(stop)
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for b.Loop() {
+				resp, err := http.Get(srv.URL + "/api/v1/pods")
+				if err != nil {
+					b.Fatalf("request failed: %v", err)
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n < int64(size) {
+					b.Fatalf("read %d bytes, error = %v", n, err)
+				}
+			}
+
+			close(stop)
+			b.ReportMetric(float64(<-peak)/(1<<20), "peak-heap-MiB")
+		})
+	}
+}
+
+func TestLargeListIsComplete(t *testing.T) {
+	_, srv, api := newTestProxy(t, nil)
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		writeList(w, 4<<20)
+	}))
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body strings.Builder
+	if _, err = io.Copy(&body, resp.Body); err != nil {
+		t.Fatalf("failed to read list: %v", err)
+	}
+	want := new(strings.Builder)
+	writeList(want, 4<<20)
+	if body.String() != want.String() {
+		t.Errorf("received %d bytes, want the %d bytes of the list", body.Len(), want.Len())
+	}
+}
+
+// rejectWith responds to every request with the Status, like the API server rejecting it.
+func rejectWith(status metav1.Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Code))
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
+
+// getStatus requests the path from the proxy and decodes the Status of the response.
+func getStatus(t *testing.T, url string) (*http.Response, metav1.Status) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status metav1.Status
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	return resp, status
+}
+
+func TestImpersonationDeniedIsExplained(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		wantRewrite bool
+	}{
+		{
+			name:        "impersonation denied",
+			message:     `users "alice@example.com" is forbidden: User "system:serviceaccount:proxy:proxy" cannot impersonate resource "users" in API group "" at the cluster scope`,
+			wantRewrite: true,
+		},
+		{
+			name:    "permission of the user",
+			message: `pods is forbidden: User "alice@example.com" cannot list resource "pods" in API group "" at the cluster scope`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv, api := newTestProxy(t, nil)
+			api.SetHandler(rejectWith(metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure,
+				Message:  tt.message,
+				Reason:   metav1.StatusReasonForbidden,
+				Code:     http.StatusForbidden,
+			}))
+
+			resp, status := getStatus(t, srv.URL+"/api/v1/pods")
+			if resp.StatusCode != http.StatusForbidden || status.Reason != metav1.StatusReasonForbidden {
+				t.Errorf("response = %d %s, want the rejection of the API server", resp.StatusCode, status.Reason)
+			}
+			want := tt.message
+			if tt.wantRewrite {
+				want = "the Kubernetes API proxy is misconfigured and not allowed to impersonate you, " +
+					"this is not an issue with your permissions: " + tt.message
+			}
+			if status.Message != want {
+				t.Errorf("message = %q, want %q", status.Message, want)
+			}
+		})
+	}
+}
+
+func TestRejectionIsLogged(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    string
+	}{
+		{
+			name: "forbidden",
+			handler: rejectWith(metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure,
+				Message:  `pods is forbidden: User "alice@example.com" cannot list resource "pods"`,
+				Reason:   metav1.StatusReasonForbidden,
+				Code:     http.StatusForbidden,
+			}),
+			want: `GET /api/v1/pods rejected with 403 user=alice@example.com groups=developers reason=Forbidden ` +
+				`message="pods is forbidden: User \"alice@example.com\" cannot list resource \"pods\""`,
+		},
+		{
+			name: "unauthorized",
+			handler: rejectWith(metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure,
+				Message:  "Unauthorized",
+				Reason:   metav1.StatusReasonUnauthorized,
+				Code:     http.StatusUnauthorized,
+			}),
+			want: `GET /api/v1/pods rejected with 401 user=alice@example.com groups=developers reason=Unauthorized message="Unauthorized"`,
+		},
+		{
+			name: "not a status",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, "forbidden by the ingress")
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			_, srv, api := newTestProxy(t, nil)
+			api.SetHandler(tt.handler)
+
+			resp, err := http.Get(srv.URL + "/api/v1/pods")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if len(body) == 0 {
+				t.Error("the body of the rejection was not forwarded")
+			}
+
+			if tt.want == "" {
+				if strings.Contains(logs.String(), "rejected with") {
+					t.Errorf("log = %q, want no rejection to be logged", logs.String())
+				}
+				return
+			}
+			waitForLog(t, logs, tt.want)
+		})
+	}
+}
+
+func TestAPIWarningsAreLogged(t *testing.T) {
+	warn := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Add("Warning", `299 - "policy/v1beta1 PodSecurityPolicy is deprecated in v1.21+"`)
+		w.Header().Add("Warning", `299 - "unknown field \"spec.foo\""`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, "{}")
+	})
+	get := func(t *testing.T, srv string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(srv + "/apis/policy/v1beta1/podsecuritypolicies")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	logs := captureLog(t)
+	_, srv, api := newTestProxy(t, map[string]any{"log_api_warnings": true})
+	api.SetHandler(warn)
+	resp := get(t, srv.URL)
+	if got := resp.Header.Values("Warning"); len(got) != 2 {
+		t.Errorf("Warning headers = %q, want both forwarded to the client", got)
+	}
+	prefix := "Warning from API server for GET /apis/policy/v1beta1/podsecuritypolicies user=" + testLogin + ": "
+	waitForLog(t, logs, prefix+"policy/v1beta1 PodSecurityPolicy is deprecated in v1.21+")
+	waitForLog(t, logs, prefix+`unknown field "spec.foo"`)
+
+	logs = captureLog(t)
+	_, srv, api = newTestProxy(t, nil)
+	api.SetHandler(warn)
+	get(t, srv.URL)
+	if strings.Contains(logs.String(), "Warning from API server") {
+		t.Errorf("log = %q, want no warnings unless enabled", logs.String())
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/version"
+)
+
+// Supported responses to requests of the root path.
+const (
+	rootResponseForward = "forward"
+	rootResponseInfo    = "info"
+)
+
+// BackendStater is optionally implemented by the IdentityResolver to report the state of
+// the Tailscale node in the info response.
+type BackendStater interface {
+	BackendState(ctx context.Context) (string, error)
+}
+
+// proxyInfo is returned for requests of the root path in info mode, telling which proxy
+// answered in setups with multiple proxies.
+type proxyInfo struct {
+	Hostname     string `json:"hostname"`
+	Version      string `json:"version"`
+	BackendState string `json:"backendState,omitempty"`
+}
+
+// validateRootResponse returns an error if the root response mode is not supported.
+func validateRootResponse(mode string) error {
+	switch mode {
+	case rootResponseForward, rootResponseInfo:
+		return nil
+	default:
+		return fmt.Errorf("%w: invalid root response %q (expected %s or %s)", ErrInvalidConfig,
+			mode, rootResponseForward, rootResponseInfo)
+	}
+}
+
+// serveInfo responds with the proxyInfo of this instance.
+func (r *ReverseProxy) serveInfo(w http.ResponseWriter, req *http.Request) {
+	info := proxyInfo{
+		Hostname: r.hostname,
+		Version:  version.Get(),
+	}
+	if stater, ok := r.identities.(BackendStater); ok {
+		info.BackendState, _ = stater.BackendState(req.Context())
+	}
+
+	countResponse(http.StatusOK, originProxy)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/version"
+)
+
+// stateResolver is a StaticResolver reporting a fixed backend state.
+type stateResolver struct {
+	*testutil.StaticResolver
+	state string
+}
+
+func (s stateResolver) BackendState(context.Context) (string, error) {
+	return s.state, nil
+}
+
+func TestRootResponseForward(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"root_response": rootResponseForward})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	if echo := doEcho(t, req); echo.Path != "/" {
+		t.Errorf("path = %q, want the root path to be forwarded", echo.Path)
+	}
+	if len(api.Requests()) != 1 {
+		t.Errorf("API server received %d requests, want 1", len(api.Requests()))
+	}
+}
+
+func TestRootResponseInfo(t *testing.T) {
+	api := testutil.NewFakeAPIServer(t)
+	testutil.Configure(t, map[string]any{"root_response": rootResponseInfo, "ts.hostname": "kube-proxy-eu"})
+	p, err := NewKubeProxy(api.Config(), stateResolver{StaticResolver: testutil.NewStaticResolver(), state: "Running"})
+	if err != nil {
+		t.Fatalf("NewKubeProxy() error = %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var info proxyInfo
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode info: %v", err)
+	}
+	want := proxyInfo{Hostname: "kube-proxy-eu", Version: version.Get(), BackendState: "Running"}
+	if info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+	if len(api.Requests()) != 0 {
+		t.Errorf("API server received %d requests, want none", len(api.Requests()))
+	}
+
+	// Only GET requests of the root path itself are answered by the proxy.
+	post, _ := http.NewRequest(http.MethodPost, srv.URL+"/", nil)
+	doEcho(t, post)
+	apiRoot, _ := http.NewRequest(http.MethodGet, srv.URL+"/api", nil)
+	doEcho(t, apiRoot)
+	if len(api.Requests()) != 2 {
+		t.Errorf("API server received %d requests, want 2", len(api.Requests()))
+	}
+
+	if err = validateRootResponse("redirect"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("validateRootResponse() error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxSessions bounds the number of tracked requests. Requests beyond the limit are
+// still proxied, they just don't show up in the list of sessions.
+const maxSessions = 1024
+
+// Session describes a request that is currently being proxied.
+type Session struct {
+	ID     uint64    `json:"id"`
+	User   string    `json:"user"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Start  time.Time `json:"start"`
+}
+
+// sessionRegistry keeps track of the requests that are currently being proxied.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[uint64]Session
+}
+
+// newSessionRegistry creates an empty registry.
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[uint64]Session),
+	}
+}
+
+// add registers the request of the user. The returned function must be called once
+// the request completed.
+func (s *sessionRegistry) add(req *http.Request, user string) (remove func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) >= maxSessions {
+		return func() {}
+	}
+
+	s.nextID++
+	id := s.nextID
+	s.sessions[id] = Session{
+		ID:     id,
+		User:   user,
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Start:  time.Now(),
+	}
+
+	return func() {
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+	}
+}
+
+// list returns all tracked sessions ordered by their start time.
+func (s *sessionRegistry) list() []Session {
+	s.mu.Lock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return a.Start.Compare(b.Start)
+	})
+	return sessions
+}
+
+// Sessions returns the requests that are currently being proxied.
+func (r *ReverseProxy) Sessions() []Session {
+	return r.sessions.list()
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// sourceFilter restricts the client addresses accepted by the proxy, e.g. to the ranges
+// of a self-hosted control server or to a subset of the tailnet. An empty filter
+// accepts all addresses.
+type sourceFilter []netip.Prefix
+
+// parseSourceFilter parses the CIDRs of the allowed source addresses.
+func parseSourceFilter(cidrs []string) (sourceFilter, error) {
+	filter := make(sourceFilter, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid source CIDR %q: %w", ErrInvalidConfig, cidr, err)
+		}
+		filter = append(filter, prefix.Masked())
+	}
+	return filter, nil
+}
+
+// allowed returns true if the remote address of a request is within an allowed range.
+// Addresses that can't be parsed are rejected if any range is configured.
+func (f sourceFilter) allowed(remoteAddr string) bool {
+	if len(f) == 0 {
+		return true
+	}
+
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range f {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
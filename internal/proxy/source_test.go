@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSourceFilter(t *testing.T) {
+	filter, err := parseSourceFilter([]string{"100.64.0.0/10", "fd7a:115c:a1e0::/48", "10.1.2.3/8"})
+	if err != nil {
+		t.Fatalf("parseSourceFilter() error = %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "100.100.1.2:41641", want: true},
+		{remoteAddr: "[fd7a:115c:a1e0::1]:41641", want: true},
+		{remoteAddr: "[::ffff:100.64.0.1]:41641", want: true},
+		{remoteAddr: "10.200.0.1:41641", want: true},
+		{remoteAddr: "192.168.1.1:41641"},
+		{remoteAddr: "[fd7a:115c:a1e1::1]:41641"},
+		{remoteAddr: "100.64.0.1"},
+		{remoteAddr: "@"},
+	}
+	for _, tt := range tests {
+		if got := filter.allowed(tt.remoteAddr); got != tt.want {
+			t.Errorf("allowed(%q) = %t, want %t", tt.remoteAddr, got, tt.want)
+		}
+	}
+
+	if !sourceFilter(nil).allowed("@") {
+		t.Error("allowed() of an empty filter = false, want all addresses")
+	}
+	if _, err = parseSourceFilter([]string{"100.64.0.0"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("parseSourceFilter() of an address error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestSourceFilterRejectsRequests(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"allowed_source_cidrs": []string{"100.64.0.0/10"}})
+
+	resp, status := getStatus(t, srv.URL+"/api/v1/pods")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d (%s), want %d", resp.StatusCode, status.Message, http.StatusForbidden)
+	}
+	if len(api.Requests()) != 0 {
+		t.Errorf("API server received %d requests, want none", len(api.Requests()))
+	}
+
+	_, srv, _ = newTestProxy(t, map[string]any{"allowed_source_cidrs": []string{"127.0.0.0/8"}})
+	resp, err := http.Get(srv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status from an allowed source = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
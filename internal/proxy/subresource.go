@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// subresourceBlock denies a subresource to identities matching its principals, which
+// must either all match if matchAll is set, or any of them.
+type subresourceBlock struct {
+	principals  []string
+	matchAll    bool
+	resource    string
+	subresource string
+}
+
+// subresourceBlocks keep users, groups or tagged nodes from powerful subresources like
+// pods/exec, while they may still use the rest of the API.
+type subresourceBlocks []subresourceBlock
+
+// parseSubresourceBlocks parses the blocks given as '<principals>=<resource>/<subresource>'
+// pairs. A principal is 'user:<name>' or 'group:<name>' of the Kubernetes identity, an
+// ACL tag like 'tag:ci' or '*' for everyone. Multiple principals are joined with '&' if
+// all of them must match, e.g. 'tag:dev&group:contractors', or with '|' if any of them
+// must match. Both can't be mixed in one block.
+func parseSubresourceBlocks(pairs []string) (subresourceBlocks, error) {
+	blocks := make(subresourceBlocks, 0, len(pairs))
+	for _, pair := range pairs {
+		principals, target, ok := strings.Cut(pair, "=")
+		resource, subresource, _ := strings.Cut(target, "/")
+		if !ok || resource == "" || subresource == "" {
+			return nil, fmt.Errorf("%w: subresource block %q must be of the form <principals>=<resource>/<subresource>", ErrInvalidConfig, pair)
+		}
+
+		block := subresourceBlock{resource: resource, subresource: subresource}
+		switch {
+		case strings.Contains(principals, "&") && strings.Contains(principals, "|"):
+			return nil, fmt.Errorf("%w: subresource block %q mixes '&' and '|'", ErrInvalidConfig, pair)
+		case strings.Contains(principals, "&"):
+			block.principals, block.matchAll = strings.Split(principals, "&"), true
+		default:
+			block.principals = strings.Split(principals, "|")
+		}
+		for _, principal := range block.principals {
+			if !validBlockPrincipal(principal) {
+				return nil, fmt.Errorf("%w: subresource block %q has an invalid principal %q (expected user:<name>, group:<name>, tag:<name> or *)", ErrInvalidConfig, pair, principal)
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// validBlockPrincipal returns true if the principal has a supported form.
+func validBlockPrincipal(principal string) bool {
+	if principal == "*" {
+		return true
+	}
+	kind, name, ok := strings.Cut(principal, ":")
+	return ok && name != "" && (kind == "user" || kind == "group" || kind == "tag")
+}
+
+// blocked returns the blocked subresource of the request as '<resource>/<subresource>',
+// or an empty string if the identity may access it.
+func (b subresourceBlocks) blocked(id *identity, info requestInfo) string {
+	if info.Subresource == "" {
+		return ""
+	}
+
+	for _, block := range b {
+		if block.resource != info.Resource || block.subresource != info.Subresource {
+			continue
+		}
+		if block.matches(id) {
+			return block.resource + "/" + block.subresource
+		}
+	}
+	return ""
+}
+
+// matches returns true if the identity is covered by the principals of the block.
+func (b subresourceBlock) matches(id *identity) bool {
+	for _, principal := range b.principals {
+		if matchesPrincipal(id, principal) != b.matchAll {
+			return !b.matchAll
+		}
+	}
+	return b.matchAll
+}
+
+// matchesPrincipal returns true if the identity is the user, a member of the group or
+// has the tag of the principal.
+func matchesPrincipal(id *identity, principal string) bool {
+	kind, name, _ := strings.Cut(principal, ":")
+	switch kind {
+	case "*":
+		return true
+	case "user":
+		return id.User == name
+	case "group":
+		return slices.Contains(id.Groups, name)
+	default:
+		return slices.Contains(id.Tags, principal)
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSubresourceDetection(t *testing.T) {
+	blocks, err := parseSubresourceBlocks([]string{"*=pods/exec", "*=pods/attach", "*=pods/portforward"})
+	if err != nil {
+		t.Fatalf("parseSubresourceBlocks() error = %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/pods/web/exec", want: "pods/exec"},
+		{method: http.MethodGet, path: "/api/v1/namespaces/default/pods/web/exec", want: "pods/exec"},
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/pods/web/attach", want: "pods/attach"},
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/pods/web/portforward", want: "pods/portforward"},
+		{method: http.MethodGet, path: "/api/v1/watch/namespaces/default/pods/web/exec", want: "pods/exec"},
+		{method: http.MethodGet, path: "/api/v1/watch/namespaces/default/pods/web/attach", want: "pods/attach"},
+		{method: http.MethodPost, path: "//api/v1/namespaces/default/pods/web/exec", want: "pods/exec"},
+		{method: http.MethodPost, path: "/api/v1/namespaces//default/pods//web/exec", want: "pods/exec"},
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/pods/web//portforward/", want: "pods/portforward"},
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/pods/other/../web/attach", want: "pods/attach"},
+		{method: http.MethodGet, path: "/api/v1/namespaces/default/pods/web/log", want: ""},
+		{method: http.MethodGet, path: "/api/v1/namespaces/default/pods/web", want: ""},
+		{method: http.MethodGet, path: "/api/v1/namespaces/default/pods/exec", want: ""},
+		{method: http.MethodGet, path: "/api/v1/watch/namespaces/default/pods", want: ""},
+		{method: http.MethodPost, path: "/api/v1/namespaces/default/services/web/exec", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL.Path = tt.path
+
+			if got := blocks.blocked(&identity{}, parseRequestInfo(req)); got != tt.want {
+				t.Errorf("blocked() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubresourceBlocked(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"block_subresources": []string{"group:developers=pods/exec"}})
+
+	resp, err := http.Post(srv.URL+"//api/v1/namespaces/default/pods/web//exec?command=sh", "", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status metav1.Status
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden || status.Message != "access to pods/exec is blocked by this proxy" {
+		t.Errorf("response = %d %q, want the subresource to be blocked", resp.StatusCode, status.Message)
+	}
+	if n := len(api.Requests()); n != 0 {
+		t.Errorf("upstream received %d requests, want none", n)
+	}
+}
+
+func TestSubresourceBlockPrincipals(t *testing.T) {
+	contractor := &identity{User: "bob", Groups: []string{"contractors"}, Tags: []string{"tag:dev"}}
+	employee := &identity{User: "alice", Groups: []string{"developers"}, Tags: []string{"tag:dev"}}
+	ci := &identity{User: "ci", Tags: []string{"tag:ci"}}
+
+	tests := []struct {
+		name  string
+		block string
+		id    *identity
+		want  bool
+	}{
+		{name: "everyone", block: "*=pods/exec", id: employee, want: true},
+		{name: "user", block: "user:bob=pods/exec", id: contractor, want: true},
+		{name: "other user", block: "user:bob=pods/exec", id: employee, want: false},
+		{name: "group", block: "group:contractors=pods/exec", id: contractor, want: true},
+		{name: "tag", block: "tag:ci=pods/exec", id: ci, want: true},
+		{name: "all of, all match", block: "tag:dev&group:contractors=pods/exec", id: contractor, want: true},
+		{name: "all of, one matches", block: "tag:dev&group:contractors=pods/exec", id: employee, want: false},
+		{name: "all of, none match", block: "tag:dev&group:contractors=pods/exec", id: ci, want: false},
+		{name: "all of, three", block: "user:bob&tag:dev&group:contractors=pods/exec", id: contractor, want: true},
+		{name: "any of, first matches", block: "tag:ci|group:contractors=pods/exec", id: ci, want: true},
+		{name: "any of, second matches", block: "tag:ci|group:contractors=pods/exec", id: contractor, want: true},
+		{name: "any of, none match", block: "tag:ci|group:contractors=pods/exec", id: employee, want: false},
+		{name: "any of, with everyone", block: "user:nobody|*=pods/exec", id: employee, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks, err := parseSubresourceBlocks([]string{tt.block})
+			if err != nil {
+				t.Fatalf("parseSubresourceBlocks() error = %v", err)
+			}
+			info := requestInfo{IsResourceRequest: true, Resource: "pods", Name: "web", Subresource: "exec"}
+			if got := blocks.blocked(tt.id, info) != ""; got != tt.want {
+				t.Errorf("blocked = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubresourceBlocksErrors(t *testing.T) {
+	for _, block := range []string{
+		"tag:dev&group:contractors|user:bob=pods/exec",
+		"pods/exec",
+		"tag:dev=pods",
+		"team:dev=pods/exec",
+		"tag:dev&=pods/exec",
+	} {
+		if _, err := parseSubresourceBlocks([]string{block}); err == nil {
+			t.Errorf("parseSubresourceBlocks(%q) error = nil, want an error", block)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagNamespaces maps ACL tags to the namespaces that tagged nodes may access.
+type tagNamespaces map[string][]string
+
+// parseTagNamespaces parses the mappings given as 'tag:name=namespace' pairs. A tag may
+// be mapped to multiple namespaces by repeating it.
+func parseTagNamespaces(pairs []string) (tagNamespaces, error) {
+	namespaces := make(tagNamespaces)
+	for _, pair := range pairs {
+		tag, namespace, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(tag, "tag:") || namespace == "" {
+			return nil, fmt.Errorf("%w: tag namespace %q must be of the form tag:<name>=<namespace>", ErrInvalidConfig, pair)
+		}
+		namespaces[tag] = append(namespaces[tag], namespace)
+	}
+	return namespaces, nil
+}
+
+// allowed reports whether a node with the tags may send the request. Nodes with a mapped
+// tag are confined to the namespaces of their tags, so resource requests outside of them,
+// including cluster-scoped and all-namespace requests, are denied. Non-resource requests
+// like discovery are always allowed, as clients depend on them.
+func (t tagNamespaces) allowed(tags []string, info requestInfo) bool {
+	restricted := false
+	for _, tag := range tags {
+		namespaces, ok := t[tag]
+		if !ok {
+			continue
+		}
+		restricted = true
+
+		for _, namespace := range namespaces {
+			if info.Namespace == namespace {
+				return true
+			}
+		}
+	}
+	return !restricted || !info.IsResourceRequest
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestTagNamespaces(t *testing.T) {
+	namespaces, err := parseTagNamespaces([]string{"tag:ci=ci", "tag:ci=builds", "tag:web=web"})
+	if err != nil {
+		t.Fatalf("parseTagNamespaces() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		tags []string
+		path string
+		want bool
+	}{
+		{name: "inside namespace", tags: []string{"tag:ci"}, path: "/api/v1/namespaces/ci/pods", want: true},
+		{name: "inside second namespace", tags: []string{"tag:ci"}, path: "/apis/apps/v1/namespaces/builds/deployments/app", want: true},
+		{name: "namespace itself", tags: []string{"tag:ci"}, path: "/api/v1/namespaces/ci", want: true},
+		{name: "outside namespace", tags: []string{"tag:ci"}, path: "/api/v1/namespaces/web/pods", want: false},
+		{name: "namespace of other tag", tags: []string{"tag:ci", "tag:web"}, path: "/api/v1/namespaces/web/pods", want: true},
+		{name: "cluster-scoped", tags: []string{"tag:ci"}, path: "/api/v1/nodes", want: false},
+		{name: "all namespaces", tags: []string{"tag:ci"}, path: "/api/v1/pods", want: false},
+		{name: "non-resource", tags: []string{"tag:ci"}, path: "/apis", want: true},
+		{name: "unmapped tag", tags: []string{"tag:other"}, path: "/api/v1/nodes", want: true},
+		{name: "untagged user", tags: nil, path: "/api/v1/namespaces/web/pods", want: true},
+		{name: "untagged user cluster-scoped", tags: nil, path: "/api/v1/nodes", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if got := namespaces.allowed(tt.tags, parseRequestInfo(req)); got != tt.want {
+				t.Errorf("allowed() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagNamespacesConfineTaggedNode(t *testing.T) {
+	p, srv, _ := newTestProxy(t, map[string]any{"tag_namespaces": []string{"tag:ci=ci"}})
+	p.identities.(*testutil.StaticResolver).SetUser("127.0.0.1", &tailscale.UserProfile{
+		UserProfile: tailcfg.UserProfile{LoginName: "ci-runner"},
+		Tags:        []string{"tag:ci"},
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/api/v1/namespaces/ci/pods", want: http.StatusOK},
+		{path: "/api/v1/namespaces/default/pods", want: http.StatusForbidden},
+		{path: "/api/v1/nodes", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("status of %s = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timeoutRule limits the duration of requests with a verb and path.
+type timeoutRule struct {
+	verb    string
+	path    *regexp.Regexp
+	timeout time.Duration
+}
+
+// timeoutRules are matched in order, the first matching rule applies.
+type timeoutRules []timeoutRule
+
+// parseTimeoutRules parses the rules given as '<verb>[:<path pattern>]=<duration>', e.g.
+// 'get=10s' or 'patch:^/apis/apps/=1m'. The verb '*' matches all verbs, and the path
+// pattern is a regular expression matched against the cleaned request path.
+func parseTimeoutRules(specs []string) (timeoutRules, error) {
+	rules := make(timeoutRules, 0, len(specs))
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: timeout rule %q must be of the form <verb>[:<path pattern>]=<duration>", ErrInvalidConfig, spec)
+		}
+
+		timeout, err := time.ParseDuration(spec[i+1:])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%w: timeout rule %q has an invalid duration", ErrInvalidConfig, spec)
+		}
+
+		verb, pattern, _ := strings.Cut(spec[:i], ":")
+		if verb == "" {
+			return nil, fmt.Errorf("%w: timeout rule %q has no verb", ErrInvalidConfig, spec)
+		}
+
+		rule := timeoutRule{verb: strings.ToLower(verb), timeout: timeout}
+		if pattern != "" {
+			patterns, err := compilePatterns([]string{pattern})
+			if err != nil {
+				return nil, err
+			}
+			rule.path = patterns[0]
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// timeout returns the timeout of the first rule matching the request, or 0 if none
+// applies. Watches, streams and other long-running requests are never limited, as
+// they are expected to stay open.
+func (t timeoutRules) timeout(req *http.Request) time.Duration {
+	if len(t) == 0 || isLongRunning(req) {
+		return 0
+	}
+
+	verb := parseRequestInfo(req).Verb
+	p := path.Clean("/" + req.URL.Path)
+	for _, rule := range t {
+		if rule.verb != "*" && rule.verb != verb {
+			continue
+		}
+		if rule.path != nil && !rule.path.MatchString(p) {
+			continue
+		}
+		return rule.timeout
+	}
+	return 0
+}
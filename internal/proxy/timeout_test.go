@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimeoutRulesErrors(t *testing.T) {
+	for _, spec := range []string{"get", "get=fast", "get=-1s", "=10s", "get:[=10s"} {
+		if _, err := parseTimeoutRules([]string{spec}); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("parseTimeoutRules(%q) error = %v, want %v", spec, err, ErrInvalidConfig)
+		}
+	}
+}
+
+func TestTimeoutRules(t *testing.T) {
+	rules, err := parseTimeoutRules([]string{"patch:^/apis/apps/=1m", "get=10s", "*:^/api/v1/namespaces/[^/]+/pods=30s"})
+	if err != nil {
+		t.Fatalf("parseTimeoutRules() error = %v", err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		want   time.Duration
+	}{
+		{http.MethodPatch, "/apis/apps/v1/namespaces/default/deployments/web", time.Minute},
+		{http.MethodGet, "/apis/apps/v1/namespaces/default/deployments/web", 10 * time.Second},
+		{http.MethodGet, "/api/v1/namespaces/default/pods", 30 * time.Second},
+		{http.MethodDelete, "/api/v1/namespaces/default/pods/web", 30 * time.Second},
+		{http.MethodPatch, "/api/v1/namespaces/default/configmaps/web", 0},
+		{http.MethodGet, "/api/v1/namespaces/default/pods?watch=true", 0},
+		{http.MethodPatch, "//apis/apps/v1/namespaces/default/deployments/web", time.Minute},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := rules.timeout(req); got != tt.want {
+			t.Errorf("timeout(%s %s) = %s, want %s", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTimeoutRuleCancelsRequest(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"timeout_rules": []string{"list=50ms"}})
+	api.SetHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// parseFingerprint decodes a SHA-256 certificate fingerprint given as hex string.
+// Colons and a "sha256:" prefix, as printed by common tools, are accepted.
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "sha256:")
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("%w: invalid SHA-256 fingerprint %q", ErrInvalidConfig, s)
+	}
+	return fingerprint, nil
+}
+
+// pinCertificate configures the TLS config to only accept a server certificate that
+// matches the given SHA-256 fingerprint, instead of verifying it against a CA.
+func pinCertificate(config *tls.Config, fingerprint []byte) {
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("server certificate fingerprint %x does not match pinned fingerprint", sum)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	want := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{name: "hex", input: want},
+		{name: "colons", input: strings.TrimSuffix(strings.Repeat("AB:", sha256.Size), ":")},
+		{name: "prefix", input: "sha256:" + want},
+		{name: "too short", input: "abcd", wantErr: true},
+		{name: "not hex", input: strings.Repeat("zz", sha256.Size), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fingerprint, err := parseFingerprint(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidConfig) {
+					t.Errorf("parseFingerprint() error = %v, want %v", err, ErrInvalidConfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFingerprint() error = %v", err)
+			}
+			if got := hex.EncodeToString(fingerprint); got != want {
+				t.Errorf("parseFingerprint() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPinnedCertificate(t *testing.T) {
+	api := testutil.NewUnstartedFakeAPIServer(t)
+	api.StartTLS()
+	sum := sha256.Sum256(api.Certificate().Raw)
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		wantStatus  int
+	}{
+		{name: "match", fingerprint: hex.EncodeToString(sum[:]), wantStatus: http.StatusOK},
+		{name: "mismatch", fingerprint: strings.Repeat("00", sha256.Size), wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without the CA, the certificate can only be accepted by its fingerprint.
+			config := api.Config()
+			config.CAData = nil
+			_, srv := serveTestProxy(t, config, map[string]any{"pinned_server_cert": tt.fingerprint})
+
+			resp, err := http.Get(srv.URL + "/api/v1/pods")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestInsecureWarningStopsOnClose(t *testing.T) {
+	logs := captureLog(t)
+	p, _, _ := newTestProxy(t, map[string]any{
+		"insecure":               true,
+		"i_understand_insecure":  true,
+		"insecure_warn_interval": 5 * time.Millisecond,
+	})
+
+	// The first warning is logged when the transport is created.
+	deadline := time.Now().Add(time.Second)
+	for strings.Count(logs.String(), insecureWarning) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	closed := strings.Count(logs.String(), insecureWarning)
+	if closed < 3 {
+		t.Fatalf("logged the warning %d times, want it repeated", closed)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := strings.Count(logs.String(), insecureWarning); got != closed {
+		t.Errorf("logged the warning %d more times after Close()", got-closed)
+	}
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+)
+
+// insecureWarning is logged if the TLS verification of the API server is disabled.
+const insecureWarning = "Warning: TLS verification of the Kubernetes API server is disabled"
+
+// dialFunc establishes a network connection, matching http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newTransport builds the transport used to forward requests to the API server.
+// It uses the TLS settings and credentials of the Kubernetes client config, but
+// allows customizing how upstream connections are established. Connections are
+// spread across the discovered endpoints of the API server if endpoints is set.
+func newTransport(settings *viper.Viper, config *rest.Config, endpoints *endpointDiscovery) (http.RoundTripper, error) {
+	insecure := settings.GetBool("insecure")
+	pinnedCert := settings.GetString("pinned_server_cert")
+	if insecure && pinnedCert != "" {
+		return nil, fmt.Errorf("%w: insecure and pinned server certificate are mutually exclusive", ErrInvalidConfig)
+	}
+
+	// Disabling the verification must be confirmed, unless debugging during development.
+	if insecure && !settings.GetBool("i_understand_insecure") && !settings.GetBool("debug") {
+		return nil, fmt.Errorf("%w: insecure requires --i-understand-insecure, as it allows intercepting the proxy's credentials", ErrInvalidConfig)
+	}
+
+	// Skip the CA based verification if the certificate is pinned or verification is
+	// disabled entirely. client-go refuses to combine a CA with the insecure flag.
+	if insecure || pinnedCert != "" {
+		config = rest.CopyConfig(config)
+		config.Insecure = true
+		config.CAFile = ""
+		config.CAData = nil
+	}
+	if insecure {
+		log.Println(insecureWarning)
+		insecureMode.Set(1)
+	}
+
+	// Verify the certificate against a different name than the host of the URL, e.g. if
+	// the API server is addressed by an IP that is not part of the certificate.
+	if serverName := settings.GetString("upstream_server_name"); serverName != "" {
+		config = rest.CopyConfig(config)
+		config.ServerName = serverName
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS config: %w", err)
+	}
+
+	if pinnedCert != "" {
+		fingerprint, err := parseFingerprint(pinnedCert)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			return nil, fmt.Errorf("%w: pinned server certificate requires a HTTPS API server URL", ErrInvalidConfig)
+		}
+		pinCertificate(tlsConfig, fingerprint)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if addr := settings.GetString("cluster_dns"); addr != "" {
+		resolver, err := newResolver(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Resolver = resolver
+	}
+	dial := dialFunc(dialer.DialContext)
+
+	// Connect to an API server listening on a Unix socket. Its certificate is still
+	// verified unless plain HTTP is explicitly requested, but the name to verify can't be
+	// taken from the URL.
+	if socket, plain := unixSocket(config.Host); socket != "" {
+		if !plain && config.ServerName == "" && !insecure && pinnedCert == "" {
+			return nil, fmt.Errorf("%w: API server on a Unix socket requires --upstream-server-name to verify its certificate, or a unix+http:// URL for plain HTTP", ErrInvalidConfig)
+		}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	if endpoints != nil {
+		dial = endpoints.dial(dial)
+	}
+
+	if settings.GetBool("send_proxy_protocol") {
+		dial = withProxyProtocol(dial)
+	}
+
+	proxy, err := upstreamProxy(settings, config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 25,
+		ForceAttemptHTTP2:   true,
+
+		// Wait for the API server to accept requests with 'Expect: 100-continue' before
+		// sending the body, so that large applies it rejects early are not uploaded.
+		// The client is only asked to continue once the body is read for forwarding.
+		ExpectContinueTimeout: time.Second,
+	}
+
+	// Ping idle HTTP/2 connections, so that connections of idle watches dropped by an
+	// intermediary are detected and closed instead of hanging indefinitely.
+	if interval := settings.GetDuration("watch_keepalive"); interval > 0 {
+		transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: interval}
+	}
+
+	// Wrap the transport to authenticate with the proxy's own credentials.
+	return rest.HTTPWrappersForConfig(config, transport)
+}
+
+// unixSocket returns the path of the socket if the host is a unix:// or unix+http:// URL,
+// or an empty string otherwise. Plain reports whether the socket serves plain HTTP
+// instead of HTTPS.
+func unixSocket(host string) (socket string, plain bool) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", false
+	}
+	switch u.Scheme {
+	case "unix":
+		return u.Path, false
+	case "unix+http":
+		return u.Path, true
+	}
+	return "", false
+}
+
+// newResolver returns a resolver that sends all queries to the given DNS server instead
+// of the ones from resolv.conf, e.g. to resolve cluster service names like
+// kubernetes.default.svc if the container's resolver is not set up for them.
+func newResolver(addr string) (*net.Resolver, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%w: cluster DNS must be an IP address, got %q", ErrInvalidConfig, host)
+	}
+
+	dialer := new(net.Dialer)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+// upstreamProxy returns the function selecting the HTTP proxy for upstream requests.
+// An explicitly configured proxy is used for all requests, otherwise the proxy is taken
+// from the client config or the HTTPS_PROXY and NO_PROXY environment variables.
+// Credentials in the proxy URL are sent as Proxy-Authorization header.
+func upstreamProxy(settings *viper.Viper, config *rest.Config) (func(*http.Request) (*url.URL, error), error) {
+	raw := settings.GetString("upstream_proxy")
+	if raw == "" {
+		if config.Proxy != nil {
+			return config.Proxy, nil
+		}
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("%w: invalid upstream proxy URL %q", ErrInvalidConfig, raw)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w: unsupported upstream proxy scheme %q", ErrInvalidConfig, proxyURL.Scheme)
+	}
+	if settings.GetBool("send_proxy_protocol") {
+		return nil, fmt.Errorf("%w: upstream proxy and PROXY protocol are mutually exclusive", ErrInvalidConfig)
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
+
+// withProxyProtocol sends a PROXY protocol v2 header on every new upstream connection.
+// As the proxy is the client of the API server, the header announces its own address.
+func withProxyProtocol(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		header := proxyproto.HeaderProxyFromAddrs(2, conn.LocalAddr(), conn.RemoteAddr())
+		if _, err = header.WriteTo(conn); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to send PROXY protocol header: %w", err)
+		}
+
+		return conn, nil
+	}
+}
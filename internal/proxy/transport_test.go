@@ -0,0 +1,444 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"k8s.io/client-go/rest"
+)
+
+// newSocketAPIServer starts a fake API server listening on a Unix socket, serving TLS
+// with a certificate for example.com if requested.
+func newSocketAPIServer(t *testing.T, tls bool) (*testutil.FakeAPIServer, string) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on socket: %v", err)
+	}
+
+	api := testutil.NewUnstartedFakeAPIServer(t)
+	api.Listener = listener
+	if tls {
+		api.StartTLS()
+	} else {
+		api.Start()
+	}
+	return api, socket
+}
+
+func TestUnixSocket(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		scheme     string
+		serverName string
+		wantStatus int
+	}{
+		{name: "TLS", tls: true, scheme: "unix", serverName: "example.com", wantStatus: http.StatusOK},
+		{name: "TLS with other name", tls: true, scheme: "unix", serverName: "other.example.org", wantStatus: http.StatusBadGateway},
+		{name: "plain HTTP", scheme: "unix+http", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, socket := newSocketAPIServer(t, tt.tls)
+			config := api.Config()
+			config.Host = tt.scheme + "://" + socket
+			_, srv := serveTestProxy(t, config, map[string]any{"upstream_server_name": tt.serverName})
+
+			resp, err := http.Get(srv.URL + "/api/v1/namespaces")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := len(api.Requests()); tt.wantStatus == http.StatusOK && got != 1 {
+				t.Errorf("API server received %d requests, want 1", got)
+			}
+		})
+	}
+}
+
+func TestUnixSocketRequiresServerName(t *testing.T) {
+	api, socket := newSocketAPIServer(t, true)
+	config := api.Config()
+	config.Host = "unix://" + socket
+	testutil.Configure(t, nil)
+
+	_, err := NewKubeProxy(config, testutil.NewStaticResolver())
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestUpstreamServerName(t *testing.T) {
+	api := testutil.NewUnstartedFakeAPIServer(t)
+	api.StartTLS()
+
+	// The certificate of the fake API server is valid for example.com and 127.0.0.1, but
+	// not for localhost.
+	tests := []struct {
+		name       string
+		serverName string
+		wantStatus int
+	}{
+		{name: "host of the URL", wantStatus: http.StatusBadGateway},
+		{name: "name of the certificate", serverName: "example.com", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := api.Config()
+			config.Host = strings.Replace(api.URL, "127.0.0.1", "localhost", 1)
+			_, srv := serveTestProxy(t, config, map[string]any{"upstream_server_name": tt.serverName})
+
+			resp, err := http.Get(srv.URL + "/api/v1/pods")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	api := testutil.NewUnstartedFakeAPIServer(t)
+	api.Listener = &proxyproto.Listener{
+		Listener:   ln,
+		ConnPolicy: func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) { return proxyproto.REQUIRE, nil },
+	}
+	api.Start()
+
+	// The API server requires the header, so requests only succeed if it is sent.
+	for _, send := range []bool{true, false} {
+		t.Run(fmt.Sprint("send=", send), func(t *testing.T) {
+			_, srv := serveTestProxy(t, api.Config(), map[string]any{"send_proxy_protocol": send})
+
+			resp, err := http.Get(srv.URL + "/api/v1/pods")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if ok := resp.StatusCode == http.StatusOK; ok != send {
+				t.Errorf("status = %d, want success %t", resp.StatusCode, send)
+			}
+		})
+	}
+
+	testutil.Configure(t, map[string]any{"send_proxy_protocol": true, "upstream_proxy": "http://proxy.example.com:3128"})
+	if _, err = NewKubeProxy(api.Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewKubeProxy() with an upstream proxy error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+// newForwardProxy starts an HTTP proxy forwarding plain HTTP requests, which records
+// the targets and the Proxy-Authorization headers of the requests.
+func newForwardProxy(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+		mu.Unlock()
+
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		maps.Copy(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &seen
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	forward, seen := newForwardProxy(t)
+	api := testutil.NewFakeAPIServer(t)
+	proxyURL := strings.Replace(forward.URL, "http://", "http://user:s3cret@", 1)
+	_, srv := serveTestProxy(t, api.Config(), map[string]any{"upstream_proxy": proxyURL})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	if echo := doEcho(t, req); echo.User != testLogin {
+		t.Errorf("Impersonate-User = %q, want %q", echo.User, testLogin)
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("user:s3cret"))
+	if want := []string{api.URL + "/api/v1/pods Basic " + credentials}; !slices.Equal(*seen, want) {
+		t.Errorf("proxy received %q, want %q", *seen, want)
+	}
+
+	for _, raw := range []string{"ftp://proxy.example.com", "proxy.example.com:3128", "http://"} {
+		testutil.Configure(t, map[string]any{"upstream_proxy": raw})
+		if _, err := NewKubeProxy(api.Config(), testutil.NewStaticResolver()); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewKubeProxy() with upstream proxy %q error = %v, want %v", raw, err, ErrInvalidConfig)
+		}
+	}
+}
+
+func TestUpstreamProxyOfClientConfig(t *testing.T) {
+	forward, seen := newForwardProxy(t)
+	api := testutil.NewFakeAPIServer(t)
+	config := api.Config()
+	proxyURL, _ := url.Parse(forward.URL)
+	config.Proxy = http.ProxyURL(proxyURL)
+	_, srv := serveTestProxy(t, config, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	doEcho(t, req)
+	if len(*seen) != 1 {
+		t.Errorf("proxy received %d requests, want 1", len(*seen))
+	}
+}
+
+// serveDNS starts a DNS server on UDP answering A queries for the name with the address,
+// and returns its address and the names it was queried for.
+func serveDNS(t *testing.T, name string, addr [4]byte) (string, func() []string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	var mu sync.Mutex
+	var queried []string
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err = query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			question := query.Questions[0]
+			mu.Lock()
+			queried = append(queried, question.Name.String())
+			mu.Unlock()
+
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			switch {
+			case !strings.EqualFold(question.Name.String(), name):
+				reply.RCode = dnsmessage.RCodeNameError
+			case question.Type == dnsmessage.TypeA:
+				reply.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: addr},
+				}}
+			}
+			packed, _ := reply.Pack()
+			_, _ = conn.WriteTo(packed, client)
+		}
+	}()
+
+	return conn.LocalAddr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), queried...)
+	}
+}
+
+func TestClusterDNS(t *testing.T) {
+	dns, queried := serveDNS(t, "kubernetes.default.svc.", [4]byte{127, 0, 0, 1})
+	api := testutil.NewFakeAPIServer(t)
+	_, port, _ := net.SplitHostPort(api.Listener.Addr().String())
+	config := api.Config()
+	config.Host = "http://kubernetes.default.svc:" + port
+	_, srv := serveTestProxy(t, config, map[string]any{"cluster_dns": dns})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+	if echo := doEcho(t, req); echo.User != testLogin {
+		t.Errorf("Impersonate-User = %q, want %q", echo.User, testLogin)
+	}
+	if !slices.Contains(queried(), "kubernetes.default.svc.") {
+		t.Errorf("DNS server was queried for %v, want kubernetes.default.svc.", queried())
+	}
+
+	for _, addr := range []string{"kube-dns.kube-system", "kube-dns:53"} {
+		if _, err := newResolver(addr); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("newResolver(%q) error = %v, want %v", addr, err, ErrInvalidConfig)
+		}
+	}
+	if _, err := newResolver("10.96.0.10"); err != nil {
+		t.Errorf("newResolver() without a port error = %v", err)
+	}
+}
+
+// serveHTTP2 starts a minimal HTTP/2 API server, which answers every request with the
+// headers of a watch and keeps the stream open. It reports each PING frame received
+// from the client on the returned channel.
+func serveHTTP2(t *testing.T) (*rest.Config, <-chan struct{}) {
+	t.Helper()
+
+	// Borrow the certificate of a test server.
+	borrowed := httptest.NewUnstartedServer(nil)
+	borrowed.StartTLS()
+	borrowed.Close()
+	tlsConfig := borrowed.TLS.Clone()
+	tlsConfig.NextProtos = []string{"h2"}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	config := &rest.Config{
+		Host:        "https://" + ln.Addr().String(),
+		BearerToken: "proxy-token",
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: borrowed.Certificate().Raw}),
+		},
+	}
+
+	pings := make(chan struct{}, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveHTTP2Conn(conn, pings)
+			}()
+		}
+	}()
+	return config, pings
+}
+
+// serveHTTP2Conn speaks just enough HTTP/2 on the connection for serveHTTP2.
+func serveHTTP2Conn(conn net.Conn, pings chan<- struct{}) {
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil || string(preface) != http2.ClientPreface {
+		return
+	}
+	framer := http2.NewFramer(conn, conn)
+	if err := framer.WriteSettings(); err != nil {
+		return
+	}
+
+	var headers bytes.Buffer
+	encoder := hpack.NewEncoder(&headers)
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return
+		}
+		switch frame := frame.(type) {
+		case *http2.SettingsFrame:
+			if !frame.IsAck() {
+				err = framer.WriteSettingsAck()
+			}
+		case *http2.HeadersFrame:
+			headers.Reset()
+			_ = encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+			_ = encoder.WriteField(hpack.HeaderField{Name: "content-type", Value: "application/json"})
+			err = framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      frame.StreamID,
+				BlockFragment: headers.Bytes(),
+				EndHeaders:    true,
+			})
+		case *http2.PingFrame:
+			if !frame.IsAck() {
+				select {
+				case pings <- struct{}{}:
+				default:
+				}
+				err = framer.WritePing(true, frame.Data)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestWatchKeepalivePings(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepalive time.Duration
+		wantPing  bool
+	}{
+		{name: "enabled", keepalive: 50 * time.Millisecond, wantPing: true},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, pings := serveHTTP2(t)
+			_, srv := serveTestProxy(t, config, map[string]any{"watch_keepalive": tt.keepalive})
+
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/pods?watch=true", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want the watch to be established", resp.StatusCode)
+			}
+
+			// The idle upstream connection of the watch is pinged if enabled.
+			select {
+			case <-pings:
+				if !tt.wantPing {
+					t.Error("the API server was pinged, want no pings")
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.wantPing {
+					t.Error("the API server was not pinged")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// verifyTimeout bounds the time the upstream verification may take.
+const verifyTimeout = 10 * time.Second
+
+// ErrUnexpectedUpstream is returned if the upstream doesn't respond like a Kubernetes
+// API server.
+var ErrUnexpectedUpstream = errors.New("upstream is not a Kubernetes API server")
+
+// VerifyUpstream checks that the upstream responds to /version like a Kubernetes API
+// server, to catch a target pointing at the wrong service before clients do.
+func (r *ReverseProxy) VerifyUpstream(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	target := r.target.JoinPath("version").String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := (&http.Client{Transport: r.http.Transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s responded with %s", ErrUnexpectedUpstream, target, resp.Status)
+	}
+
+	var info version.Info
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return fmt.Errorf("%w: %s responded with invalid version: %v", ErrUnexpectedUpstream, target, err)
+	}
+	if _, err = utilversion.ParseGeneric(info.GitVersion); err != nil {
+		return fmt.Errorf("%w: %s responded with invalid version %q", ErrUnexpectedUpstream, target, info.GitVersion)
+	}
+	return nil
+}
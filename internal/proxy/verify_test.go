@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestVerifyUpstream(t *testing.T) {
+	respond := func(code int, contentType, body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(code)
+			_, _ = io.WriteString(w, body)
+		})
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]any
+		handler  http.Handler
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:     "Kubernetes",
+			handler:  http.HandlerFunc(serveVersionAndPods),
+			wantPath: "/version",
+		},
+		{
+			name:     "Kubernetes below a prefix",
+			settings: map[string]any{"upstream_path_prefix": "/cluster-a"},
+			handler: http.StripPrefix("/cluster-a", respond(http.StatusOK, "application/json",
+				`{"major":"1","minor":"36","gitVersion":"v1.36.0"}`)),
+			wantPath: "/cluster-a/version",
+		},
+		{
+			name:     "not found",
+			handler:  respond(http.StatusNotFound, "text/html", "<h1>404 Not Found</h1>"),
+			wantPath: "/version",
+			wantErr:  ErrUnexpectedUpstream,
+		},
+		{
+			name:     "web server",
+			handler:  respond(http.StatusOK, "text/html", "<h1>Welcome to nginx!</h1>"),
+			wantPath: "/version",
+			wantErr:  ErrUnexpectedUpstream,
+		},
+		{
+			name:     "other JSON API",
+			handler:  respond(http.StatusOK, "application/json", `{"version":"2.4.1"}`),
+			wantPath: "/version",
+			wantErr:  ErrUnexpectedUpstream,
+		},
+		{
+			name:     "invalid version",
+			handler:  respond(http.StatusOK, "application/json", `{"gitVersion":"latest"}`),
+			wantPath: "/version",
+			wantErr:  ErrUnexpectedUpstream,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, api := newTestProxy(t, tt.settings)
+			api.SetHandler(tt.handler)
+
+			err := p.VerifyUpstream(t.Context())
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("VerifyUpstream() error = %v", err)
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyUpstream() error = %v, want %v", err, tt.wantErr)
+			}
+			if requests := api.Requests(); len(requests) != 1 || requests[0].URL.Path != tt.wantPath {
+				t.Errorf("API server received %d requests, want one for %s", len(requests), tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestVerifyUnreachableUpstream(t *testing.T) {
+	p, _, api := newTestProxy(t, nil)
+	api.Close()
+
+	err := p.VerifyUpstream(t.Context())
+	if err == nil || errors.Is(err, ErrUnexpectedUpstream) {
+		t.Errorf("VerifyUpstream() error = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// serverVersionTTL is how long the version of the API server is cached.
+	serverVersionTTL = 10 * time.Minute
+
+	// maxVersionSkew is the number of minor versions kubectl supports beyond the API
+	// server's in either direction.
+	maxVersionSkew = 1
+)
+
+// versionSkewChecker logs a warning if users run a kubectl whose version is too far off
+// the API server's, helping operators to spot clients that need an upgrade. Every
+// version of a user is only reported once, and checked at most once per TTL otherwise.
+type versionSkewChecker struct {
+	client *http.Client
+	url    string
+
+	// fetches shares a fetch of the server version among concurrent checks, so that a
+	// slow API server never holds up the cache or requests.
+	fetches singleflight.Group
+
+	mu      sync.Mutex
+	server  *utilversion.Version
+	expires time.Time
+
+	checkedMu sync.Mutex
+	checked   *lruMap[string, skewCheck]
+}
+
+// skewCheck is the result of checking a kubectl version of a user.
+type skewCheck struct {
+	warned  bool
+	expires time.Time
+}
+
+// newVersionSkewChecker creates the checker for the target, or returns nil if it is
+// disabled. It uses a dedicated transport like the upstream pinger.
+func newVersionSkewChecker(settings *viper.Viper, config *rest.Config, target *url.URL, endpoints *endpointDiscovery) (*versionSkewChecker, error) {
+	if !settings.GetBool("check_version_skew") {
+		return nil, nil
+	}
+
+	transport, err := newTransport(settings, config, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return &versionSkewChecker{
+		client:  &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		url:     target.JoinPath("version").String(),
+		checked: newLRUMap[string, skewCheck](settings.GetInt("max_tracked_users")),
+	}, nil
+}
+
+// check compares the kubectl version of the request with the API server's. Requests
+// of other clients are ignored, as their user agent doesn't tell the version of the
+// Kubernetes client libraries they use.
+func (c *versionSkewChecker) check(req *http.Request, id *identity) {
+	if c == nil {
+		return
+	}
+
+	client := kubectlVersion(req.UserAgent())
+	if client == nil {
+		return
+	}
+
+	// Claim the check before it runs, so that requests arriving meanwhile, or while the
+	// API server is unreachable, don't start checks of their own.
+	login, ip := id.LoginName(), req.RemoteAddr
+	key := login + " " + client.String()
+	now := time.Now()
+	c.checkedMu.Lock()
+	entry, ok := c.checked.get(key)
+	if ok && (entry.warned || now.Before(entry.expires)) {
+		c.checkedMu.Unlock()
+		return
+	}
+	c.checked.put(key, skewCheck{expires: now.Add(serverVersionTTL)})
+	c.checkedMu.Unlock()
+
+	// The server version is fetched in the background to not delay the request.
+	go func() {
+		server, err := c.serverVersion()
+		if err != nil {
+			log.Printf("Warning: failed to get the version of the Kubernetes API: %v", err)
+			return
+		}
+
+		skew := int(client.Minor()) - int(server.Minor())
+		if client.Major() == server.Major() && skew >= -maxVersionSkew && skew <= maxVersionSkew {
+			return
+		}
+
+		c.checkedMu.Lock()
+		c.checked.put(key, skewCheck{warned: true})
+		c.checkedMu.Unlock()
+		log.Printf("Warning: kubectl %s is outside of the supported version skew of the API server %s user=%s ip=%s",
+			client, server, login, ip)
+	}()
+}
+
+// serverVersion returns the cached version of the API server, fetching it if needed.
+func (c *versionSkewChecker) serverVersion() (*utilversion.Version, error) {
+	c.mu.Lock()
+	server, expires := c.server, c.expires
+	c.mu.Unlock()
+	if server != nil && time.Now().Before(expires) {
+		return server, nil
+	}
+
+	result, err, _ := c.fetches.Do("version", func() (any, error) {
+		server, err := c.fetchVersion()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.server, c.expires = server, time.Now().Add(serverVersionTTL)
+		c.mu.Unlock()
+		return server, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*utilversion.Version), nil
+}
+
+// fetchVersion requests the version of the API server.
+func (c *versionSkewChecker) fetchVersion() (*utilversion.Version, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var info version.Info
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode version: %w", err)
+	}
+	return utilversion.ParseGeneric(info.GitVersion)
+}
+
+// kubectlVersion returns the version in a user agent of kubectl like
+// 'kubectl/v1.30.1 (linux/amd64) kubernetes/6911225', or nil for other clients.
+func kubectlVersion(userAgent string) *utilversion.Version {
+	product, _, _ := strings.Cut(userAgent, " ")
+	name, raw, ok := strings.Cut(product, "/")
+	if !ok || name != "kubectl" {
+		return nil
+	}
+	v, err := utilversion.ParseGeneric(raw)
+	if err != nil {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKubectlVersion(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"kubectl/v1.30.1 (linux/amd64) kubernetes/6911225", "1.30.1"},
+		{"kubectl/v1.29.0", "1.29.0"},
+		{"k9s/v0.32.4 (darwin/arm64)", ""},
+		{"Go-http-client/1.1", ""},
+		{"kubectl/unknown", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if v := kubectlVersion(tt.userAgent); v != nil {
+			got = v.String()
+		}
+		if got != tt.want {
+			t.Errorf("kubectlVersion(%q) = %q, want %q", tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestVersionSkewChecksOncePerVersion(t *testing.T) {
+	_, srv, api := newTestProxy(t, map[string]any{"check_version_skew": true})
+
+	var fetches atomic.Int32
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			fetches.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"gitVersion":"v1.30.2"}`))
+		}
+	}))
+
+	for range 20 {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods", nil)
+		req.Header.Set("User-Agent", "kubectl/v1.30.1 (linux/amd64) kubernetes/6911225")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("version fetches = %d, want 1", got)
+	}
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// logWatchEnd logs whether a watch was ended by the client, the API server or the proxy
+// at the end of the connection's lifetime, which helps telling apart informers that
+// reconnect regularly from dropped connections.
+// Events of watches are flushed to the client immediately, as httputil.ReverseProxy
+// flushes responses of unknown length after every write.
+func logWatchEnd(req *http.Request, id *identity, start time.Time) {
+	closedBy := "API server"
+	if err := req.Context().Err(); errors.Is(err, context.DeadlineExceeded) {
+		closedBy = "proxy"
+	} else if err != nil {
+		closedBy = "client"
+	}
+	log.Printf("%s %s watch closed by %s after %s user=%s ip=%s", req.Method, req.URL.Path, closedBy,
+		time.Since(start).Round(time.Second), id.LoginName(), req.RemoteAddr)
+}
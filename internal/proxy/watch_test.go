@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamEvents serves a watch that sends the events, flushing after each one, and then
+// stays open until the client disconnects.
+func streamEvents(events ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for _, event := range events {
+			_, _ = w.Write([]byte(event + "\n"))
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	})
+}
+
+// readEvent reads the next event of the watch, failing the test if it doesn't arrive
+// within a second.
+func readEvent(t *testing.T, events *bufio.Scanner) map[string]any {
+	t.Helper()
+
+	read := make(chan bool, 1)
+	go func() { read <- events.Scan() }()
+	select {
+	case ok := <-read:
+		if !ok {
+			t.Fatalf("watch ended before the event: %v", events.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event was not streamed within a second")
+	}
+
+	var event map[string]any
+	if err := json.Unmarshal(events.Bytes(), &event); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	return event
+}
+
+func TestListQueryIsForwardedVerbatim(t *testing.T) {
+	_, srv, _ := newTestProxy(t, nil)
+
+	query := url.Values{
+		"limit":                {"500"},
+		"continue":             {"eyJ2IjoibWV0YS5rOHMuaW8vdjEiLCJydiI6MTIzNDUsInN0YXJ0IjoicG9kLTEvIn0="},
+		"allowWatchBookmarks":  {"true"},
+		"resourceVersion":      {"12345"},
+		"resourceVersionMatch": {"NotOlderThan"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/pods?"+query.Encode(), nil)
+	echo := doEcho(t, req)
+
+	for key, want := range query {
+		if got := echo.Query.Get(key); got != want[0] {
+			t.Errorf("query %s = %q, want %q", key, got, want[0])
+		}
+	}
+	if len(echo.Query) != len(query) {
+		t.Errorf("query = %v, want %v", echo.Query, query)
+	}
+}
+
+func TestWatchBookmarksAreStreamed(t *testing.T) {
+	_, srv, api := newTestProxy(t, nil)
+	api.SetHandler(streamEvents(
+		`{"type":"ADDED","object":{"kind":"Pod","metadata":{"name":"pod-1","resourceVersion":"100"}}}`,
+		`{"type":"BOOKMARK","object":{"kind":"Pod","metadata":{"resourceVersion":"200"}}}`,
+	))
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods?watch=true&allowWatchBookmarks=true")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	events := bufio.NewScanner(resp.Body)
+	for _, want := range []string{"ADDED", "BOOKMARK"} {
+		if event := readEvent(t, events); event["type"] != want {
+			t.Errorf("event type = %v, want %s", event["type"], want)
+		}
+	}
+}
+
+// syncBuffer collects log output written concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the log output to the returned buffer until the test finishes.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+
+	buf := new(syncBuffer)
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// waitForLog waits up to a second for the line to be logged, e.g. once the proxy's
+// handler returns after the response was sent.
+func waitForLog(t *testing.T, logs *syncBuffer, line string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), line) {
+		if time.Now().After(deadline) {
+			t.Errorf("log = %q, want %q", logs.String(), line)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIdleWatchThenEvent(t *testing.T) {
+	logs := captureLog(t)
+	_, srv, api := newTestProxy(t, nil)
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"type":"MODIFIED","object":{"kind":"Pod","metadata":{"name":"pod-1"}}}` + "\n"))
+	}))
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods?watch=true")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	events := bufio.NewScanner(resp.Body)
+	if event := readEvent(t, events); event["type"] != "MODIFIED" {
+		t.Errorf("event type = %v, want MODIFIED", event["type"])
+	}
+	if events.Scan() {
+		t.Errorf("unexpected data after the watch was closed: %q", events.Text())
+	}
+
+	waitForLog(t, logs, "watch closed by API server")
+}
+
+func TestWatchClosedByClient(t *testing.T) {
+	logs := captureLog(t)
+	_, srv, api := newTestProxy(t, nil)
+	api.SetHandler(streamEvents(`{"type":"ADDED","object":{"kind":"Pod","metadata":{"name":"pod-1"}}}`))
+
+	resp, err := http.Get(srv.URL + "/api/v1/pods?watch=true")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	readEvent(t, bufio.NewScanner(resp.Body))
+	resp.Body.Close()
+
+	waitForLog(t, logs, "watch closed by client")
+}
@@ -0,0 +1,89 @@
+package tailscale
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// keepAliveConn is implemented by connections that expose TCP keep-alive options.
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// keepAliveListener enables TCP keep-alives on accepted connections, so that dead
+// clients are detected. Connections of the userspace network stack don't expose any
+// socket options, in which case detecting dead peers is left to Tailscale itself.
+type keepAliveListener struct {
+	net.Listener
+	period      time.Duration
+	unsupported sync.Once
+}
+
+// Accept waits for the next connection and enables keep-alives on it if possible.
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	kc, ok := conn.(keepAliveConn)
+	if !ok {
+		l.unsupported.Do(func() {
+			log.Printf("Warning: TCP keep-alive is not supported by connections of type %T", conn)
+		})
+		return conn, nil
+	}
+
+	if err = kc.SetKeepAlive(true); err == nil {
+		err = kc.SetKeepAlivePeriod(l.period)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to enable TCP keep-alive for %s: %v", conn.RemoteAddr(), err)
+	}
+
+	return conn, nil
+}
+
+// limitListener accepts at most a fixed number of concurrent connections. Unlike
+// netutil.LimitListener, connections over the limit are closed right away instead of
+// being left waiting in the backlog, so that a single peer can't exhaust the proxy.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+// Accept waits for the next connection and closes it if the limit is reached.
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+		default:
+			connsRejected.Inc()
+			log.Printf("Warning: rejected connection from %s, limit of %d connections reached", conn.RemoteAddr(), cap(l.sem))
+			_ = conn.Close()
+		}
+	}
+}
+
+// limitConn frees its slot of the limitListener once closed.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+// Close closes the connection and frees its slot.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
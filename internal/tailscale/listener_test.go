@@ -0,0 +1,90 @@
+package tailscale
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimitListener(t *testing.T) {
+	buf := captureLog(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ln := &limitListener{Listener: inner, sem: make(chan struct{}, 2)}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	accept := func() net.Conn {
+		t.Helper()
+		select {
+		case conn := <-accepted:
+			return conn
+		case <-time.After(5 * time.Second):
+			t.Fatal("the connection was not accepted")
+			return nil
+		}
+	}
+	closedByServer := func(conn net.Conn) bool {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+		return err != nil && !(errors.As(err, &netErr) && netErr.Timeout())
+	}
+
+	before := promtestutil.ToFloat64(connsRejected)
+	dial()
+	first := accept()
+	dial()
+	accept()
+
+	// The third connection exceeds the limit and is closed right away.
+	if rejected := dial(); !closedByServer(rejected) {
+		t.Error("the connection over the limit was not closed")
+	}
+	if got := promtestutil.ToFloat64(connsRejected) - before; got != 1 {
+		t.Errorf("rejected connections increased by %v, want 1", got)
+	}
+
+	// Closing a connection frees its slot, also when closed twice.
+	_ = first.Close()
+	_ = first.Close()
+	dial()
+	accept()
+	if got := promtestutil.ToFloat64(connsRejected) - before; got != 1 {
+		t.Errorf("rejected connections increased by %v after freeing a slot, want 1", got)
+	}
+
+	// The log is read once the accepting goroutine is gone.
+	_ = ln.Close()
+	for range accepted {
+	}
+	if !strings.Contains(buf.String(), "limit of 2 connections reached") {
+		t.Errorf("log = %q, want a warning about the rejected connection", buf.String())
+	}
+}
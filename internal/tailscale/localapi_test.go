@@ -0,0 +1,27 @@
+package tailscale
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/local"
+)
+
+// newFakeLocalAPI serves the handler as the local API of the tsnet server and returns a
+// client of it.
+func newFakeLocalAPI(t *testing.T, handler http.Handler) *local.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &local.Client{
+		OmitAuth: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package tailscale
+
+import (
+	"fmt"
+	"log"
+)
+
+// Supported log levels for the tsnet server. At the info level only user-facing
+// messages are logged, while the debug level also includes verbose backend logs.
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// LogLevel returns the current log level of the tsnet server.
+func (s *Server) LogLevel() string {
+	if s.verbose.Load() {
+		return LogLevelDebug
+	}
+	return LogLevelInfo
+}
+
+// SetLogLevel changes the log level of the tsnet server at runtime.
+func (s *Server) SetLogLevel(level string) error {
+	switch level {
+	case LogLevelInfo:
+		s.verbose.Store(false)
+	case LogLevelDebug:
+		s.verbose.Store(true)
+	default:
+		return fmt.Errorf("invalid log level %q (expected %s or %s)", level, LogLevelInfo, LogLevelDebug)
+	}
+
+	return nil
+}
+
+// userLogf logs messages of the tsnet server intended for the user, like the login URL
+// and status changes, which are always emitted.
+func (s *Server) userLogf(format string, args ...any) {
+	log.Printf("tsnet: "+format, args...)
+}
+
+// backendLogf logs verbose messages of the tsnet backend if the debug level is enabled.
+func (s *Server) backendLogf(format string, args ...any) {
+	if s.verbose.Load() {
+		log.Printf("tsnet: "+format, args...)
+	}
+}
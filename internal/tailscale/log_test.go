@@ -0,0 +1,143 @@
+package tailscale
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+
+	"github.com/spf13/viper"
+)
+
+// captureLog redirects the standard logger to a buffer until the test finishes.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestLogRouting(t *testing.T) {
+	tests := []struct {
+		level       string
+		wantBackend bool
+	}{
+		{level: LogLevelInfo, wantBackend: false},
+		{level: LogLevelDebug, wantBackend: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			buf := captureLog(t)
+			s := &Server{}
+			if err := s.SetLogLevel(tt.level); err != nil {
+				t.Fatalf("SetLogLevel() error = %v", err)
+			}
+
+			s.userLogf("To authenticate, visit: %s", "https://login.example.com")
+			s.backendLogf("magicsock: %s", "endpoints changed")
+
+			if !strings.Contains(buf.String(), "tsnet: To authenticate, visit: https://login.example.com") {
+				t.Errorf("log = %q, want the user message", buf.String())
+			}
+			if got := strings.Contains(buf.String(), "tsnet: magicsock: endpoints changed"); got != tt.wantBackend {
+				t.Errorf("backend message logged = %t, want %t", got, tt.wantBackend)
+			}
+		})
+	}
+}
+
+func TestTsnetLogRouting(t *testing.T) {
+	tests := []struct {
+		name        string
+		settings    map[string]any
+		wantBackend bool
+	}{
+		{name: "info", settings: map[string]any{"ts.log_level": LogLevelInfo}},
+		{name: "debug", settings: map[string]any{"ts.log_level": LogLevelDebug}, wantBackend: true},
+		{name: "debug flag", settings: map[string]any{"ts.log_level": LogLevelInfo, "debug": true}, wantBackend: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			settings := viper.New()
+			settings.Set("ts.authkey", "tskey-test")
+			for key, value := range tt.settings {
+				settings.Set(key, value)
+			}
+			s, err := newServer(settings, nil)
+			if err != nil {
+				t.Fatalf("newServer() error = %v", err)
+			}
+
+			s.ts.UserLogf("To authenticate, visit: %s", "https://login.example.com")
+			s.ts.Logf("magicsock: %s", "endpoints changed")
+
+			if !strings.Contains(buf.String(), "tsnet: To authenticate, visit: https://login.example.com") {
+				t.Errorf("log = %q, want the user message", buf.String())
+			}
+			if got := strings.Contains(buf.String(), "tsnet: magicsock: endpoints changed"); got != tt.wantBackend {
+				t.Errorf("backend message logged = %t, want %t", got, tt.wantBackend)
+			}
+		})
+	}
+
+	settings := viper.New()
+	settings.Set("ts.authkey", "tskey-test")
+	settings.Set("ts.log_level", "trace")
+	if _, err := newServer(settings, nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("newServer() with an invalid log level error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
+
+func TestLogLevelChangeAtRuntime(t *testing.T) {
+	buf := captureLog(t)
+	s := &Server{}
+	if err := s.SetLogLevel(LogLevelInfo); err != nil {
+		t.Fatalf("SetLogLevel() error = %v", err)
+	}
+	handler := management.LogLevelHandler(s)
+	call := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// GET only reports the level.
+	if rec := call(http.MethodGet, "/debug/tslog?level=debug", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET with level = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := call(http.MethodGet, "/debug/tslog", nil); rec.Body.String() != "info\n" {
+		t.Errorf("GET = %q, want the info level", rec.Body.String())
+	}
+	s.backendLogf("suppressed")
+
+	if rec := call(http.MethodPost, "/debug/tslog", url.Values{"level": {"debug"}}); rec.Code != http.StatusOK || rec.Body.String() != "debug\n" {
+		t.Errorf("POST debug = %d %q, want the debug level", rec.Code, rec.Body.String())
+	}
+	s.backendLogf("emitted")
+	if rec := call(http.MethodPost, "/debug/tslog", url.Values{"level": {"trace"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST trace = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if strings.Contains(buf.String(), "suppressed") || !strings.Contains(buf.String(), "tsnet: emitted") {
+		t.Errorf("log = %q, want only the backend message after the change", buf.String())
+	}
+	if s.LogLevel() != LogLevelDebug {
+		t.Errorf("LogLevel() = %q, want %q", s.LogLevel(), LogLevelDebug)
+	}
+}
@@ -0,0 +1,58 @@
+package tailscale
+
+import (
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stateSecretSize = promauto.With(metrics.Registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "state_secret_bytes",
+		Help:      "Size of the Tailscale state stored in the Kubernetes secret.",
+	})
+
+	upDuration = promauto.With(metrics.Registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tailscale_up_duration_seconds",
+		Help:      "Time it took to connect the Tailscale node to the tailnet on startup.",
+	})
+
+	peersTotal = promauto.With(metrics.Registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tailscale_peers",
+		Help:      "Number of peers of the Tailscale node.",
+	})
+
+	peersOnline = promauto.With(metrics.Registerer).NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tailscale_peers_online",
+		Help:      "Number of peers of the Tailscale node that are online.",
+	})
+
+	peersConnection = promauto.With(metrics.Registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tailscale_peers_connected",
+		Help:      "Number of online peers with an active connection by type, either direct or through a DERP relay.",
+	}, []string{"type"})
+
+	stateWriteRejected = promauto.With(metrics.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "state_write_rejected_total",
+		Help:      "Number of Tailscale state writes rejected for exceeding the Kubernetes secret size limit.",
+	})
+
+	connsRejected = promauto.With(metrics.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "connections_rejected_total",
+		Help:      "Number of client connections closed for exceeding the connection limit.",
+	})
+
+	localRetries = promauto.With(metrics.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "tailscale_local_api_retries_total",
+		Help:      "Number of retried calls to the Tailscale local API after transient errors.",
+	})
+)
@@ -0,0 +1,71 @@
+package tailscale
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestStateSizeWarning(t *testing.T) {
+	buf := captureLog(t)
+	store := &KubernetesStore{
+		state:    map[ipn.StateKey][]byte{"_machinekey": make([]byte, 89)},
+		secret:   "tailscale-state",
+		warnSize: 200,
+	}
+	warnings := func() int {
+		return strings.Count(buf.String(), "Warning: Tailscale state in secret tailscale-state")
+	}
+
+	store.updateSize()
+	if got := promtestutil.ToFloat64(stateSecretSize); got != 100 {
+		t.Errorf("state size = %v, want 100", got)
+	}
+	if warnings() != 0 {
+		t.Errorf("log = %q, want no warning below the threshold", buf.String())
+	}
+
+	// The warning is logged once when crossing the threshold, not on every write.
+	for range 2 {
+		store.state["profile-1"] = make([]byte, 141)
+		store.updateSize()
+	}
+	if got := promtestutil.ToFloat64(stateSecretSize); got != 250 {
+		t.Errorf("state size = %v, want 250", got)
+	}
+	if got := warnings(); got != 1 {
+		t.Errorf("logged %d warnings, want 1", got)
+	}
+
+	// Shrinking below the threshold rearms the warning.
+	delete(store.state, "profile-1")
+	store.updateSize()
+	store.state["profile-1"] = make([]byte, 141)
+	store.updateSize()
+	if got := warnings(); got != 2 {
+		t.Errorf("logged %d warnings after crossing the threshold again, want 2", got)
+	}
+}
+
+func TestUpDuration(t *testing.T) {
+	upDuration.Set(0)
+	s := &Server{
+		authKeys: []string{"tskey-test"},
+		up: func(context.Context) (*ipnstate.Status, error) {
+			time.Sleep(20 * time.Millisecond)
+			return &ipnstate.Status{TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")}}, nil
+		},
+	}
+	if err := s.Up(t.Context()); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if got := promtestutil.ToFloat64(upDuration); got < 0.02 || got > 5 {
+		t.Errorf("up duration = %vs, want the time it took to connect", got)
+	}
+}
@@ -0,0 +1,59 @@
+package tailscale
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// peerMetricsInterval is the interval at which the peer metrics are updated.
+const peerMetricsInterval = 30 * time.Second
+
+// ReportPeerMetrics periodically updates the metrics about the peers of the node until
+// the context is canceled. They help correlating latency spikes of the proxy with
+// connectivity issues, e.g. if peers are only reachable through DERP relays.
+func (s *Server) ReportPeerMetrics(ctx context.Context) {
+	ticker := time.NewTicker(peerMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := s.client.Status(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: failed to get Tailscale status for metrics: %v", err)
+		} else if err == nil {
+			updatePeerMetrics(status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updatePeerMetrics sets the peer gauges from the status.
+func updatePeerMetrics(status *ipnstate.Status) {
+	var online, direct, relayed int
+	for _, peer := range status.Peer {
+		if !peer.Online {
+			continue
+		}
+		online++
+
+		// Peers with an active connection either have a direct address or go through DERP.
+		switch {
+		case peer.CurAddr != "":
+			direct++
+		case peer.Relay != "" && peer.Active:
+			relayed++
+		}
+	}
+
+	peersTotal.Set(float64(len(status.Peer)))
+	peersOnline.Set(float64(online))
+	peersConnection.WithLabelValues("direct").Set(float64(direct))
+	peersConnection.WithLabelValues("relay").Set(float64(relayed))
+}
@@ -0,0 +1,41 @@
+package tailscale
+
+import (
+	"testing"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestUpdatePeerMetrics(t *testing.T) {
+	status := &ipnstate.Status{Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+		key.NewNode().Public(): {Online: true, Active: true, CurAddr: "192.0.2.1:41641"},
+		key.NewNode().Public(): {Online: true, Active: true, Relay: "fra"},
+		key.NewNode().Public(): {Online: true, Relay: "fra"},
+		key.NewNode().Public(): {Relay: "fra", Active: true},
+	}}
+
+	updatePeerMetrics(status)
+	want := map[string]float64{"total": 4, "online": 3, "direct": 1, "relay": 1}
+	got := map[string]float64{
+		"total":  promtestutil.ToFloat64(peersTotal),
+		"online": promtestutil.ToFloat64(peersOnline),
+		"direct": promtestutil.ToFloat64(peersConnection.WithLabelValues("direct")),
+		"relay":  promtestutil.ToFloat64(peersConnection.WithLabelValues("relay")),
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s peers = %v, want %v", name, got[name], value)
+		}
+	}
+
+	// Peers that went away are no longer counted.
+	updatePeerMetrics(&ipnstate.Status{})
+	if got := promtestutil.ToFloat64(peersTotal); got != 0 {
+		t.Errorf("total peers = %v, want 0", got)
+	}
+	if got := promtestutil.ToFloat64(peersConnection.WithLabelValues("direct")); got != 0 {
+		t.Errorf("direct peers = %v, want 0", got)
+	}
+}
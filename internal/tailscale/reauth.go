@@ -0,0 +1,63 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// reauthDelay is the time to wait before re-authenticating the node.
+const reauthDelay = 5 * time.Second
+
+// AutoReauth watches the state of the node and re-authenticates it with the configured
+// auth key whenever it needs to log in again, e.g. after its node key expired. This
+// only succeeds with reusable auth keys. It returns an error once the node still
+// needs to log in after the given number of consecutive attempts.
+func (s *Server) AutoReauth(ctx context.Context, maxAttempts int) error {
+	watcher, err := s.client.WatchIPNBus(ctx, ipn.NotifyInitialState)
+	if err != nil {
+		return fmt.Errorf("failed to watch Tailscale state: %w", err)
+	}
+	defer watcher.Close()
+
+	attempts := 0
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to watch Tailscale state: %w", err)
+		}
+		if n.State == nil {
+			continue
+		}
+
+		switch *n.State {
+		case ipn.Running:
+			if attempts > 0 {
+				log.Printf("Tailscale node re-authenticated after %d attempt(s)", attempts)
+			}
+			attempts = 0
+		case ipn.NeedsLogin:
+			if attempts >= maxAttempts {
+				return fmt.Errorf("node still needs login after %d re-authentication attempts", attempts)
+			}
+			attempts++
+
+			log.Printf("Tailscale node needs login, re-authenticating (attempt %d/%d)...", attempts, maxAttempts)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(reauthDelay):
+			}
+
+			if err = s.client.Start(ctx, ipn.Options{AuthKey: s.authKey}); err != nil {
+				log.Printf("Warning: failed to re-authenticate Tailscale node: %v", err)
+			}
+		}
+	}
+}
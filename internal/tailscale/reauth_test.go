@@ -0,0 +1,85 @@
+package tailscale
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+func TestAutoReauth(t *testing.T) {
+	logs := captureLog(t)
+
+	// The node needs to log in again after the first successful re-authentication, and
+	// the second one doesn't help.
+	states := make(chan ipn.State, 4)
+	states <- ipn.NeedsLogin
+	var (
+		mu      sync.Mutex
+		started []string
+	)
+	client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/watch-ipn-bus":
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case state := <-states:
+					_ = enc.Encode(ipn.Notify{State: &state})
+					w.(http.Flusher).Flush()
+				}
+			}
+		case "/localapi/v0/start":
+			var opts ipn.Options
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			started = append(started, opts.AuthKey)
+			if len(started) == 1 {
+				states <- ipn.Running
+			}
+			mu.Unlock()
+			states <- ipn.NeedsLogin
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	s := &Server{client: client, authKey: "tskey-reusable"}
+
+	done := make(chan error, 1)
+	go func() { done <- s.AutoReauth(t.Context(), 1) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(2*reauthDelay + 5*time.Second):
+		t.Fatal("AutoReauth() did not give up")
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "after 1 re-authentication attempts") {
+		t.Errorf("AutoReauth() error = %v, want to give up after one attempt", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"tskey-reusable", "tskey-reusable"}; !slices.Equal(started, want) {
+		t.Errorf("started with %v, want %v", started, want)
+	}
+	for _, line := range []string{
+		"Tailscale node needs login, re-authenticating (attempt 1/1)...",
+		"Tailscale node re-authenticated after 1 attempt(s)",
+	} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("log = %q, want %q", logs.String(), line)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tailscale.com/client/local"
+)
+
+// localRetryDelay is the delay before the first retry of a local API call, which is
+// doubled for every further attempt.
+const localRetryDelay = 50 * time.Millisecond
+
+// retryLocal calls fn up to the configured number of attempts while it fails with a
+// transient error, so that a hiccup of the local API doesn't fail the identification
+// of requests or health checks right away.
+func retryLocal[T any](ctx context.Context, attempts int, fn func(context.Context) (T, error)) (T, error) {
+	delay := localRetryDelay
+	for attempt := 1; ; attempt++ {
+		result, err := fn(ctx)
+		if err == nil || attempt >= attempts || !isTransientLocalError(err) {
+			return result, err
+		}
+
+		localRetries.Inc()
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientLocalError returns false for errors that a retry wouldn't resolve, like
+// unknown peers or denied access.
+func isTransientLocalError(err error) bool {
+	return !errors.Is(err, local.ErrPeerNotFound) &&
+		!local.IsAccessDeniedError(err) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,78 @@
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryLocal(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		recoverAt   int32
+		wantErr     bool
+		wantCalls   int32
+		wantRetries float64
+	}{
+		{name: "transient failure recovers", status: http.StatusInternalServerError, recoverAt: 2, wantCalls: 2, wantRetries: 1},
+		{name: "transient failure persists", status: http.StatusInternalServerError, wantErr: true, wantCalls: 3, wantRetries: 2},
+		{name: "unknown peer", status: http.StatusNotFound, wantErr: true, wantCalls: 1},
+		{name: "access denied", status: http.StatusForbidden, wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := calls.Add(1); n == tt.recoverAt {
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"UserProfile":{"ID":1,"LoginName":"alice@example.com"}}`))
+					return
+				}
+				http.Error(w, http.StatusText(tt.status), tt.status)
+			}))
+			s := &Server{client: client, localAttempts: 3}
+			retries := promtestutil.ToFloat64(localRetries)
+
+			profile, err := s.WhoIs(context.Background(), "100.64.0.3:51234")
+			if tt.wantErr != (err != nil) {
+				t.Errorf("WhoIs() = %v, %v, want error %t", profile, err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("local API was called %d times, want %d", got, tt.wantCalls)
+			}
+			if got := promtestutil.ToFloat64(localRetries) - retries; got != tt.wantRetries {
+				t.Errorf("retries increased by %v, want %v", got, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetryLocalCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := time.Now()
+	_, err := retryLocal(ctx, 5, func(context.Context) (any, error) {
+		calls++
+		cancel()
+		return nil, errors.New("connection refused")
+	})
+
+	// The wait for the next attempt is aborted once the context is canceled.
+	if err == nil || calls != 1 {
+		t.Errorf("retryLocal() = %v after %d calls, want the error of the first call", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed >= localRetryDelay {
+		t.Errorf("retryLocal() took %s, want to return without waiting", elapsed)
+	}
+
+	// Errors of canceled calls are not transient.
+	if isTransientLocalError(context.Canceled) || isTransientLocalError(context.DeadlineExceeded) {
+		t.Error("context errors are transient, want them to be returned at once")
+	}
+}
@@ -0,0 +1,59 @@
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// SelfInfo describes the proxy node as seen by the control plane. Keys are omitted.
+type SelfInfo struct {
+	ID           tailcfg.StableNodeID `json:"id"`
+	HostName     string               `json:"hostName"`
+	DNSName      string               `json:"dnsName"`
+	TailscaleIPs []netip.Addr         `json:"tailscaleIPs"`
+	Tailnet      string               `json:"tailnet,omitempty"`
+	User         string               `json:"user,omitempty"`
+	Tags         []string             `json:"tags,omitempty"`
+	KeyExpiry    *time.Time           `json:"keyExpiry,omitempty"`
+
+	// Capabilities are the node capabilities granted by the tailnet policy.
+	Capabilities tailcfg.NodeCapMap `json:"capabilities,omitempty"`
+}
+
+// Self returns the identity, tags and capabilities of the proxy node, which determine
+// its position in the tailnet policy and thereby which peers can reach it.
+func (s *Server) Self(ctx context.Context) (*SelfInfo, error) {
+	status, err := s.client.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	if status.Self == nil {
+		return nil, errors.New("node is not logged in")
+	}
+
+	self := status.Self
+	info := &SelfInfo{
+		ID:           self.ID,
+		HostName:     self.HostName,
+		DNSName:      self.DNSName,
+		TailscaleIPs: self.TailscaleIPs,
+		KeyExpiry:    self.KeyExpiry,
+		Capabilities: self.CapMap,
+	}
+	if status.CurrentTailnet != nil {
+		info.Tailnet = status.CurrentTailnet.Name
+	}
+	if self.Tags != nil {
+		info.Tags = self.Tags.AsSlice()
+	}
+	// Tagged nodes are owned by the tags instead of a user.
+	if profile, ok := status.User[self.UserID]; ok && info.Tags == nil {
+		info.User = profile.LoginName
+	}
+	return info, nil
+}
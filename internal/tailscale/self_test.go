@@ -0,0 +1,110 @@
+package tailscale
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+func TestSelfEndpoint(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	tags := views.SliceOf([]string{"tag:k8s-proxy"})
+	self := func(tags *views.Slice[string]) *ipnstate.PeerStatus {
+		return &ipnstate.PeerStatus{
+			ID:           "nKzS7Ab8CNTRL",
+			PublicKey:    nodeKey,
+			HostName:     "kube-proxy",
+			DNSName:      "kube-proxy.tail1234.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+			UserID:       42,
+			Tags:         tags,
+			CapMap:       tailcfg.NodeCapMap{"https://tailscale.com/cap/funnel": nil},
+		}
+	}
+	users := map[tailcfg.UserID]tailcfg.UserProfile{42: {LoginName: "ops@example.com"}}
+
+	tests := []struct {
+		name     string
+		status   ipnstate.Status
+		wantCode int
+		want     SelfInfo
+	}{
+		{
+			name:     "tagged",
+			status:   ipnstate.Status{Self: self(&tags), User: users, CurrentTailnet: &ipnstate.TailnetStatus{Name: "example.com"}},
+			wantCode: http.StatusOK,
+			want: SelfInfo{
+				ID:       "nKzS7Ab8CNTRL",
+				HostName: "kube-proxy",
+				DNSName:  "kube-proxy.tail1234.ts.net.",
+				Tailnet:  "example.com",
+				Tags:     []string{"tag:k8s-proxy"},
+			},
+		},
+		{
+			name:     "owned by a user",
+			status:   ipnstate.Status{Self: self(nil), User: users},
+			wantCode: http.StatusOK,
+			want: SelfInfo{
+				ID:       "nKzS7Ab8CNTRL",
+				HostName: "kube-proxy",
+				DNSName:  "kube-proxy.tail1234.ts.net.",
+				User:     "ops@example.com",
+			},
+		},
+		{
+			name:     "not logged in",
+			status:   ipnstate.Status{BackendState: "NeedsLogin"},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/localapi/v0/status" {
+					http.NotFound(w, r)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(tt.status)
+			}))
+			s := &Server{client: client}
+
+			rec := httptest.NewRecorder()
+			management.JSONErrorHandler(s.Self).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/whoami-self", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			if strings.Contains(rec.Body.String(), nodeKey.String()) {
+				t.Errorf("response = %s, want the node key to be omitted", rec.Body.String())
+			}
+			var got SelfInfo
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.ID != tt.want.ID || got.HostName != tt.want.HostName || got.DNSName != tt.want.DNSName ||
+				got.Tailnet != tt.want.Tailnet || got.User != tt.want.User || strings.Join(got.Tags, ",") != strings.Join(tt.want.Tags, ",") {
+				t.Errorf("self = %+v, want %+v", got, tt.want)
+			}
+			if len(got.TailscaleIPs) != 1 || got.TailscaleIPs[0] != netip.MustParseAddr("100.64.0.1") {
+				t.Errorf("Tailscale IPs = %v, want 100.64.0.1", got.TailscaleIPs)
+			}
+			if _, ok := got.Capabilities["https://tailscale.com/cap/funnel"]; !ok {
+				t.Errorf("capabilities = %v, want the funnel capability", got.Capabilities)
+			}
+		})
+	}
+}
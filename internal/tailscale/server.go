@@ -2,46 +2,77 @@ package tailscale
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/spf13/viper"
 	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
-	"tailscale.com/types/logger"
+)
+
+// ErrInvalidConfig is returned if the server is created with an invalid configuration.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrUnexpectedTailnet is returned if the node joined another tailnet than expected.
+var ErrUnexpectedTailnet = errors.New("joined unexpected tailnet")
+
+// Supported address families of the listener.
+const (
+	AddressFamilyBoth = "both"
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
 )
 
 // Server represents a Tailscale tsnet server instance.
 type Server struct {
-	ts     *tsnet.Server
-	client *local.Client
-	ln     net.Listener
+	ts      *tsnet.Server
+	client  *local.Client
+	ln      net.Listener
+	verbose atomic.Bool
+	authKey string
+
+	// family restricts the Tailscale IPs the server listens on.
+	family string
+
+	// authKeys are the candidate auth keys, tried in order until login succeeds.
+	authKeys []string
+
+	// localAttempts is the number of attempts of local API calls with transient errors.
+	localAttempts int
+
+	// expectedTailnet is the tailnet the node must join, any if empty.
+	expectedTailnet string
+
+	// up brings the node up and returns its status, which is the Up of the tsnet server.
+	up func(ctx context.Context) (*ipnstate.Status, error)
 }
 
 // NewServer initializes and starts a new tsnet server using the provided Kubernetes store.
 func NewServer(store ipn.StateStore) (*Server, error) {
-	server := new(Server)
-
-	// Check if authkey is set
-	if viper.GetString("ts.authkey") == "" {
-		return nil, fmt.Errorf("authkey is required")
-	}
+	return NewServerWithSettings(viper.GetViper(), store)
+}
 
-	// Create a new tsnet server
-	server.ts = &tsnet.Server{
-		Hostname:   viper.GetString("ts.hostname"),
-		AuthKey:    viper.GetString("ts.authkey"),
-		ControlURL: viper.GetString("ts.control_url"),
-		Ephemeral:  viper.GetBool("ts.ephemeral"),
-		Store:      store,
+// NewServerWithSettings creates a server like NewServer, but configured by the given
+// settings instead of the global configuration.
+func NewServerWithSettings(settings *viper.Viper, store ipn.StateStore) (*Server, error) {
+	server, err := newServer(settings, store)
+	if err != nil {
+		return nil, err
 	}
 
-	// Enable logging if debug flag is set
-	if viper.GetBool("debug") {
-		server.ts.Logf = logger.WithPrefix(log.Printf, "tsnet")
+	network, err := listenNetwork(server.family)
+	if err != nil {
+		return nil, err
 	}
 
 	// Start the tsnet server
@@ -50,7 +81,6 @@ func NewServer(store ipn.StateStore) (*Server, error) {
 	}
 
 	// Create a local client
-	var err error
 	server.client, err = server.ts.LocalClient()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create local client: %w", err)
@@ -58,14 +88,153 @@ func NewServer(store ipn.StateStore) (*Server, error) {
 
 	// We listen on port 80 to provide a standard entry point for internal proxying
 	// within the Tailscale network, regardless of the actual target service port.
-	server.ln, err = server.ts.Listen("tcp", ":80")
+	server.ln, err = server.ts.Listen(network, ":80")
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on port 80: %w", err)
 	}
 
+	// Detect dead clients with TCP keep-alives if configured.
+	if period := settings.GetDuration("tcp_keepalive"); period > 0 {
+		server.ln = &keepAliveListener{Listener: server.ln, period: period}
+	}
+
+	// Reject connections over the limit to protect against connection exhaustion.
+	if maxConns := settings.GetInt("max_conns"); maxConns > 0 {
+		server.ln = &limitListener{Listener: server.ln, sem: make(chan struct{}, maxConns)}
+	}
+
+	return server, nil
+}
+
+// newServer configures the server and its tsnet server without starting it.
+func newServer(settings *viper.Viper, store ipn.StateStore) (*Server, error) {
+	server := &Server{
+		family:          settings.GetString("ts.address_family"),
+		localAttempts:   settings.GetInt("ts.local_api_attempts"),
+		expectedTailnet: settings.GetString("ts.expected_tailnet"),
+	}
+
+	// Collect the auth keys, multiple keys allow rotating them without downtime
+	for _, key := range append([]string{settings.GetString("ts.authkey")}, settings.GetStringSlice("ts.authkeys")...) {
+		if key != "" {
+			server.authKeys = append(server.authKeys, key)
+		}
+	}
+	if len(server.authKeys) == 0 {
+		return nil, fmt.Errorf("%w: authkey is required", ErrInvalidConfig)
+	}
+
+	// Create a new tsnet server
+	server.authKey = server.authKeys[0]
+	server.ts = &tsnet.Server{
+		Hostname:   settings.GetString("ts.hostname"),
+		AuthKey:    server.authKey,
+		ControlURL: settings.GetString("ts.control_url"),
+		Ephemeral:  settings.GetBool("ts.ephemeral"),
+		Dir:        settings.GetString("ts.dir"),
+		Store:      store,
+	}
+	server.up = server.ts.Up
+
+	// Route tsnet logs through our logger. User-facing messages are logged at the info
+	// level, while the verbose backend logs are only emitted at the debug level.
+	level := settings.GetString("ts.log_level")
+	if settings.GetBool("debug") {
+		level = LogLevelDebug
+	}
+	if err := server.SetLogLevel(level); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	server.ts.UserLogf = server.userLogf
+	server.ts.Logf = server.backendLogf
+
 	return server, nil
 }
 
+// Up blocks until the tsnet server is connected to the Tailscale network. If the login
+// fails, it is retried with the next auth key, which is then used for re-authentication.
+func (s *Server) Up(ctx context.Context) error {
+	start := time.Now()
+	for i, key := range s.authKeys {
+		if i > 0 {
+			log.Printf("Retrying Tailscale login with auth key #%d...", i+1)
+			if err := s.client.Start(ctx, ipn.Options{AuthKey: key}); err != nil {
+				return fmt.Errorf("failed to restart tsnet server: %w", err)
+			}
+		}
+
+		status, err := s.up(ctx)
+		if err == nil {
+			if err = verifyTailnet(status, s.expectedTailnet); err != nil {
+				return err
+			}
+			log.Printf("Tailscale node is up, listening on %s", s.listenAddrs(status.TailscaleIPs))
+			if len(s.authKeys) > 1 {
+				log.Printf("Tailscale node logged in with auth key #%d", i+1)
+			}
+			s.authKey = key
+			upDuration.Set(time.Since(start).Seconds())
+			return nil
+		}
+		if ctx.Err() != nil || i == len(s.authKeys)-1 {
+			return fmt.Errorf("failed to bring up tsnet server: %w", err)
+		}
+		log.Printf("Warning: Tailscale login with auth key #%d failed: %v", i+1, err)
+	}
+	return nil
+}
+
+// verifyTailnet returns an error if the node is not part of the expected tailnet, given
+// by its name or MagicDNS suffix, e.g. because a leaked auth key of another tailnet was
+// configured. Any tailnet is accepted if none is expected.
+func verifyTailnet(status *ipnstate.Status, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	// Headscale doesn't report the tailnet in all versions, so the suffix of the node's
+	// DNS name is compared instead, which doesn't necessarily end in ts.net.
+	tailnet := status.CurrentTailnet
+	if tailnet == nil && status.Self != nil {
+		_, suffix, _ := strings.Cut(strings.TrimSuffix(status.Self.DNSName, "."), ".")
+		tailnet = &ipnstate.TailnetStatus{MagicDNSSuffix: suffix}
+	}
+	if tailnet == nil || (tailnet.MagicDNSSuffix == "" && tailnet.Name == "") {
+		return fmt.Errorf("%w: tailnet is unknown, expected %s", ErrUnexpectedTailnet, expected)
+	}
+
+	if !strings.EqualFold(tailnet.Name, expected) && !strings.EqualFold(tailnet.MagicDNSSuffix, expected) {
+		return fmt.Errorf("%w: joined %s (%s), expected %s", ErrUnexpectedTailnet, tailnet.Name, tailnet.MagicDNSSuffix, expected)
+	}
+	return nil
+}
+
+// listenNetwork returns the network to listen on for the address family.
+func listenNetwork(family string) (string, error) {
+	switch family {
+	case AddressFamilyBoth:
+		return "tcp", nil
+	case AddressFamilyIPv4:
+		return "tcp4", nil
+	case AddressFamilyIPv6:
+		return "tcp6", nil
+	default:
+		return "", fmt.Errorf("%w: invalid address family %q (expected %s, %s or %s)", ErrInvalidConfig,
+			family, AddressFamilyBoth, AddressFamilyIPv4, AddressFamilyIPv6)
+	}
+}
+
+// listenAddrs returns the addresses of the Tailscale IPs the server listens on.
+func (s *Server) listenAddrs(ips []netip.Addr) string {
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if (s.family == AddressFamilyIPv4 && !ip.Is4()) || (s.family == AddressFamilyIPv6 && !ip.Is6()) {
+			continue
+		}
+		addrs = append(addrs, netip.AddrPortFrom(ip, 80).String())
+	}
+	return strings.Join(addrs, ", ")
+}
+
 // Listener returns the network listener for the tsnet server.
 func (s *Server) Listener() net.Listener {
 	return s.ln
@@ -76,26 +245,67 @@ func (s *Server) Close() error {
 	return s.ts.Close()
 }
 
-// UserProfile is a wrapper around tailcfg.UserProfile.
-type UserProfile tailcfg.UserProfile
+// UserProfile is a wrapper around tailcfg.UserProfile, extended by the ACL tags of the
+// node the user connects from.
+type UserProfile struct {
+	tailcfg.UserProfile
+
+	// Tags are the ACL tags of the node, which are only set for tagged devices.
+	Tags []string
+
+	// Capabilities are the application capabilities granted to the node by the
+	// tailnet policy.
+	Capabilities tailcfg.PeerCapMap
+}
 
 // WhoIs returns the profile of the user associated with the remote address.
 func (s *Server) WhoIs(c context.Context, remoteAddr string) (*UserProfile, error) {
-	resp, err := s.client.WhoIs(c, remoteAddr)
+	resp, err := retryLocal(c, s.localAttempts, func(ctx context.Context) (*apitype.WhoIsResponse, error) {
+		return s.client.WhoIs(ctx, remoteAddr)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return (*UserProfile)(resp.UserProfile), nil
+	// Control servers other than Tailscale's, like Headscale, may omit fields.
+	if resp.UserProfile == nil {
+		return nil, fmt.Errorf("no user profile for %s", remoteAddr)
+	}
+
+	profile := &UserProfile{UserProfile: *resp.UserProfile}
+	if resp.Node != nil {
+		profile.Tags = resp.Node.Tags
+	}
+	profile.Capabilities = resp.CapMap
+	return profile, nil
 }
 
 // IsConnected returns true if the Tailscale client is connected to the Tailscale network.
 func (s *Server) IsConnected(ctx context.Context) bool {
-	status, err := s.client.StatusWithoutPeers(ctx)
+	return s.CheckStatus(ctx) == nil
+}
 
+// CheckStatus returns an error describing why the Tailscale client is not connected to
+// the Tailscale network, or nil if it is.
+func (s *Server) CheckStatus(ctx context.Context) error {
+	state, err := s.BackendState(ctx)
 	if err != nil {
-		return false
+		return err
+	}
+
+	if state != ipn.Running.String() {
+		return fmt.Errorf("backend state is %s", state)
 	}
+	return nil
+}
 
-	return status.BackendState == "Running"
+// BackendState returns the state of the Tailscale backend, e.g. Running or NeedsLogin.
+func (s *Server) BackendState(ctx context.Context) (string, error) {
+	// Transient errors of the local API are retried, so that only a backend that is
+	// actually not running fails the check.
+	status, err := retryLocal(ctx, s.localAttempts, s.client.StatusWithoutPeers)
+	if err != nil {
+		return "", fmt.Errorf("failed to get status: %w", err)
+	}
+	return status.BackendState, nil
 }
@@ -0,0 +1,345 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/management"
+
+	"github.com/spf13/viper"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestVerifyTailnet(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   *ipnstate.Status
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:   "any tailnet",
+			status: &ipnstate.Status{CurrentTailnet: &ipnstate.TailnetStatus{Name: "other.example.com"}},
+		},
+		{
+			name:     "matching name",
+			status:   &ipnstate.Status{CurrentTailnet: &ipnstate.TailnetStatus{Name: "example.com", MagicDNSSuffix: "tail1234.ts.net"}},
+			expected: "Example.com",
+		},
+		{
+			name:     "matching suffix",
+			status:   &ipnstate.Status{CurrentTailnet: &ipnstate.TailnetStatus{Name: "example.com", MagicDNSSuffix: "tail1234.ts.net"}},
+			expected: "tail1234.ts.net",
+		},
+		{
+			name:     "DNS name fallback",
+			status:   &ipnstate.Status{Self: &ipnstate.PeerStatus{DNSName: "proxy.headscale.example.com."}},
+			expected: "headscale.example.com",
+		},
+		{
+			name:     "DNS name fallback mismatch",
+			status:   &ipnstate.Status{Self: &ipnstate.PeerStatus{DNSName: "proxy.other.example.com."}},
+			expected: "headscale.example.com",
+			wantErr:  true,
+		},
+		{
+			name:     "mismatch",
+			status:   &ipnstate.Status{CurrentTailnet: &ipnstate.TailnetStatus{Name: "attacker.example.com", MagicDNSSuffix: "tail9999.ts.net"}},
+			expected: "example.com",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown tailnet",
+			status:   &ipnstate.Status{},
+			expected: "example.com",
+			wantErr:  true,
+		},
+		{
+			name:     "empty tailnet",
+			status:   &ipnstate.Status{CurrentTailnet: &ipnstate.TailnetStatus{}},
+			expected: "example.com",
+			wantErr:  true,
+		},
+		{
+			name:     "node without domain",
+			status:   &ipnstate.Status{Self: &ipnstate.PeerStatus{DNSName: "proxy."}},
+			expected: "example.com",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyTailnet(tt.status, tt.expected)
+			if tt.wantErr != errors.Is(err, ErrUnexpectedTailnet) {
+				t.Errorf("verifyTailnet() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	ips := []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")}
+	tests := []struct {
+		family      string
+		wantNetwork string
+		wantAddrs   string
+	}{
+		{family: AddressFamilyBoth, wantNetwork: "tcp", wantAddrs: "100.64.0.1:80, [fd7a:115c:a1e0::1]:80"},
+		{family: AddressFamilyIPv4, wantNetwork: "tcp4", wantAddrs: "100.64.0.1:80"},
+		{family: AddressFamilyIPv6, wantNetwork: "tcp6", wantAddrs: "[fd7a:115c:a1e0::1]:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family, func(t *testing.T) {
+			network, err := listenNetwork(tt.family)
+			if err != nil {
+				t.Fatalf("listenNetwork() error = %v", err)
+			}
+			if network != tt.wantNetwork {
+				t.Errorf("listenNetwork() = %q, want %q", network, tt.wantNetwork)
+			}
+
+			s := &Server{family: tt.family}
+			if got := s.listenAddrs(ips); got != tt.wantAddrs {
+				t.Errorf("listenAddrs() = %q, want %q", got, tt.wantAddrs)
+			}
+		})
+	}
+
+	if _, err := listenNetwork("ipv5"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("listenNetwork(ipv5) error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestUpRetriesWithNextAuthKey(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		started []string
+	)
+	client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var opts ipn.Options
+		if r.URL.Path != "/localapi/v0/start" || json.NewDecoder(r.Body).Decode(&opts) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		started = append(started, opts.AuthKey)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name        string
+		failures    int
+		wantErr     bool
+		wantKey     string
+		wantStarted []string
+	}{
+		{name: "first key succeeds", failures: 0, wantKey: "tskey-first"},
+		{name: "second key succeeds", failures: 1, wantKey: "tskey-second", wantStarted: []string{"tskey-second"}},
+		{name: "all keys fail", failures: 2, wantErr: true, wantKey: "tskey-first", wantStarted: []string{"tskey-second"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			started = nil
+			mu.Unlock()
+			calls := 0
+			s := &Server{
+				client:   client,
+				authKeys: []string{"tskey-first", "tskey-second"},
+				authKey:  "tskey-first",
+				family:   AddressFamilyBoth,
+				up: func(context.Context) (*ipnstate.Status, error) {
+					calls++
+					if calls <= tt.failures {
+						return nil, errors.New("invalid key: unable to validate API key")
+					}
+					return &ipnstate.Status{TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")}}, nil
+				},
+			}
+
+			err := s.Up(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Up() error = %v, want error %t", err, tt.wantErr)
+			}
+			if s.authKey != tt.wantKey {
+				t.Errorf("auth key = %q, want %q", s.authKey, tt.wantKey)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(started, tt.wantStarted) {
+				t.Errorf("restarted with %v, want %v", started, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func TestTsnetDir(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{name: "default"},
+		{name: "custom", dir: t.TempDir()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := viper.New()
+			settings.Set("ts.authkey", "tskey-test")
+			settings.Set("ts.log_level", LogLevelInfo)
+			if tt.dir != "" {
+				settings.Set("ts.dir", tt.dir)
+			}
+
+			s, err := newServer(settings, new(mem.Store))
+			if err != nil {
+				t.Fatalf("newServer() error = %v", err)
+			}
+			// An empty directory makes tsnet fall back to the user config directory.
+			if s.ts.Dir != tt.dir {
+				t.Errorf("tsnet dir = %q, want %q", s.ts.Dir, tt.dir)
+			}
+		})
+	}
+}
+
+func TestHealthCheckEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "running", state: ipn.Running.String(), wantCode: http.StatusOK, wantBody: "ok\n"},
+		{name: "needs login", state: ipn.NeedsLogin.String(), wantCode: http.StatusServiceUnavailable, wantBody: "backend state is NeedsLogin\n"},
+		{name: "local API failure", wantCode: http.StatusServiceUnavailable, wantBody: "failed to get status: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/localapi/v0/status" || tt.state == "" {
+					http.Error(w, "backend unavailable", http.StatusInternalServerError)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(ipnstate.Status{BackendState: tt.state})
+			}))
+			s := &Server{client: client, localAttempts: 1}
+
+			rec := httptest.NewRecorder()
+			management.HealthHandler(s.CheckStatus).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+			if rec.Code != tt.wantCode || !strings.HasPrefix(rec.Body.String(), tt.wantBody) {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUpCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		authKeys: []string{"tskey-first", "tskey-second"},
+		authKey:  "tskey-first",
+		up: func(ctx context.Context) (*ipnstate.Status, error) {
+			cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	// The login is aborted without retrying the next auth key, which would need a client.
+	if err := s.Up(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Up() error = %v, want %v", err, context.Canceled)
+	}
+	if s.authKey != "tskey-first" {
+		t.Errorf("auth key = %q, want the first one", s.authKey)
+	}
+}
+
+func TestWhoIsHeadscale(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantErr   bool
+		wantLogin string
+		wantTags  []string
+	}{
+		{
+			name: "user without display name",
+			response: `{"Node":{"ID":3,"StableID":"3","Name":"laptop.headscale.example.com.","User":2},
+				"UserProfile":{"ID":2,"LoginName":"alice","DisplayName":""}}`,
+			wantLogin: "alice",
+		},
+		{
+			name: "tagged node",
+			response: `{"Node":{"ID":4,"Name":"ci.headscale.example.com.","Tags":["tag:ci"]},
+				"UserProfile":{"ID":2147455555,"LoginName":"tagged-devices","DisplayName":"Tagged Devices"}}`,
+			wantLogin: "tagged-devices",
+			wantTags:  []string{"tag:ci"},
+		},
+		{
+			name:      "no node",
+			response:  `{"UserProfile":{"ID":2,"LoginName":"alice"}}`,
+			wantLogin: "alice",
+		},
+		{
+			name:     "no user profile",
+			response: `{"Node":{"ID":3,"Name":"laptop.headscale.example.com."}}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeLocalAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/localapi/v0/whois" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			s := &Server{client: client, localAttempts: 1}
+
+			profile, err := s.WhoIs(context.Background(), "100.64.0.3:51234")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("WhoIs() = %+v, want an error", profile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WhoIs() error = %v", err)
+			}
+			if profile.LoginName != tt.wantLogin || !slices.Equal(profile.Tags, tt.wantTags) {
+				t.Errorf("WhoIs() = %s %v, want %s %v", profile.LoginName, profile.Tags, tt.wantLogin, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestVerifyTailnetHeadscaleStatus(t *testing.T) {
+	// Headscale doesn't report the current tailnet, only the DNS name of the node.
+	var status ipnstate.Status
+	fixture := `{"BackendState":"Running","TailscaleIPs":["100.64.0.1"],
+		"Self":{"ID":"1","HostName":"kube-proxy","DNSName":"kube-proxy.headscale.example.com.","TailscaleIPs":["100.64.0.1"]},
+		"MagicDNSSuffix":"headscale.example.com"}`
+	if err := json.Unmarshal([]byte(fixture), &status); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+
+	if err := verifyTailnet(&status, "headscale.example.com"); err != nil {
+		t.Errorf("verifyTailnet() error = %v, want the DNS suffix to match", err)
+	}
+	if err := verifyTailnet(&status, "tail1234.ts.net"); !errors.Is(err, ErrUnexpectedTailnet) {
+		t.Errorf("verifyTailnet() error = %v, want %v", err, ErrUnexpectedTailnet)
+	}
+}
@@ -4,16 +4,40 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/spf13/viper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"tailscale.com/ipn"
 )
 
+// maxSecretSize is the maximum size of a Secret accepted by the Kubernetes API server.
+const maxSecretSize = 1 << 20
+
+// ErrStateTooLarge is returned if writing the state would exceed the maximum Secret size.
+var ErrStateTooLarge = errors.New("tailscale state exceeds the Kubernetes secret size limit")
+
+// managedByLabel marks the state secret as managed by the proxy.
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// Annotations set on the state secret to make its purpose discoverable.
+const (
+	hostnameAnnotation    = "tailscale-kube-proxy/hostname"
+	lastUpdatedAnnotation = "tailscale-kube-proxy/last-updated"
+)
+
 // KubernetesStore implements ipn.StateStore by persisting state in a Kubernetes Secret.
 // It maintains an in-memory cache to avoid frequent API calls for reads.
 type KubernetesStore struct {
@@ -22,34 +46,95 @@ type KubernetesStore struct {
 	namespace string
 	secret    string
 	mu        sync.RWMutex
+
+	// labels and hostname identify the secret and are set whenever the state is written.
+	labels   map[string]string
+	hostname string
+
+	// warnSize is the state size above which a warning is logged, as writes start to
+	// fail once the secret exceeds the Kubernetes limit of 1MiB.
+	warnSize int
+	warned   bool
 }
 
 // NewKubernetesStore initializes a new store and loads existing state from the specified Secret.
 func NewKubernetesStore(namespace string, secret string, config *rest.Config) (ipn.StateStore, error) {
+	return NewKubernetesStoreWithSettings(viper.GetViper(), namespace, secret, config)
+}
+
+// NewKubernetesStoreWithSettings creates a store like NewKubernetesStore, but configured
+// by the given settings instead of the global configuration.
+func NewKubernetesStoreWithSettings(settings *viper.Viper, namespace string, secret string, config *rest.Config) (ipn.StateStore, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
+	labels, err := parseLabels(settings.GetStringSlice("state_secret_labels"))
+	if err != nil {
+		return nil, err
+	}
+
 	store := &KubernetesStore{
 		state:     make(map[ipn.StateKey][]byte),
 		client:    clientset,
 		namespace: namespace,
 		secret:    secret,
+		labels:    labels,
+		hostname:  settings.GetString("ts.hostname"),
+		warnSize:  settings.GetInt("state_size_warn_bytes"),
 	}
-	if err = store.initStore(); err != nil {
+	if err = store.loadState(settings.GetDuration("client_init_timeout")); err != nil {
 		return nil, fmt.Errorf("failed to initialize store: %w", err)
 	}
 
 	return store, nil
 }
 
+// loadState initializes the store, retrying with backoff until the timeout expires, so
+// that the proxy doesn't crash-loop if the API server is briefly unreachable at startup.
+// Errors that won't resolve on their own, like a missing secret, are returned at once.
+func (s *KubernetesStore) loadState(timeout time.Duration) error {
+	if timeout <= 0 {
+		return s.initStore(context.TODO())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	backoff := wait.Backoff{
+		Duration: 500 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      10 * time.Second,
+	}
+
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+		lastErr = s.initStore(ctx)
+		switch {
+		case lastErr == nil:
+			return true, nil
+		case apierrors.IsNotFound(lastErr), apierrors.IsForbidden(lastErr), apierrors.IsUnauthorized(lastErr):
+			return false, lastErr
+		default:
+			log.Printf("Warning: failed to load state from secret %s, retrying: %v", s.secret, lastErr)
+			return false, nil
+		}
+	})
+	if err != nil && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
 // initStore populates the in-memory cache from the Kubernetes Secret.
-func (s *KubernetesStore) initStore() error {
+func (s *KubernetesStore) initStore(ctx context.Context) error {
 	secret, err := s.client.
 		CoreV1().
 		Secrets(s.namespace).
-		Get(context.TODO(), s.secret, metav1.GetOptions{})
+		Get(ctx, s.secret, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -57,6 +142,7 @@ func (s *KubernetesStore) initStore() error {
 	for k, v := range secret.Data {
 		s.state[ipn.StateKey(k)] = v
 	}
+	s.updateSize()
 
 	return nil
 }
@@ -75,12 +161,28 @@ func (s *KubernetesStore) ReadState(id ipn.StateKey) ([]byte, error) {
 // WriteState updates the local cache and persists the change to Kubernetes.
 func (s *KubernetesStore) WriteState(id ipn.StateKey, bs []byte) error {
 	s.mu.Lock()
+	// Reject the write up front, as the API server would fail the patch with an error
+	// that does not point to the size of the state as the cause.
+	if size := s.size() - len(s.state[id]) + len(bs); size > maxSecretSize {
+		s.mu.Unlock()
+		stateWriteRejected.Inc()
+		return fmt.Errorf("%w: writing %s would grow secret %s to %d bytes, limit is %d bytes; remove unused keys from the secret or use a dedicated secret per proxy",
+			ErrStateTooLarge, id, s.secret, size, maxSecretSize)
+	}
 	s.state[id] = bs
+	s.updateSize()
 	s.mu.Unlock()
 
 	// Use a Strategic Merge Patch to update only the specific key in the Secret's data.
 	// This avoids race conditions and unnecessary overhead of fetching the full Secret first.
 	patchData := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": s.labels,
+			"annotations": map[string]string{
+				hostnameAnnotation:    s.hostname,
+				lastUpdatedAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
 		"data": map[string]string{
 			// Values in a Secret's 'data' field must be base64 encoded when using Patch.
 			string(id): base64.StdEncoding.EncodeToString(bs),
@@ -97,3 +199,47 @@ func (s *KubernetesStore) WriteState(id ipn.StateKey, bs []byte) error {
 	)
 	return err
 }
+
+// updateSize reports the size of the state and warns once it crosses the threshold.
+// It must be called with the lock held.
+func (s *KubernetesStore) updateSize() {
+	size := s.size()
+	stateSecretSize.Set(float64(size))
+
+	if s.warnSize <= 0 {
+		return
+	}
+	if size > s.warnSize && !s.warned {
+		log.Printf("Warning: Tailscale state in secret %s is %d bytes, exceeding %d bytes (Kubernetes limit is 1MiB)", s.secret, size, s.warnSize)
+	}
+	s.warned = size > s.warnSize
+}
+
+// parseLabels parses the labels of the state secret given as 'key=value' pairs and adds
+// the managed-by label unless it is overridden.
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := map[string]string{managedByLabel: "tailscale-kube-proxy"}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: state secret label %q must be of the form key=value", ErrInvalidConfig, pair)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: invalid state secret label key %q: %s", ErrInvalidConfig, key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("%w: invalid state secret label value %q: %s", ErrInvalidConfig, value, strings.Join(errs, "; "))
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// size returns the size of the state. It must be called with the lock held.
+func (s *KubernetesStore) size() int {
+	size := 0
+	for k, v := range s.state {
+		size += len(k) + len(v)
+	}
+	return size
+}
@@ -0,0 +1,163 @@
+package tailscale_test
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"tailscale.com/ipn"
+)
+
+// secretServer serves the state secret after failing the first requests with the
+// status, and counts the requests.
+func secretServer(t *testing.T, failures int32, status *apierrors.StatusError) (*testutil.FakeAPIServer, *atomic.Int32) {
+	t.Helper()
+
+	api := testutil.NewFakeAPIServer(t)
+	calls := new(atomic.Int32)
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) <= failures {
+			w.WriteHeader(int(status.ErrStatus.Code))
+			_ = json.NewEncoder(w).Encode(status.ErrStatus)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "tailscale-state", Namespace: "default"},
+			Data:       map[string][]byte{"_machinekey": []byte("privkey:1234")},
+		})
+	}))
+	return api, calls
+}
+
+func TestStoreRetriesLoadingState(t *testing.T) {
+	testutil.Configure(t, map[string]any{"client_init_timeout": 10 * time.Second})
+	api, calls := secretServer(t, 1, apierrors.NewServiceUnavailable("etcd is starting"))
+
+	store, err := tailscale.NewKubernetesStore("default", "tailscale-state", api.Config())
+	if err != nil {
+		t.Fatalf("NewKubernetesStore() error = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("API server received %d requests, want 2", got)
+	}
+	if state, err := store.ReadState("_machinekey"); err != nil || string(state) != "privkey:1234" {
+		t.Errorf("ReadState() = %q, %v, want the state of the secret", state, err)
+	}
+}
+
+func TestStoreDoesNotRetryMissingSecret(t *testing.T) {
+	testutil.Configure(t, map[string]any{"client_init_timeout": 10 * time.Second})
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "tailscale-state")
+	api, calls := secretServer(t, 1, notFound)
+
+	if _, err := tailscale.NewKubernetesStore("default", "tailscale-state", api.Config()); !apierrors.IsNotFound(err) {
+		t.Errorf("NewKubernetesStore() error = %v, want not found", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("API server received %d requests, want 1", got)
+	}
+}
+
+func TestStoreRejectsOversizedState(t *testing.T) {
+	testutil.Configure(t, nil)
+	api, calls := secretServer(t, 0, nil)
+	store, err := tailscale.NewKubernetesStore("default", "tailscale-state", api.Config())
+	if err != nil {
+		t.Fatalf("NewKubernetesStore() error = %v", err)
+	}
+
+	err = store.WriteState("profile-1234", make([]byte, 1<<20))
+	if !errors.Is(err, tailscale.ErrStateTooLarge) {
+		t.Fatalf("WriteState() error = %v, want %v", err, tailscale.ErrStateTooLarge)
+	}
+	if !strings.Contains(err.Error(), "secret tailscale-state") {
+		t.Errorf("WriteState() error = %v, want the name of the secret", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("API server received %d requests, want the write to be rejected before patching", got)
+	}
+	if _, err = store.ReadState("profile-1234"); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("ReadState() error = %v, want the rejected state not to be cached", err)
+	}
+}
+
+func TestStoreLabelsSecret(t *testing.T) {
+	testutil.Configure(t, map[string]any{
+		"ts.hostname":         "kube-api",
+		"state_secret_labels": []string{"team=platform", "app.kubernetes.io/managed-by=gitops"},
+	})
+	api, _ := secretServer(t, 0, nil)
+	store, err := tailscale.NewKubernetesStore("default", "tailscale-state", api.Config())
+	if err != nil {
+		t.Fatalf("NewKubernetesStore() error = %v", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		patches []corev1.Secret
+	)
+	api.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var patch corev1.Secret
+		if r.Method != http.MethodPatch || r.Header.Get("Content-Type") != "application/strategic-merge-patch+json" ||
+			json.NewDecoder(r.Body).Decode(&patch) != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		patches = append(patches, patch)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(corev1.Secret{TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}})
+	}))
+
+	// The first write adds the key to the secret, the second one updates it.
+	for _, state := range []string{"profile:1", "profile:2"} {
+		if err = store.WriteState("_current-profile", []byte(state)); err != nil {
+			t.Fatalf("WriteState() error = %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(patches) != 2 {
+		t.Fatalf("API server received %d patches, want 2", len(patches))
+	}
+	wantLabels := map[string]string{"team": "platform", "app.kubernetes.io/managed-by": "gitops"}
+	for i, patch := range patches {
+		if !maps.Equal(patch.Labels, wantLabels) {
+			t.Errorf("labels of patch %d = %v, want %v", i+1, patch.Labels, wantLabels)
+		}
+		if got := patch.Annotations["tailscale-kube-proxy/hostname"]; got != "kube-api" {
+			t.Errorf("hostname annotation of patch %d = %q, want kube-api", i+1, got)
+		}
+		if _, err := time.Parse(time.RFC3339, patch.Annotations["tailscale-kube-proxy/last-updated"]); err != nil {
+			t.Errorf("last-updated annotation of patch %d is invalid: %v", i+1, err)
+		}
+	}
+	if got := string(patches[1].Data["_current-profile"]); got != "profile:2" {
+		t.Errorf("patched state = %q, want profile:2", got)
+	}
+}
+
+func TestStoreInvalidLabel(t *testing.T) {
+	testutil.Configure(t, map[string]any{"state_secret_labels": []string{"team"}})
+	api, _ := secretServer(t, 0, nil)
+	if _, err := tailscale.NewKubernetesStore("default", "tailscale-state", api.Config()); !errors.Is(err, tailscale.ErrInvalidConfig) {
+		t.Errorf("NewKubernetesStore() error = %v, want an invalid label", err)
+	}
+}
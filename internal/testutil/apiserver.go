@@ -0,0 +1,103 @@
+package testutil
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+// EchoResponse is returned by the FakeAPIServer for every request, reflecting what the
+// proxy forwarded to it.
+type EchoResponse struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  url.Values  `json:"query"`
+	User   string      `json:"user"`
+	Groups []string    `json:"groups"`
+	Header http.Header `json:"header"`
+}
+
+// FakeAPIServer stands in for the Kubernetes API server. Unless a custom handler is set,
+// it echoes the received method, path and impersonation headers as EchoResponse.
+type FakeAPIServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handler  http.Handler
+	requests []*http.Request
+}
+
+// NewFakeAPIServer starts a fake API server, which is closed when the test finishes.
+func NewFakeAPIServer(t testing.TB) *FakeAPIServer {
+	t.Helper()
+
+	fake := NewUnstartedFakeAPIServer(t)
+	fake.Start()
+
+	return fake
+}
+
+// NewUnstartedFakeAPIServer creates a fake API server without starting it, e.g. to listen
+// on a Unix socket or serve TLS. It is closed when the test finishes.
+func NewUnstartedFakeAPIServer(t testing.TB) *FakeAPIServer {
+	t.Helper()
+
+	fake := new(FakeAPIServer)
+	fake.Server = httptest.NewUnstartedServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+// Config returns a client config pointing to the fake API server, which trusts its
+// certificate if it serves TLS.
+func (f *FakeAPIServer) Config() *rest.Config {
+	config := &rest.Config{Host: f.URL, BearerToken: "proxy-token"}
+	if cert := f.Certificate(); cert != nil {
+		config.CAData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return config
+}
+
+// SetHandler replaces the echo behavior with a custom handler, e.g. to return errors or
+// streaming responses.
+func (f *FakeAPIServer) SetHandler(handler http.Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = handler
+}
+
+// Requests returns all requests received so far.
+func (f *FakeAPIServer) Requests() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*http.Request(nil), f.requests...)
+}
+
+func (f *FakeAPIServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Clone(r.Context()))
+	handler := f.handler
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(EchoResponse{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		User:   r.Header.Get("Impersonate-User"),
+		Groups: r.Header.Values("Impersonate-Group"),
+		Header: r.Header,
+	})
+}
@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaults are the settings without a usable zero value, set to the defaults of the
+// command line flags.
+var defaults = map[string]any{
+	"username_source":             "login",
+	"unidentified_user":           "system:anonymous",
+	"allow_methods":               []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	"max_header_bytes":            http.DefaultMaxHeaderBytes,
+	"root_response":               "forward",
+	"client_authorization":        "strip",
+	"client_authorization_header": "X-Forwarded-Authorization",
+	"active_user_window":          15 * time.Minute,
+	"log_sample_rate":             1.0,
+}
+
+// Configure resets the global configuration to the flag defaults the proxy needs to
+// start and applies the settings on top. The configuration is reset again when the
+// test finishes, so tests using it must not run in parallel.
+func Configure(t testing.TB, settings map[string]any) {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	for key, value := range defaults {
+		viper.Set(key, value)
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+}
@@ -0,0 +1,20 @@
+// Package testutil provides fakes to test the proxy end to end without a Kubernetes
+// cluster or a tailnet: a fake API server echoing the requests it receives, and a static
+// identity resolver standing in for Tailscale's WhoIs.
+//
+// A typical test points the proxy at the fake API server and serves it with httptest:
+//
+//	testutil.Configure(t, map[string]any{"max_groups": 10})
+//	api := testutil.NewFakeAPIServer(t)
+//	resolver := testutil.NewStaticResolver()
+//	resolver.SetUser("127.0.0.1", &tailscale.UserProfile{
+//		UserProfile: tailcfg.UserProfile{LoginName: "alice@example.com"},
+//	})
+//
+//	p, err := proxy.NewKubeProxy(api.Config(), resolver)
+//	srv := httptest.NewServer(p)
+//
+// Requests sent to srv are answered with a testutil.EchoResponse. The proxy reads its
+// settings from viper, so tests call Configure first, which sets the ones without a
+// usable zero value, like "username_source" and "unidentified_user", to their defaults.
+package testutil
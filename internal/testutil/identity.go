@@ -0,0 +1,47 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"codeberg.org/0x2321/tailscale-kube-proxy/internal/tailscale"
+)
+
+// StaticResolver is a fake identity resolver returning fixed Tailscale users by the
+// IP address of the client, so that the proxy can be tested without a tailnet.
+type StaticResolver struct {
+	mu    sync.Mutex
+	users map[string]*tailscale.UserProfile
+}
+
+// NewStaticResolver creates a resolver without any known users.
+func NewStaticResolver() *StaticResolver {
+	return &StaticResolver{
+		users: make(map[string]*tailscale.UserProfile),
+	}
+}
+
+// SetUser registers the user for requests from the given IP address.
+func (s *StaticResolver) SetUser(ip string, user *tailscale.UserProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[ip] = user
+}
+
+// WhoIs returns the user registered for the IP of the remote address.
+func (s *StaticResolver) WhoIs(_ context.Context, remoteAddr string) (*tailscale.UserProfile, error) {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[ip]; ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("no user for %s", remoteAddr)
+}
@@ -0,0 +1,39 @@
+// Package version reports the version of the proxy from the build information embedded
+// by the Go toolchain.
+package version
+
+import (
+	"runtime/debug"
+)
+
+// Get returns the module version of the binary, or the VCS revision for builds from a
+// source checkout, which report their module version as "(devel)".
+func Get() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "devel"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}